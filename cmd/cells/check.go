@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pressly/cli"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/report"
+)
+
+// checkCommand reports the diagnostics in CEL files, for use in CI.
+var checkCommand = &cli.Command{
+	Name:      "check",
+	Usage:     "cells check [flags] [paths...]",
+	ShortHelp: "Report problems in CEL files, exiting nonzero if there are any",
+	Flags: cli.FlagsFunc(func(f *flag.FlagSet) {
+		f.String("format", "text", "output format: "+strings.Join(report.Formats, ", "))
+	}),
+	Exec: func(_ context.Context, s *cli.State) error {
+		format := cli.GetFlag[string](s, "format")
+		if !slices.Contains(report.Formats, format) {
+			return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(report.Formats, ", "))
+		}
+		paths := s.Args
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		reports, err := lsp.Check(paths)
+		if err != nil {
+			return err
+		}
+		if err := report.Write(s.Stdout, format, reports); err != nil {
+			return err
+		}
+		if report.Failed(reports) {
+			return errProblems
+		}
+		return nil
+	},
+}
+
+// errProblems is the error of a check that found problems.
+var errProblems = errors.New("problems found")
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	celpb "cel.dev/expr"
+	"github.com/pressly/cli"
+	"github.com/stefanvanburen/cells/internal/compiled"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/report"
+	"google.golang.org/protobuf/proto"
+)
+
+// compileFlags are the flags compile and decompile share, which select how
+// expressions are encoded.
+func compileFlags(f *flag.FlagSet) {
+	f.String("format", "binpb", "encoding: "+strings.Join(compiled.Formats, ", "))
+	f.Bool("parsed", false, "use cel.expr.ParsedExpr instead of cel.expr.CheckedExpr")
+	f.Bool("manifest", false, "use a cells.Manifest bundling many expressions")
+}
+
+// compileFormat returns the format the format flag selects.
+func compileFormat(s *cli.State) (string, error) {
+	format := cli.GetFlag[string](s, "format")
+	if !slices.Contains(compiled.Formats, format) {
+		return "", fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(compiled.Formats, ", "))
+	}
+	return format, nil
+}
+
+// compileCommand writes the checked ASTs of CEL files, so that services can
+// load them without parsing and checking them.
+var compileCommand = &cli.Command{
+	Name:      "compile",
+	Usage:     "cells compile [flags] [paths...]",
+	ShortHelp: "Parse and check .cel files, writing them as cel.expr protobufs",
+	Flags: cli.FlagsFunc(func(f *flag.FlagSet) {
+		compileFlags(f)
+		f.String("o", "", "write the output to `file` instead of standard output")
+	}),
+	Exec: func(_ context.Context, s *cli.State) error {
+		format, err := compileFormat(s)
+		if err != nil {
+			return err
+		}
+		paths := s.Args
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		exprs, reports, err := lsp.Compile(paths, cli.GetFlag[bool](s, "parsed"))
+		if err != nil {
+			return err
+		}
+		if len(reports) > 0 {
+			if err := report.Text(s.Stderr, reports); err != nil {
+				return err
+			}
+			return errProblems
+		}
+
+		var m proto.Message
+		switch {
+		case cli.GetFlag[bool](s, "manifest"):
+			if m, err = compiled.NewManifest(exprs); err != nil {
+				return err
+			}
+		case len(exprs) == 1:
+			m = compiled.Message(exprs[0])
+		case len(exprs) == 0:
+			return errors.New("no expressions found")
+		default:
+			return fmt.Errorf("found %d expressions, use --manifest to bundle them", len(exprs))
+		}
+		data, err := compiled.Marshal(m, format)
+		if err != nil {
+			return err
+		}
+		if out := cli.GetFlag[string](s, "o"); out != "" {
+			return os.WriteFile(out, data, 0o644)
+		}
+		_, err = s.Stdout.Write(data)
+		return err
+	},
+}
+
+// decompileCommand turns the output of compile back into source.
+var decompileCommand = &cli.Command{
+	Name:      "decompile",
+	Usage:     "cells decompile [flags] [file]",
+	ShortHelp: "Print the source of expressions written by cells compile",
+	Flags:     cli.FlagsFunc(compileFlags),
+	Exec: func(_ context.Context, s *cli.State) error {
+		format, err := compileFormat(s)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		switch len(s.Args) {
+		case 0:
+			data, err = io.ReadAll(s.Stdin)
+		case 1:
+			data, err = os.ReadFile(s.Args[0])
+		default:
+			return errors.New("expected at most one file")
+		}
+		if err != nil {
+			return err
+		}
+
+		if !cli.GetFlag[bool](s, "manifest") {
+			var m proto.Message = &celpb.CheckedExpr{}
+			if cli.GetFlag[bool](s, "parsed") {
+				m = &celpb.ParsedExpr{}
+			}
+			if err := compiled.Unmarshal(data, format, m); err != nil {
+				return err
+			}
+			source, err := lsp.Decompile(m)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(s.Stdout, source)
+			return err
+		}
+
+		exprs, err := compiled.ReadManifest(data, format)
+		if err != nil {
+			return err
+		}
+		// Each expression is headed by a comment naming it.
+		for i, expr := range exprs {
+			source, err := lsp.Decompile(compiled.Message(expr))
+			if err != nil {
+				return fmt.Errorf("%s: %w", expr.Path, err)
+			}
+			if i > 0 {
+				fmt.Fprintln(s.Stdout)
+			}
+			fmt.Fprintf(s.Stdout, "// %s\n%s", expr.Path, source)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pressly/cli"
+	"github.com/pressly/cli/flagtype"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/report"
+)
+
+// evalCommand evaluates an expression in the environment the language
+// server gives it.
+var evalCommand = &cli.Command{
+	Name:      "eval",
+	Usage:     "cells eval [flags] [expression]",
+	ShortHelp: "Evaluate an expression, or the expression in a file, and print its value",
+	Flags: cli.FlagsFunc(func(f *flag.FlagSet) {
+		f.String("f", "", "evaluate the expression in `file`")
+		f.String("input", "", "bind variables from a JSON or YAML `file` of inputs")
+		f.Var(flagtype.StringSlice(), "var", "bind a variable, as name=type:value or name=value (repeatable)")
+		f.Bool("json", false, "print the value and its type as JSON")
+	}),
+	Exec: func(ctx context.Context, s *cli.State) error {
+		ev := lsp.Evaluation{
+			Path:      cli.GetFlag[string](s, "f"),
+			InputPath: cli.GetFlag[string](s, "input"),
+			Vars:      cli.GetFlag[[]string](s, "var"),
+		}
+		switch {
+		case ev.Path != "" && len(s.Args) > 0:
+			return errors.New("can't give both -f and an expression")
+		case ev.Path != "":
+			content, err := os.ReadFile(ev.Path)
+			if err != nil {
+				return err
+			}
+			ev.Expr = string(content)
+		case len(s.Args) == 1:
+			ev.Expr = s.Args[0]
+		default:
+			return errors.New("expected an expression, or -f with a file")
+		}
+
+		result, reports, err := lsp.Eval(ctx, ev)
+		if err != nil {
+			return err
+		}
+		if len(reports) > 0 {
+			if err := report.Text(s.Stderr, reports); err != nil {
+				return err
+			}
+			return errProblems
+		}
+		if cli.GetFlag[bool](s, "json") {
+			enc := json.NewEncoder(s.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+		_, err = fmt.Fprintln(s.Stdout, result)
+		return err
+	},
+}
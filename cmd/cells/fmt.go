@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pressly/cli"
+	"github.com/stefanvanburen/cells/internal/diff"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+// fmtCommand formats .cel files, as gofmt does Go files.
+var fmtCommand = &cli.Command{
+	Name:      "fmt",
+	Usage:     "cells fmt [flags] [paths...]",
+	ShortHelp: "Format .cel files, or standard input if no paths are given",
+	Flags: cli.FlagsFunc(func(f *flag.FlagSet) {
+		f.Bool("w", false, "write the result to each file instead of standard output")
+		f.Bool("l", false, "list the files whose formatting differs")
+		f.Bool("d", false, "print diffs of the changes instead of the formatted files")
+	}),
+	Exec: func(_ context.Context, s *cli.State) error {
+		formatter, err := lsp.NewFormatter()
+		if err != nil {
+			return err
+		}
+		c := &fmtRun{
+			formatter: formatter,
+			state:     s,
+			write:     cli.GetFlag[bool](s, "w"),
+			list:      cli.GetFlag[bool](s, "l"),
+			diff:      cli.GetFlag[bool](s, "d"),
+		}
+
+		if len(s.Args) == 0 {
+			if c.write {
+				return errors.New("can't use -w with standard input")
+			}
+			content, err := io.ReadAll(s.Stdin)
+			if err != nil {
+				return err
+			}
+			c.format("", "<standard input>", content)
+		} else {
+			files, err := lsp.FindFiles(s.Args, func(path string) bool {
+				return filepath.Ext(path) == ".cel"
+			})
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					c.report(path, err)
+					continue
+				}
+				c.format(path, path, content)
+			}
+		}
+		if c.failed {
+			return errors.New("some files couldn't be formatted")
+		}
+		return nil
+	},
+}
+
+// fmtRun formats files for the fmt command, in the modes its flags select.
+type fmtRun struct {
+	formatter         *lsp.Formatter
+	state             *cli.State
+	write, list, diff bool
+	// failed is set when a file couldn't be formatted.
+	failed bool
+}
+
+// format formats content, the content of the file at path, which is named
+// name in output.
+func (c *fmtRun) format(path, name string, content []byte) {
+	formatted, err := c.formatter.Format(path, string(content))
+	if errors.Is(err, lsp.ErrSkipped) {
+		// Skipped files are left as they are, and reported so that
+		// they're not mistaken for formatted ones.
+		fmt.Fprintf(c.state.Stderr, "%s: %v\n", name, err)
+		formatted, err = string(content), nil
+	}
+	if err != nil {
+		c.report(name, err)
+		return
+	}
+
+	changed := formatted != string(content)
+	if c.list && changed {
+		fmt.Fprintln(c.state.Stdout, name)
+	}
+	if c.write && changed {
+		info, err := os.Stat(path)
+		if err == nil {
+			err = os.WriteFile(path, []byte(formatted), info.Mode().Perm())
+		}
+		if err != nil {
+			c.report(name, err)
+			return
+		}
+	}
+	if c.diff && changed {
+		fmt.Fprint(c.state.Stdout, diff.Unified(name+".orig", name, string(content), formatted))
+	}
+	if !c.list && !c.write && !c.diff {
+		fmt.Fprint(c.state.Stdout, formatted)
+	}
+}
+
+// report reports that the file named name couldn't be formatted.
+func (c *fmtRun) report(name string, err error) {
+	fmt.Fprintf(c.state.Stderr, "%s: %v\n", name, err)
+	c.failed = true
+}
@@ -6,7 +6,7 @@ import (
 	"os"
 
 	"github.com/pressly/cli"
-	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/server"
 )
 
 func main() {
@@ -22,10 +22,16 @@ func main() {
 			{
 				Name:      "serve",
 				ShortHelp: "Start the CEL language server (communicates over stdin/stdout)",
-				Exec: func(_ context.Context, _ *cli.State) error {
-					return lsp.Serve()
+				Exec: func(ctx context.Context, _ *cli.State) error {
+					return server.Serve(ctx, server.Stdio())
 				},
 			},
+			checkCommand,
+			fmtCommand,
+			evalCommand,
+			replCommand,
+			compileCommand,
+			decompileCommand,
 		},
 	}
 	if err := cli.ParseAndRun(context.Background(), root, os.Args[1:], nil); err != nil {
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/pressly/cli"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/report"
+	"golang.org/x/term"
+)
+
+const (
+	prompt             = "cel> "
+	continuationPrompt = "...> "
+	// maxHistory is the number of lines of input the history keeps.
+	maxHistory = 1000
+)
+
+// replCommand evaluates expressions and commands interactively.
+var replCommand = &cli.Command{
+	Name:      "repl",
+	Usage:     "cells repl",
+	ShortHelp: "Evaluate expressions interactively, in the environment of the current directory",
+	Exec: func(ctx context.Context, s *cli.State) error {
+		session, err := lsp.NewSession()
+		if err != nil {
+			return err
+		}
+		r := &repl{session: session, state: s}
+		if f, ok := s.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			return r.interactive(ctx, f)
+		}
+		return r.script(ctx)
+	},
+}
+
+// repl runs a session for the repl command.
+type repl struct {
+	session *lsp.Session
+	state   *cli.State
+}
+
+// interactive reads input from the terminal in, with line editing,
+// completion and history.
+func (r *repl) interactive(ctx context.Context, in *os.File) error {
+	fd := int(in.Fd())
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, saved)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, r.state.Stdout}, prompt)
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		_ = t.SetSize(width, height)
+	}
+	if path, err := historyPath(); err == nil {
+		t.History = loadHistory(path)
+	}
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		start, candidates := r.session.Complete(line, pos)
+		if len(candidates) == 0 {
+			return line, pos, true
+		}
+		if common := commonPrefix(candidates); len(common) > pos-start {
+			return line[:start] + common + line[pos:], start + len(common), true
+		}
+		// There's nothing to add, so the candidates are listed instead.
+		fmt.Fprintln(t, strings.Join(candidates, "  "))
+		return line, pos, true
+	}
+
+	var input strings.Builder
+	for {
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		input.WriteString(line)
+		input.WriteByte('\n')
+		if lsp.Incomplete(input.String()) {
+			t.SetPrompt(continuationPrompt)
+			continue
+		}
+		t.SetPrompt(prompt)
+
+		// Input runs with the terminal restored, so that an interrupt
+		// cancels a long evaluation rather than ending the session.
+		if err := term.Restore(fd, saved); err != nil {
+			return err
+		}
+		r.exec(ctx, input.String())
+		input.Reset()
+		if _, err := term.MakeRaw(fd); err != nil {
+			return err
+		}
+	}
+}
+
+// script reads input that isn't from a terminal, such as a file of
+// commands, without prompts.
+func (r *repl) script(ctx context.Context) error {
+	var input strings.Builder
+	scanner := bufio.NewScanner(r.state.Stdin)
+	for scanner.Scan() {
+		input.WriteString(scanner.Text())
+		input.WriteByte('\n')
+		if !lsp.Incomplete(input.String()) {
+			r.exec(ctx, input.String())
+			input.Reset()
+		}
+	}
+	if input.Len() > 0 {
+		r.exec(ctx, input.String())
+	}
+	return scanner.Err()
+}
+
+// exec runs input, printing its output, and the problems with it.
+func (r *repl) exec(ctx context.Context, input string) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	out, reports, err := r.session.Exec(ctx, strings.TrimSuffix(input, "\n"))
+	switch {
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(r.state.Stderr, "interrupted")
+	case err != nil:
+		fmt.Fprintf(r.state.Stderr, "error: %v\n", err)
+	case len(reports) > 0:
+		_ = report.Text(r.state.Stderr, reports)
+	case out != "":
+		fmt.Fprintln(r.state.Stdout, out)
+	}
+}
+
+// commonPrefix returns the longest prefix the strings of s share.
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, c := range s[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// historyPath returns the path of the file the history is kept in.
+func historyPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cells", "repl_history"), nil
+}
+
+// history is a term.History that's kept in a file, one line of input per
+// line, so that it persists across sessions.
+type history struct {
+	path    string
+	entries []string
+}
+
+// loadHistory returns the history kept in the file at path. A missing or
+// unreadable file gives an empty history.
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+		h.entries = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(h.entries) > maxHistory {
+			h.entries = h.entries[len(h.entries)-maxHistory:]
+			_ = os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600)
+		}
+	}
+	return h
+}
+
+// Add adds entry to the history and its file, unless it's empty or repeats
+// the last entry. Failing to write the file only loses the entry from later
+// sessions.
+func (h *history) Add(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = fmt.Fprintln(f, entry)
+}
+
+// Len returns the number of entries in the history.
+func (h *history) Len() int { return len(h.entries) }
+
+// At returns the entry idx entries before the most recent.
+func (h *history) At(idx int) string { return h.entries[len(h.entries)-1-idx] }
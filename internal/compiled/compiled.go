@@ -0,0 +1,167 @@
+// Package compiled encodes the expressions compiled by cells compile, on
+// their own or bundled in manifests, in the formats services load them from.
+package compiled
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	celpb "cel.dev/expr"
+	"github.com/bufbuild/protocompile"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Formats are the names of the formats Marshal and Unmarshal support: the
+// binary wire format, protojson and the text format.
+var Formats = []string{"binpb", "json", "textproto"}
+
+// Marshal encodes m in the named format.
+func Marshal(m proto.Message, format string) ([]byte, error) {
+	switch format {
+	case "binpb":
+		return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	case "json":
+		data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+		return append(data, '\n'), err
+	case "textproto":
+		return prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+	}
+	return nil, unknownFormat(format)
+}
+
+// Unmarshal decodes data, in the named format, into m.
+func Unmarshal(data []byte, format string, m proto.Message) error {
+	switch format {
+	case "binpb":
+		return proto.Unmarshal(data, m)
+	case "json":
+		return protojson.Unmarshal(data, m)
+	case "textproto":
+		return prototext.Unmarshal(data, m)
+	}
+	return unknownFormat(format)
+}
+
+func unknownFormat(format string) error {
+	return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Message returns the message of expr: its checked expression, or its parsed
+// one if it was only parsed.
+func Message(expr lsp.CompiledExpr) proto.Message {
+	if expr.Checked != nil {
+		return expr.Checked
+	}
+	return expr.Parsed
+}
+
+//go:embed manifest.proto
+var manifestProto string
+
+// manifestTypes returns the descriptor of the types declared by
+// manifest.proto, linked against the cel.expr types registered in this
+// binary, so that the expressions in manifests are theirs.
+var manifestTypes = sync.OnceValues(func() (protoreflect.FileDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+					"cells/manifest.proto": manifestProto,
+				}),
+			},
+			protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+				if err != nil {
+					return protocompile.SearchResult{}, err
+				}
+				return protocompile.SearchResult{Desc: fd}, nil
+			}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "cells/manifest.proto")
+	if err != nil {
+		return nil, err
+	}
+	return protodesc.NewFile(protodesc.ToFileDescriptorProto(files[0]), protoregistry.GlobalFiles)
+})
+
+// NewManifest returns a cells.Manifest of exprs, named by their paths.
+func NewManifest(exprs []lsp.CompiledExpr) (proto.Message, error) {
+	fd, err := manifestTypes()
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc := fd.Messages().ByName("Manifest")
+	exprDesc := fd.Messages().ByName("Expression")
+	manifest := dynamicpb.NewMessage(manifestDesc)
+	list := manifest.Mutable(manifestDesc.Fields().ByName("expressions")).List()
+	for _, expr := range exprs {
+		e := dynamicpb.NewMessage(exprDesc)
+		e.Set(exprDesc.Fields().ByName("name"), protoreflect.ValueOfString(filepath.ToSlash(expr.Path)))
+		if expr.Checked != nil {
+			e.Set(exprDesc.Fields().ByName("checked_expr"), protoreflect.ValueOfMessage(expr.Checked.ProtoReflect()))
+		} else {
+			e.Set(exprDesc.Fields().ByName("parsed_expr"), protoreflect.ValueOfMessage(expr.Parsed.ProtoReflect()))
+		}
+		list.Append(protoreflect.ValueOfMessage(e))
+	}
+	return manifest, nil
+}
+
+// ReadManifest returns the expressions of the cells.Manifest in data, which
+// is in the named format. Their paths are their names in the manifest.
+func ReadManifest(data []byte, format string) ([]lsp.CompiledExpr, error) {
+	fd, err := manifestTypes()
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc := fd.Messages().ByName("Manifest")
+	exprDesc := fd.Messages().ByName("Expression")
+	manifest := dynamicpb.NewMessage(manifestDesc)
+	if err := Unmarshal(data, format, manifest); err != nil {
+		return nil, err
+	}
+
+	var exprs []lsp.CompiledExpr
+	list := manifest.Get(manifestDesc.Fields().ByName("expressions")).List()
+	for i := range list.Len() {
+		e := list.Get(i).Message()
+		expr := lsp.CompiledExpr{Path: e.Get(exprDesc.Fields().ByName("name")).String()}
+		// The expressions are decoded as dynamic messages, and converted to
+		// the generated ones.
+		if f := exprDesc.Fields().ByName("checked_expr"); e.Has(f) {
+			expr.Checked = &celpb.CheckedExpr{}
+			err = convert(e.Get(f).Message().Interface(), expr.Checked)
+		} else if f := exprDesc.Fields().ByName("parsed_expr"); e.Has(f) {
+			expr.Parsed = &celpb.ParsedExpr{}
+			err = convert(e.Get(f).Message().Interface(), expr.Parsed)
+		} else {
+			err = fmt.Errorf("expression %q is empty", expr.Path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// convert converts src to dst, a message of the same type.
+func convert(src, dst proto.Message) error {
+	data, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, dst)
+}
@@ -0,0 +1,67 @@
+package compiled_test
+
+import (
+	"testing"
+
+	celpb "cel.dev/expr"
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/compiled"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"google.golang.org/protobuf/proto"
+)
+
+// compile returns the expressions compiled from the files in testdata.
+func compile(t *testing.T, parseOnly bool) []lsp.CompiledExpr {
+	t.Helper()
+	exprs, reports, err := lsp.Compile([]string{"testdata"}, parseOnly)
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+	return exprs
+}
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	checked := compile(t, false)[0].Checked
+	for _, format := range compiled.Formats {
+		data, err := compiled.Marshal(checked, format)
+		be.Err(t, err, nil)
+		got := &celpb.CheckedExpr{}
+		be.Err(t, compiled.Unmarshal(data, format, got), nil)
+		be.True(t, proto.Equal(got, checked))
+	}
+
+	_, err := compiled.Marshal(checked, "yaml")
+	be.Err(t, err, `unknown format "yaml", expected one of binpb, json, textproto`)
+}
+
+func TestManifest(t *testing.T) {
+	t.Parallel()
+
+	for _, parseOnly := range []bool{false, true} {
+		exprs := compile(t, parseOnly)
+		manifest, err := compiled.NewManifest(exprs)
+		be.Err(t, err, nil)
+		for _, format := range compiled.Formats {
+			data, err := compiled.Marshal(manifest, format)
+			be.Err(t, err, nil)
+			got, err := compiled.ReadManifest(data, format)
+			be.Err(t, err, nil)
+			be.Equal(t, len(got), len(exprs))
+			for i := range exprs {
+				be.Equal(t, got[i].Path, exprs[i].Path)
+				be.True(t, proto.Equal(compiled.Message(got[i]), compiled.Message(exprs[i])))
+			}
+		}
+	}
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := compiled.ReadManifest([]byte(`expressions: {name: "a.cel"}`), "textproto")
+	be.Err(t, err, `expression "a.cel" is empty`)
+
+	_, err = compiled.ReadManifest([]byte(`{"expressions": 1}`), "json")
+	be.True(t, err != nil)
+}
@@ -0,0 +1,68 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed_line",
+			old:  "1 +  2\n",
+			new:  "1 + 2\n",
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-1 +  2\n+1 + 2\n",
+		},
+		{
+			name: "context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate_hunks",
+			old:  "a\n" + strings.Repeat("x\n", 7) + "b\n",
+			new:  "A\n" + strings.Repeat("x\n", 7) + "B\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n x\n x\n x\n@@ -6,4 +6,4 @@\n x\n x\n x\n-b\n+B\n",
+		},
+		{
+			name: "insertion_at_start",
+			old:  "b\n",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1 +1,2 @@\n+a\n b\n",
+		},
+		{
+			name: "empty_old",
+			old:  "",
+			new:  "a\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "missing_newline",
+			old:  "a",
+			new:  "a\n",
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, diff.Unified("old", "new", tt.old, tt.new), tt.want)
+		})
+	}
+}
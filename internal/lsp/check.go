@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// A Report holds the diagnostics of a file checked by Check.
+type Report struct {
+	// Path is the path of the file, as it was given to Check or found in
+	// a directory given to it.
+	Path string
+	// Content is the content of the file, which the ranges of the
+	// diagnostics are in.
+	Content     string
+	Diagnostics []protocol.Diagnostic
+}
+
+// Check returns reports of the diagnostics in the files at paths, for the
+// files that have any. They're the diagnostics the server publishes for each
+// file, with environments resolved from config files and profiles the same
+// way. Directories are searched for .cel files and files that embed CEL,
+// such as .proto and Markdown files.
+func Check(paths []string, opts ...Option) ([]Report, error) {
+	s, err := newServer(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := FindFiles(paths, func(path string) bool {
+		_, ok := embedders[filepath.Ext(path)]
+		return ok || filepath.Ext(path) == ".cel"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		content := string(data)
+		if diagnostics := s.documentDiagnostics(abs, content); len(diagnostics) > 0 {
+			reports = append(reports, Report{Path: path, Content: content, Diagnostics: diagnostics})
+		}
+	}
+	return reports, nil
+}
+
+// FindFiles returns the files at paths, and those in the directories at
+// paths that match, skipping hidden directories. Each file is returned once,
+// in the order they're found. A file at paths that doesn't match is an
+// error, rather than being skipped, since it was named on purpose.
+func FindFiles(paths []string, match func(path string) bool) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if !match(path) {
+				return nil, fmt.Errorf("%s: unsupported file type", path)
+			}
+			add(path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if match(p) {
+				add(p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,59 @@
+package lsp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	reports, err := lsp.Check([]string{"testdata/check"})
+	be.Err(t, err, nil)
+
+	// Files without problems aren't reported, and hidden directories aren't
+	// walked.
+	var paths []string
+	for _, r := range reports {
+		paths = append(paths, r.Path)
+	}
+	be.Equal(t, paths, []string{"testdata/check/nested/bad.cel", "testdata/check/notes.md"})
+
+	be.Equal(t, len(reports[0].Diagnostics), 1)
+	be.True(t, strings.Contains(reports[0].Diagnostics[0].Message, "Syntax error"))
+	be.Equal(t, reports[0].Content, "size('hello') +\n")
+
+	// The expression in the Markdown file is reported at its position in
+	// the file.
+	be.Equal(t, len(reports[1].Diagnostics), 1)
+	be.Equal(t, reports[1].Diagnostics[0].Range, lineRange(3, 0, 14))
+}
+
+func TestCheckFiles(t *testing.T) {
+	t.Parallel()
+
+	// Files are checked whatever directory they're in, and only once.
+	reports, err := lsp.Check([]string{"testdata/check/.hidden/bad.cel", "testdata/check/ok.cel", "testdata/check/.hidden/bad.cel"})
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+	be.Equal(t, reports[0].Path, "testdata/check/.hidden/bad.cel")
+
+	_, err = lsp.Check([]string{"testdata/check/missing.cel"})
+	be.True(t, err != nil)
+
+	// Files named explicitly must be ones Check understands.
+	_, err = lsp.Check([]string{"testdata/check/ok.cel", "../../go.mod"})
+	be.Err(t, err, "../../go.mod: unsupported file type")
+}
+
+func TestCheckConfig(t *testing.T) {
+	t.Parallel()
+
+	// Expressions are checked in the environment of their config file.
+	reports, err := lsp.Check([]string{"testdata/config/variables.cel", "testdata/config/container.cel"})
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+}
@@ -0,0 +1,146 @@
+package lsp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	celpb "cel.dev/expr"
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+// A CompiledExpr is an expression compiled by Compile.
+type CompiledExpr struct {
+	// Path is the path of the file the expression is in, as it was given to
+	// Compile or found in a directory given to it.
+	Path string
+	// Checked is the checked expression, unless Compile only parsed it.
+	Checked *celpb.CheckedExpr
+	// Parsed is the parsed expression, if Compile only parsed it.
+	Parsed *celpb.ParsedExpr
+}
+
+// Compile parses and checks the .cel files at paths, and those in the
+// directories at paths, in the environment the server gives each. With
+// parseOnly, they're only parsed, and the environment's declarations don't
+// apply. Each expression's source info is located at the path of its file.
+// Files of definitions hold several expressions, which can't be compiled as
+// one, and are reported as problems. If any file has problems, nothing is
+// compiled, and reports of the problems are returned instead.
+func Compile(paths []string, parseOnly bool, opts ...Option) ([]CompiledExpr, []Report, error) {
+	s, err := newServer(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := FindFiles(paths, func(path string) bool {
+		return filepath.Ext(path) == ".cel"
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var exprs []CompiledExpr
+	var reports []Report
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		content := string(data)
+		if m := definitionPattern.FindStringSubmatchIndex(content); m != nil {
+			reports = append(reports, Report{Path: path, Content: content, Diagnostics: []protocol.Diagnostic{
+				hostDiagnostic(content, m[2], m[3], "files of definitions can't be compiled"),
+			}})
+			continue
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		env := s.documentEnv(abs, content)
+
+		if parseOnly {
+			ast, iss := env.Parse(content)
+			if iss.Err() != nil {
+				reports = append(reports, Report{Path: path, Content: content, Diagnostics: issuesToDiagnostics(content, iss, protocol.SeverityError)})
+				continue
+			}
+			alpha, err := cel.AstToParsedExpr(ast)
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed := &celpb.ParsedExpr{}
+			if err := convertProto(alpha, parsed); err != nil {
+				return nil, nil, err
+			}
+			parsed.GetSourceInfo().Location = path
+			exprs = append(exprs, CompiledExpr{Path: path, Parsed: parsed})
+			continue
+		}
+
+		if diagnostics := computeDiagnostics(content, env); len(diagnostics) > 0 {
+			reports = append(reports, Report{Path: path, Content: content, Diagnostics: diagnostics})
+			continue
+		}
+		ast, iss := env.Compile(content)
+		if iss.Err() != nil {
+			return nil, nil, iss.Err()
+		}
+		alpha, err := cel.AstToCheckedExpr(ast)
+		if err != nil {
+			return nil, nil, err
+		}
+		checked := &celpb.CheckedExpr{}
+		if err := convertProto(alpha, checked); err != nil {
+			return nil, nil, err
+		}
+		checked.GetSourceInfo().Location = path
+		exprs = append(exprs, CompiledExpr{Path: path, Checked: checked})
+	}
+	if len(reports) > 0 {
+		return nil, reports, nil
+	}
+	return exprs, nil, nil
+}
+
+// Decompile returns the source of expr, a *celpb.CheckedExpr or
+// *celpb.ParsedExpr, formatted as the formatter formats .cel files.
+func Decompile(expr proto.Message) (string, error) {
+	var ast *cel.Ast
+	switch expr := expr.(type) {
+	case *celpb.CheckedExpr:
+		alpha := &exprpb.CheckedExpr{}
+		if err := convertProto(expr, alpha); err != nil {
+			return "", err
+		}
+		ast = cel.CheckedExprToAst(alpha)
+	case *celpb.ParsedExpr:
+		alpha := &exprpb.ParsedExpr{}
+		if err := convertProto(expr, alpha); err != nil {
+			return "", err
+		}
+		ast = cel.ParsedExprToAst(alpha)
+	default:
+		return "", fmt.Errorf("can't decompile %s", expr.ProtoReflect().Descriptor().FullName())
+	}
+	source, err := cel.AstToString(ast)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(source) + "\n", nil
+}
+
+// convertProto converts src to dst, a message with the same wire format.
+// cel-go converts ASTs to and from the google.api.expr.v1alpha1 messages,
+// whose wire format the cel.expr ones share.
+func convertProto(src, dst proto.Message) error {
+	data, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, dst)
+}
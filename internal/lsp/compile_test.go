@@ -0,0 +1,65 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	// The directives of each file apply to it.
+	exprs, reports, err := lsp.Compile([]string{"testdata/compile/limit.cel", "testdata/compile/ternary.cel"}, false)
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+	be.Equal(t, len(exprs), 2)
+	be.Equal(t, exprs[0].Path, "testdata/compile/limit.cel")
+	be.True(t, exprs[0].Checked != nil && exprs[0].Parsed == nil)
+	be.True(t, len(exprs[0].Checked.GetTypeMap()) > 0)
+	be.Equal(t, exprs[0].Checked.GetSourceInfo().GetLocation(), "testdata/compile/limit.cel")
+
+	for i, want := range []string{
+		"[1, 2, 3].filter(x, x < limit).size() > 0\n",
+		`"hello".startsWith("h") ? {"a": 1} : {}` + "\n",
+	} {
+		source, err := lsp.Decompile(exprs[i].Checked)
+		be.Err(t, err, nil)
+		be.Equal(t, source, want)
+	}
+}
+
+func TestCompileParsed(t *testing.T) {
+	t.Parallel()
+
+	// Parsing alone doesn't need declarations.
+	exprs, reports, err := lsp.Compile([]string{"testdata/compile/invalid"}, true)
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+	be.Equal(t, len(exprs), 1)
+	be.True(t, exprs[0].Parsed != nil && exprs[0].Checked == nil)
+	be.Equal(t, exprs[0].Parsed.GetSourceInfo().GetLocation(), "testdata/compile/invalid/undeclared.cel")
+
+	source, err := lsp.Decompile(exprs[0].Parsed)
+	be.Err(t, err, nil)
+	be.Equal(t, source, "undeclared + 1\n")
+}
+
+func TestCompileReports(t *testing.T) {
+	t.Parallel()
+
+	// Nothing is compiled if any file has problems, and files of
+	// definitions can't be compiled.
+	exprs, reports, err := lsp.Compile([]string{"testdata/compile"}, false)
+	be.Err(t, err, nil)
+	be.Equal(t, len(exprs), 0)
+	be.Equal(t, len(reports), 2)
+	be.Equal(t, reports[0].Path, "testdata/compile/definitions.cel")
+	be.Equal(t, diagMessages(reports[0].Diagnostics), []string{"files of definitions can't be compiled"})
+	be.Equal(t, reports[1].Path, "testdata/compile/invalid/undeclared.cel")
+
+	_, reports, err = lsp.Compile([]string{"testdata/compile/definitions.cel"}, true)
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+}
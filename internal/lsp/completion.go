@@ -29,10 +29,28 @@ func (s *server) completion(req *jsonrpc2.Request) (any, error) {
 	f := s.files[params.TextDocument.URI]
 	s.mu.Unlock()
 
+	celEnv := s.envFor(params.TextDocument.URI)
+	pos := params.Position
+	if f != nil {
+		if exprs, ok := s.embedded(f); ok {
+			// Complete within the embedded expression at the cursor, if any.
+			e, exprPos, ok := embeddedAt(exprs, f.content, pos)
+			if !ok {
+				return &protocol.CompletionList{Items: []protocol.CompletionItem{}}, nil
+			}
+			f, celEnv, pos = e.file, e.env, exprPos
+		}
+	}
+
 	// Dot context: member completions filtered by receiver type.
-	if f != nil && isDotContext(f.content, params.Position) {
-		receiverType := receiverTypeAtDot(f.content, params.Position, s.celEnv)
-		items := memberCompletionItems(s.celEnv, receiverType)
+	if f != nil && isDotContext(f.content, pos) {
+		// Namespaced functions, e.g. after "math.", are offered on their own.
+		items := namespaceCompletionItems(celEnv, namespaceAtDot(f.content, pos))
+		if len(items) == 0 {
+			receiverType := receiverTypeAtDot(f.content, pos, celEnv)
+			items = fieldCompletionItems(celEnv, receiverType)
+			items = append(items, memberCompletionItems(celEnv, receiverType)...)
+		}
 		return &protocol.CompletionList{
 			IsIncomplete: false,
 			Items:        items,
@@ -42,13 +60,14 @@ func (s *server) completion(req *jsonrpc2.Request) (any, error) {
 	// Check for operator context to filter by expected type.
 	var expectedType *types.Type
 	if f != nil {
-		expectedType = expectedTypeAfterOperator(f.content, params.Position, s.celEnv)
+		expectedType = expectedTypeAfterOperator(f.content, pos, celEnv)
 	}
 
 	var items []protocol.CompletionItem
-	items = append(items, globalCompletionItems(s.celEnv, expectedType)...)
-	items = append(items, macroCompletionItems(s.celEnv, expectedType)...)
-	items = append(items, keywordCompletionItems(s.celEnv, expectedType)...)
+	items = append(items, variableCompletionItems(celEnv, expectedType)...)
+	items = append(items, globalCompletionItems(celEnv, expectedType)...)
+	items = append(items, macroCompletionItems(celEnv, expectedType)...)
+	items = append(items, keywordCompletionItems(celEnv, expectedType)...)
 	return &protocol.CompletionList{
 		IsIncomplete: false,
 		Items:        items,
@@ -86,6 +105,71 @@ func receiverTypeAtDot(content string, pos protocol.Position, celEnv *cel.Env) *
 	return ast.OutputType()
 }
 
+// namespaceAtDot returns the dotted identifier before the dot at the given
+// cursor position, such as "math" in "x + math.", or "" if there isn't one.
+func namespaceAtDot(content string, pos protocol.Position) string {
+	offset := lineColToByteOffset(content, pos.Line, pos.Character)
+	if offset <= 0 || offset > len(content) {
+		return ""
+	}
+	end := offset - 1
+	start := end
+	for start > 0 && (isIdentifierChar(rune(content[start-1])) || content[start-1] == '.') {
+		start--
+	}
+	ns := content[start:end]
+	if ns == "" || ns[0] == '.' || ('0' <= ns[0] && ns[0] <= '9') {
+		return ""
+	}
+	return ns
+}
+
+// namespaceCompletionItems returns completion items for the functions and
+// macros in namespace ns, labeled without the namespace.
+func namespaceCompletionItems(celEnv *cel.Env, ns string) []protocol.CompletionItem {
+	if ns == "" {
+		return nil
+	}
+	prefix := ns + "."
+	snippet := protocol.SnippetTextFormat
+	var items []protocol.CompletionItem
+	for name, fn := range celEnv.Functions() {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok || strings.Contains(rest, ".") || isOperatorOrInternal(rest) {
+			continue
+		}
+		items = append(items, protocol.CompletionItem{
+			Label:            rest,
+			Kind:             protocol.FunctionCompletion,
+			Detail:           globalFunctionDetail(fn),
+			Documentation:    docString(fn.Description()),
+			InsertText:       rest + "($1)",
+			InsertTextFormat: &snippet,
+		})
+	}
+	seen := make(map[string]bool)
+	for _, m := range celEnv.Macros() {
+		name := m.Function()
+		nm, ok := namespacedMacros[name]
+		if !ok || nm.namespace != ns || seen[name] {
+			continue
+		}
+		seen[name] = true
+		items = append(items, protocol.CompletionItem{
+			Label:            name,
+			Kind:             protocol.FunctionCompletion,
+			Detail:           "macro",
+			Documentation:    docString(nm.description),
+			InsertText:       name + "($1)",
+			InsertTextFormat: &snippet,
+		})
+	}
+	slices.SortFunc(items, func(a, b protocol.CompletionItem) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+	return items
+}
+
 // binaryOperatorSymbols returns a map from display symbol (e.g. "&&") to
 // cel-go internal name (e.g. "_&&_"), derived from the environment's functions
 // and operators.FindReverseBinaryOperator.
@@ -199,6 +283,37 @@ func typeMatches(expectedType, resultType *types.Type) bool {
 	return expectedType.IsAssignableType(resultType)
 }
 
+// fieldCompletionItems returns completion items for the fields of
+// receiverType, if it is a message type.
+func fieldCompletionItems(celEnv *cel.Env, receiverType *types.Type) []protocol.CompletionItem {
+	if receiverType == nil || receiverType.Kind() != types.StructKind {
+		return nil
+	}
+	typeName := receiverType.TypeName()
+	provider := celEnv.CELTypeProvider()
+	names, ok := provider.FindStructFieldNames(typeName)
+	if !ok {
+		return nil
+	}
+	var items []protocol.CompletionItem
+	for _, name := range names {
+		fieldType, ok := provider.FindStructFieldType(typeName, name)
+		if !ok {
+			continue
+		}
+		items = append(items, protocol.CompletionItem{
+			Label:         name,
+			Kind:          protocol.FieldCompletion,
+			Detail:        fieldType.Type.String(),
+			Documentation: docString(fieldComment(celEnv, typeName, name)),
+		})
+	}
+	slices.SortFunc(items, func(a, b protocol.CompletionItem) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+	return items
+}
+
 // memberCompletionItems returns completion items for member functions.
 // If receiverType is non-nil, only functions whose receiver matches that type
 // are returned. If nil, all member functions are returned.
@@ -242,6 +357,28 @@ func memberCompletionItems(celEnv *cel.Env, receiverType *types.Type) []protocol
 	return items
 }
 
+// variableCompletionItems returns completion items for the variables declared
+// in the environment. If expectedType is non-nil, only variables with a
+// compatible type are included.
+func variableCompletionItems(celEnv *cel.Env, expectedType *types.Type) []protocol.CompletionItem {
+	var items []protocol.CompletionItem
+	for _, v := range celEnv.Variables() {
+		if !typeMatches(expectedType, v.Type()) {
+			continue
+		}
+		items = append(items, protocol.CompletionItem{
+			Label:         v.Name(),
+			Kind:          protocol.VariableCompletion,
+			Detail:        v.Type().String(),
+			Documentation: docString(v.Description()),
+		})
+	}
+	slices.SortFunc(items, func(a, b protocol.CompletionItem) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+	return items
+}
+
 // globalCompletionItems returns completion items for global functions and type
 // conversions. If expectedType is non-nil, only functions that can return a
 // compatible type are included.
@@ -295,12 +432,18 @@ func macroCompletionItems(celEnv *cel.Env, _ *types.Type) []protocol.CompletionI
 		}
 		seen[name] = true
 
+		// Namespaced macros are only expanded when called on their
+		// namespace, so that's how they're inserted.
+		label := name
+		if nm, ok := namespacedMacros[name]; ok {
+			label = nm.namespace + "." + name
+		}
 		snippet := protocol.SnippetTextFormat
 		items = append(items, protocol.CompletionItem{
-			Label:            name,
+			Label:            label,
 			Kind:             protocol.FunctionCompletion,
 			Detail:           "macro",
-			InsertText:       name + "($1)",
+			InsertText:       label + "($1)",
 			InsertTextFormat: &snippet,
 		})
 	}
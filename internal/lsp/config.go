@@ -0,0 +1,404 @@
+package lsp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/env"
+	"github.com/google/cel-go/ext"
+	"go.yaml.in/yaml/v3"
+)
+
+// configFileName is the name of the project configuration file. It is
+// discovered in a document's directory or any of its ancestors.
+const configFileName = ".cells.yaml"
+
+// config is the contents of a project configuration file, which declares the
+// CEL environment used for documents beneath it.
+type config struct {
+	// Profile is the name of a built-in environment to start from, such as
+	// "kubernetes-admission".
+	Profile string `yaml:"profile,omitempty"`
+	// Kubernetes configures the Kubernetes profiles.
+	Kubernetes *kubernetesConfig `yaml:"kubernetes,omitempty"`
+	// Protovalidate configures the protovalidate profile.
+	Protovalidate *protovalidateConfig `yaml:"protovalidate,omitempty"`
+	// Container is the namespace used to resolve unqualified names.
+	Container string `yaml:"container,omitempty"`
+	// Abbreviations are qualified names that may be referenced by their
+	// last segment alone.
+	Abbreviations []string `yaml:"abbreviations,omitempty"`
+	// Variables are declared in the environment in order.
+	Variables []configVariable `yaml:"variables,omitempty"`
+	// DescriptorSets are paths, relative to the config file, of
+	// FileDescriptorSet files in the binary or JSON encoding. Their message
+	// and enum types are available to expressions.
+	DescriptorSets []string `yaml:"descriptor_sets,omitempty"`
+	// ProtoPaths are directories, relative to the config file, of .proto
+	// files that are compiled to provide message and enum types. Each is
+	// also an import path for the others.
+	ProtoPaths []string `yaml:"proto_paths,omitempty"`
+	// Extensions are cel-go extension libraries to enable.
+	Extensions []extension `yaml:"extensions,omitempty"`
+	// Environments are paths, relative to the config file, of environment
+	// files in cel-go's native YAML format (see the common/env package).
+	// They are applied in order, before the declarations above.
+	Environments []string `yaml:"environments,omitempty"`
+	// Embedded locates CEL expressions in JSON and YAML files beneath the
+	// config file.
+	Embedded []embeddedConfig `yaml:"embedded,omitempty"`
+}
+
+// embeddedConfig locates the CEL expressions in a set of JSON or YAML
+// files, e.g.
+//
+//	embedded:
+//	  - files: "rbac/**/*.json"
+//	    selectors:
+//	      - path: $.policies.*.condition
+//	        profile: kubernetes-admission
+type embeddedConfig struct {
+	// Files is a glob, relative to the config file, of the files the
+	// selectors apply to. A "**" segment matches any number of directories.
+	Files     string           `yaml:"files"`
+	Selectors []selectorConfig `yaml:"selectors"`
+}
+
+// selectorConfig selects the fields holding CEL expressions with a
+// JSONPath-like path, and optionally the profile they're analyzed with in
+// place of the config's own.
+type selectorConfig struct {
+	Path    string `yaml:"path"`
+	Profile string `yaml:"profile,omitempty"`
+}
+
+// configVariable declares a single variable, e.g.
+//
+//	variables:
+//	  - name: request
+//	    type: map(string, dyn)
+type configVariable struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// parseConfig decodes a configuration file, rejecting unknown fields so that
+// typos are reported rather than silently ignored.
+func parseConfig(data []byte) (*config, error) {
+	var cfg config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if cfg.Profile != "" {
+		if err := validateProfile(cfg.Profile); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range cfg.Extensions {
+		if err := e.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for i, v := range cfg.Variables {
+		if v.Name == "" {
+			return nil, fmt.Errorf("variables[%d]: missing name", i)
+		}
+		if v.Type == "" {
+			return nil, fmt.Errorf("variable %q: missing type", v.Name)
+		}
+	}
+	for i, e := range cfg.Embedded {
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("embedded[%d]: %w", i, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// envFile is an environment file in cel-go's native format, referenced from
+// a config file.
+type envFile struct {
+	path   string
+	config *env.Config
+}
+
+// loadEnvironments reads and decodes the environment files referenced by the
+// config, resolving relative paths against dir.
+func (c *config) loadEnvironments(dir string, read func(string) ([]byte, error)) ([]envFile, []configError) {
+	var (
+		files []envFile
+		errs  []configError
+	)
+	for _, name := range c.Environments {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := read(path)
+		if err != nil {
+			errs = append(errs, referenceError(dir, name, fmt.Sprintf("environment %q: %v", name, err), read))
+			continue
+		}
+		envConfig, err := parseEnvConfig(data)
+		if err != nil {
+			errs = append(errs, configErrors(path, data, err)...)
+			continue
+		}
+		files = append(files, envFile{path: path, config: envConfig})
+	}
+	return files, errs
+}
+
+// parseEnvConfig decodes and validates an environment file in cel-go's
+// native format.
+func parseEnvConfig(data []byte) (*env.Config, error) {
+	envConfig := env.NewConfig("")
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(envConfig); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := envConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return envConfig, nil
+}
+
+// subsetsStdLib reports whether any of the environment files subsets the
+// standard library, which requires starting from an environment without it.
+// Files that don't declare a subset include the whole standard library, so a
+// file declaring a subset must come first.
+func subsetsStdLib(files []envFile) bool {
+	for _, f := range files {
+		if f.config.StdLib != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// envOptions returns the CEL environment options declared by the config and
+// its environment files.
+func (c *config) envOptions(files []envFile) []cel.EnvOption {
+	var opts []cel.EnvOption
+	for _, f := range files {
+		opts = append(opts, fromEnvFile(f))
+	}
+	opts = append(opts, extensionOptions(c.Extensions)...)
+	if c.Container != "" {
+		opts = append(opts, cel.Container(c.Container))
+	}
+	if len(c.Abbreviations) > 0 {
+		opts = append(opts, cel.Abbrevs(c.Abbreviations...))
+	}
+	for _, v := range c.Variables {
+		opts = append(opts, declareVariable(v.Name, v.Type, v.Description))
+	}
+	return opts
+}
+
+// fromEnvFile returns an option applying an environment file. Errors are
+// wrapped in a fileError so they can be reported against the file.
+func fromEnvFile(f envFile) cel.EnvOption {
+	opt := cel.FromConfig(f.config, ext.ExtensionOptionFactory)
+	return func(e *cel.Env) (*cel.Env, error) {
+		e, err := opt(e)
+		if err != nil {
+			return nil, &fileError{path: f.path, err: err}
+		}
+		return e, nil
+	}
+}
+
+// declareVariable returns an option declaring a variable whose type is given
+// in CEL syntax. The type is resolved against the environment's type provider
+// when the option is applied, so message types registered by earlier options
+// may be referenced.
+func declareVariable(name, typeName, doc string) cel.EnvOption {
+	return func(e *cel.Env) (*cel.Env, error) {
+		t, err := parseCELType(typeName, e)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		if doc != "" {
+			return cel.VariableWithDoc(name, t, doc)(e)
+		}
+		return cel.Variable(name, t)(e)
+	}
+}
+
+// parseCELType parses a type written in CEL syntax, such as "int",
+// "list(string)" or "map(string, google.protobuf.Struct)", and resolves it
+// against the environment's type provider.
+func parseCELType(s string, celEnv *cel.Env) (*cel.Type, error) {
+	p := &typeParser{input: s}
+	td, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid type %q: %w", s, err)
+	}
+	t, err := td.AsCELType(celEnv.CELTypeProvider())
+	if err != nil {
+		return nil, fmt.Errorf("invalid type %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// typeParser is a small recursive-descent parser for CEL type names.
+//
+//	type   = name [ "(" type { "," type } ")" ]
+//	name   = ident { "." ident }
+type typeParser struct {
+	input string
+	pos   int
+}
+
+func (p *typeParser) parse() (*env.TypeDesc, error) {
+	td, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos:], p.pos)
+	}
+	return td, nil
+}
+
+func (p *typeParser) parseType() (*env.TypeDesc, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isTypeNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	name := strings.TrimPrefix(p.input[start:p.pos], ".")
+	if name == "" {
+		if p.pos >= len(p.input) {
+			return nil, errors.New("unexpected end of type")
+		}
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos], p.pos)
+	}
+	td := env.NewTypeDesc(name)
+
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		return td, nil
+	}
+	p.pos++
+	for {
+		param, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		td.Params = append(td.Params, param)
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, errors.New("missing ')'")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return td, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos], p.pos)
+		}
+	}
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func isTypeNameChar(c byte) bool {
+	return c == '_' || c == '.' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// configError is an error in a config file or a file it references.
+type configError struct {
+	path string
+	// line is the 0-based line the error applies to, or -1 if unknown.
+	line int
+	msg  string
+}
+
+// fileError associates an error with the file that caused it.
+type fileError struct {
+	path string
+	err  error
+}
+
+func (e *fileError) Error() string { return e.path + ": " + e.err.Error() }
+func (e *fileError) Unwrap() error { return e.err }
+
+// yamlLineRe matches the line numbers in go-yaml error messages, e.g.
+// "yaml: line 3: did not find expected key".
+var yamlLineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// quotedRe matches double-quoted names in error messages, e.g. the variable
+// name in `variable "x": invalid type`.
+var quotedRe = regexp.MustCompile(`"([^"]+)"`)
+
+// configErrors converts an error from loading the file at path into
+// configErrors, locating the line each one applies to where possible.
+func configErrors(path string, data []byte, err error) []configError {
+	var msgs []string
+	if typeErr, ok := errors.AsType[*yaml.TypeError](err); ok {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	errs := make([]configError, 0, len(msgs))
+	for _, msg := range msgs {
+		msg = strings.TrimPrefix(msg, "yaml: unmarshal errors:\n")
+		e := configError{path: path, line: -1, msg: msg}
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			e.line = line - 1
+			e.msg = msg[len(m[0]):]
+		} else {
+			// Errors from cel-go don't carry positions, but they usually
+			// quote the name of the offending declaration.
+			for _, m := range quotedRe.FindAllStringSubmatch(msg, -1) {
+				if line := lineContaining(string(data), m[1]); line >= 0 {
+					e.line = line
+					break
+				}
+			}
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// referenceError returns an error about name, a file referenced by the
+// config file in dir, located on the line of the config that references it.
+func referenceError(dir, name, msg string, read func(string) ([]byte, error)) configError {
+	configPath := filepath.Join(dir, configFileName)
+	content, _ := read(configPath)
+	return configError{path: configPath, line: lineContaining(string(content), name), msg: msg}
+}
+
+// lineContaining returns the 0-based index of the first line of content
+// containing s, or -1.
+func lineContaining(content, s string) int {
+	for i, line := range strings.Split(content, "\n") {
+		if strings.Contains(line, s) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,262 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"go.yaml.in/yaml/v3"
+)
+
+// validate checks the glob and selectors of an embedded entry.
+func (e embeddedConfig) validate() error {
+	if e.Files == "" {
+		return errors.New("missing files")
+	}
+	for _, segment := range strings.Split(e.Files, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("files %q: %w", e.Files, err)
+		}
+	}
+	if len(e.Selectors) == 0 {
+		return errors.New("missing selectors")
+	}
+	for i, sel := range e.Selectors {
+		if sel.Path == "" {
+			return fmt.Errorf("selectors[%d]: missing path", i)
+		}
+		if _, err := parseSelector(sel.Path); err != nil {
+			return fmt.Errorf("selector %q: %w", sel.Path, err)
+		}
+		if sel.Profile != "" {
+			if err := validateProfile(sel.Profile); err != nil {
+				return fmt.Errorf("selector %q: %w", sel.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash-separated name matches pattern, in
+// which a "**" segment matches any number of segments and other segments
+// are matched with path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// selectorStep is a step of a selector, which selects nodes beneath each of
+// the nodes selected by the steps before it.
+type selectorStep struct {
+	// recursive is set for steps that apply to the nodes at any depth
+	// beneath, written with "..".
+	recursive bool
+	// wildcard selects every field of a mapping or element of a sequence.
+	wildcard bool
+	// name selects the field of a mapping with that key, and index the
+	// element of a sequence, if it isn't -1.
+	name  string
+	index int
+}
+
+// parseSelector parses a JSONPath-like selector, such as
+// "$.rules[*].condition", "$..when", or "spec['match'][0]". The leading "$"
+// is optional.
+func parseSelector(s string) ([]selectorStep, error) {
+	src := strings.TrimPrefix(s, "$")
+	if len(src) == len(s) && !strings.HasPrefix(src, ".") && !strings.HasPrefix(src, "[") {
+		src = "." + src
+	}
+	var steps []selectorStep
+	for i := 0; i < len(src); {
+		step := selectorStep{index: -1}
+		if strings.HasPrefix(src[i:], "..") {
+			step.recursive = true
+			// Leave the second dot to introduce the field, unless the step
+			// is bracketed.
+			i++
+			if i+1 < len(src) && src[i+1] == '[' {
+				i++
+			}
+		}
+		switch src[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(src) && src[end] != '.' && src[end] != '[' {
+				end++
+			}
+			switch name := src[i:end]; name {
+			case "":
+				return nil, fmt.Errorf("missing field name at offset %d", i)
+			case "*":
+				step.wildcard = true
+			default:
+				step.name = name
+			}
+			i = end
+		case '[':
+			// [*], [0], ['name'], or ["name"].
+			end := strings.IndexByte(src[i:], ']')
+			if end < 0 {
+				return nil, errors.New("missing ']'")
+			}
+			inner := src[i+1 : i+end]
+			switch {
+			case inner == "*":
+				step.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				step.name = inner[1 : len(inner)-1]
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q", inner)
+				}
+				step.index = n
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", src[i], i)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// apply returns the nodes the step selects directly beneath n.
+func (step selectorStep) apply(n *yaml.Node) []*yaml.Node {
+	switch {
+	case step.wildcard && n.Kind == yaml.MappingNode:
+		var values []*yaml.Node
+		for i := 1; i < len(n.Content); i += 2 {
+			values = append(values, n.Content[i])
+		}
+		return values
+	case step.wildcard:
+		return yamlItems(n)
+	case step.index >= 0:
+		if items := yamlItems(n); step.index < len(items) {
+			return []*yaml.Node{items[step.index]}
+		}
+	default:
+		if v := yamlField(n, step.name); v != nil {
+			return []*yaml.Node{v}
+		}
+	}
+	return nil
+}
+
+// selectNodes returns the nodes beneath root selected by steps.
+func selectNodes(root *yaml.Node, steps []selectorStep) []*yaml.Node {
+	nodes := []*yaml.Node{root}
+	for _, step := range steps {
+		var next []*yaml.Node
+		for _, n := range nodes {
+			if !step.recursive {
+				next = append(next, step.apply(n)...)
+				continue
+			}
+			for _, d := range yamlDescendants(n) {
+				next = append(next, step.apply(d)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// yamlDescendants returns n and every mapping value and sequence element
+// beneath it.
+func yamlDescendants(n *yaml.Node) []*yaml.Node {
+	nodes := []*yaml.Node{n}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			nodes = append(nodes, yamlDescendants(n.Content[i])...)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			nodes = append(nodes, yamlDescendants(item)...)
+		}
+	}
+	return nodes
+}
+
+// boundSelector is a selector from a config file, with the environment of
+// the expressions it selects.
+type boundSelector struct {
+	steps []selectorStep
+	env   *cel.Env
+}
+
+// configSelectors returns the selectors that the config file applying to
+// the document at path has for it.
+func (s *server) configSelectors(path string, read func(string) ([]byte, error)) []boundSelector {
+	configPath := s.findConfig(filepath.Dir(path))
+	if configPath == "" {
+		return nil
+	}
+	data, err := read(configPath)
+	if err != nil {
+		return nil
+	}
+	// An invalid config is reported on the config file itself.
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(filepath.Dir(configPath), path)
+	if err != nil {
+		return nil
+	}
+	var selectors []boundSelector
+	for _, e := range cfg.Embedded {
+		if !matchGlob(e.Files, filepath.ToSlash(rel)) {
+			continue
+		}
+		for _, sel := range e.Selectors {
+			steps, _ := parseSelector(sel.Path)
+			env := s.celEnv
+			if entry := s.hostEnv(envKey{configPath: configPath, profile: sel.Profile}, read); len(entry.errs) == 0 {
+				env = entry.env
+			}
+			selectors = append(selectors, boundSelector{steps: steps, env: env})
+		}
+	}
+	return selectors
+}
+
+// expressions returns the expressions the selector selects in doc.
+func (sel boundSelector) expressions(content string, doc *yaml.Node) []*embeddedExpr {
+	var exprs []*embeddedExpr
+	for _, n := range selectNodes(doc, sel.steps) {
+		if isYAMLString(n) {
+			exprs = append(exprs, yamlExpr(content, n, sel.env))
+		}
+	}
+	return exprs
+}
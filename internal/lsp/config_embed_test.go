@@ -0,0 +1,54 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestConfigEmbedDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/config_embed/workflows/deploy.yaml"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	// The position of the error accounts for the escaped quotes before it.
+	// The description isn't selected, so it isn't analyzed.
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/config_embed/rbac/policy.json"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{"found no matching overload for '>' applied to '(int, string)'"})
+	be.Equal(t, diags[0].Range.Start, protocol.Position{Line: 6, Character: 71})
+}
+
+func TestConfigEmbedHover(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		file     string
+		line     uint32
+		char     uint32
+		contains string
+	}{
+		{"json field", "testdata/config_embed/rbac/policy.json", 3, 21, "**Variable**: `input`"},
+		{"recursive selector", "testdata/config_embed/workflows/deploy.yaml", 8, 12, "**Variable**: `input`"},
+		{"selector profile", "testdata/config_embed/workflows/deploy.yaml", 10, 13, "The object from the incoming request."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			requireHoverContains(t, tt.file, tt.line, tt.char, tt.contains, tt.name)
+		})
+	}
+	// Fields that aren't selected have no expressions.
+	requireNoHover(t, "testdata/config_embed/rbac/policy.json", 9, 20, "unselected field")
+}
+
+func TestConfigEmbedCompletion(t *testing.T) {
+	t.Parallel()
+
+	conn, uri, _ := setupCompletionServer(t, "testdata/config_embed/workflows/deploy.yaml")
+	result := requestCompletion(t, conn, uri, protocol.Position{Line: 2, Character: 11}, protocol.Invoked, "")
+	be.True(t, containsLabel(result.Items, "input"))
+}
@@ -0,0 +1,186 @@
+package lsp_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestConfigDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"declared variables", "testdata/config/variables.cel"},
+		{"container", "testdata/config/container.cel"},
+		{"ancestor directory", "testdata/config/nested/ancestor.cel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, len(diags), 0)
+		})
+	}
+}
+
+func TestConfigWithoutConfigFile(t *testing.T) {
+	t.Parallel()
+
+	// Outside of testdata/config, the same variables are undeclared.
+	conn, uri := openDiagFile(t, "undeclared_variable.cel")
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "undeclared reference"))
+}
+
+func TestConfigHover(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join("testdata", "config", "variables.cel")
+	requireHoverContains(t, file, 0, 0, "**Variable**: `request`", "variable header")
+	requireHoverContains(t, file, 0, 0, "map(string, dyn)", "variable type")
+	requireHoverContains(t, file, 0, 0, "The incoming request.", "variable description")
+	requireHoverContains(t, file, 0, 29, "`int`", "variable without description")
+}
+
+func TestConfigCompletion(t *testing.T) {
+	t.Parallel()
+
+	result := requestInvokedCompletion(t, "testdata/config/variables.cel")
+	item := findCompletionItem(result.Items, "request")
+	be.True(t, item != nil)
+	be.Equal(t, item.Kind, protocol.VariableCompletion)
+	be.Equal(t, item.Detail, "map(string, dyn)")
+
+	// After "limit +", only int-typed variables are offered.
+	result = requestInvokedAtEnd(t, "testdata/config/after_operator.cel")
+	be.True(t, containsLabel(result.Items, "limit"))
+	be.True(t, !containsLabel(result.Items, "request"))
+}
+
+func TestConfigEnvironmentFile(t *testing.T) {
+	t.Parallel()
+
+	file := "testdata/env_config/uses_env.cel"
+	conn, uri := setupLSPServer(t, getAbsPath(t, file))
+	be.Equal(t, len(pullDiagnostics(t, conn, uri)), 0)
+
+	requireHoverContains(t, file, 0, 23, "The maximum number of items.", "variable from environment file")
+	requireHoverContains(t, file, 0, 37, "Determines whether a list is empty.", "function from environment file")
+}
+
+func TestConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		file        string
+		wantLine    uint32
+		wantContain string
+	}{
+		{"unknown field", "testdata/config_invalid/.cells.yaml", 1, "field varibles not found"},
+		{"undefined type", "testdata/env_config_invalid/bad.yaml", 3, "undefined type name"},
+		{"missing environment file", "testdata/env_config_missing/.cells.yaml", 2, "missing.yaml"},
+		{"unknown extension", "testdata/extensions_invalid/.cells.yaml", 2, "unknown library"},
+		{"unknown protovalidate message", "testdata/protovalidate_unknown/.cells.yaml", 2, "unknown message type"},
+		{"invalid selector", "testdata/config_embed_invalid/.cells.yaml", 3, `invalid index "first"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, len(diags), 1)
+			be.Equal(t, diags[0].Range.Start.Line, tt.wantLine)
+			be.Equal(t, diags[0].Severity, protocol.SeverityError)
+			be.True(t, strings.Contains(diags[0].Message, tt.wantContain))
+		})
+	}
+}
+
+func TestConfigErrorsPublishedForCELFile(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	})
+	go func() {
+		_ = lsp.ServeStream(ctx, serverConn)
+	}()
+
+	collector := newDiagnosticCollector()
+	client := jsonrpc2.NewConn(ctx, clientConn, jsonrpc2.HandlerFunc(collector.handler))
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	var initResult protocol.InitializeResult
+	be.Err(t, client.Call(ctx, "initialize", protocol.InitializeParams{}, &initResult), nil)
+
+	celPath := getAbsPath(t, "testdata/config_invalid/uses_config.cel")
+	content, err := os.ReadFile(celPath)
+	be.Err(t, err, nil)
+	err = client.Notify(ctx, "textDocument/didOpen", protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			URI:        protocol.URIFromPath(celPath),
+			LanguageID: "cel",
+			Version:    1,
+			Text:       string(content),
+		},
+	})
+	be.Err(t, err, nil)
+
+	// One notification for the CEL file, which falls back to the default
+	// environment, and one for the broken config file.
+	collector.waitForDiagnostics(t, 2)
+	configURI := protocol.URIFromPath(getAbsPath(t, "testdata/config_invalid/.cells.yaml"))
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	var found bool
+	for _, params := range collector.diagnostics {
+		if params.URI == configURI {
+			found = true
+			be.Equal(t, len(params.Diagnostics), 1)
+		}
+	}
+	be.True(t, found)
+}
+
+func TestConfigChangedOnDisk(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".cells.yaml")
+	writeConfig := func(typeName string) {
+		config := "variables:\n  - name: limit\n    type: " + typeName + "\n"
+		be.Err(t, os.WriteFile(configPath, []byte(config), 0o644), nil)
+	}
+	writeConfig("int")
+	celPath := filepath.Join(dir, "limit.cel")
+	be.Err(t, os.WriteFile(celPath, []byte("limit + 1"), 0o644), nil)
+
+	conn, uri := setupLSPServer(t, celPath)
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	// The environment is rebuilt once the config's stamp changes.
+	writeConfig("string")
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "no matching overload"))
+}
@@ -0,0 +1,59 @@
+package lsp
+
+import (
+	"encoding/json"
+
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// definition finds where a variable is declared. Only hosts that declare
+// variables themselves, such as cel-go policies with their variables.<name>,
+// locate declarations.
+func (s *server) definition(req *jsonrpc2.Request) (any, error) {
+	var params protocol.DefinitionParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	f := s.files[params.TextDocument.URI]
+	s.mu.Unlock()
+
+	if f == nil || f.content == "" {
+		return nil, nil
+	}
+
+	exprs, ok := s.embedded(f)
+	if !ok {
+		return nil, nil
+	}
+	e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+	if !ok {
+		return nil, nil
+	}
+	r, ok := e.definitions[qualifiedNameAt(e.file.content, pos)]
+	if !ok {
+		return nil, nil
+	}
+	return []protocol.Location{{URI: params.TextDocument.URI, Range: r}}, nil
+}
+
+// qualifiedNameAt returns the dotted name that ends with the identifier at
+// pos in content, e.g. "variables.x" for a position within the x of
+// "variables.x.y".
+func qualifiedNameAt(content string, pos protocol.Position) string {
+	offset := lineColToByteOffset(content, pos.Line, pos.Character)
+	if offset < 0 {
+		return ""
+	}
+	start := offset
+	for start > 0 && (isIdentifierChar(rune(content[start-1])) || content[start-1] == '.') {
+		start--
+	}
+	end := offset
+	for end < len(content) && isIdentifierChar(rune(content[end])) {
+		end++
+	}
+	return content[start:end]
+}
@@ -0,0 +1,198 @@
+package lsp
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// definitionPattern matches the start of a named definition in a .cel file,
+// a name followed by := at the start of a line, as in
+//
+//	limits := {'max': 10}
+//
+// A .cel file with definitions holds several named expressions instead of
+// one; each runs to the next definition, so they may be separated by blank
+// lines and comments.
+var definitionPattern = regexp.MustCompile(`(?m)^([_a-zA-Z][_a-zA-Z0-9]*)[ \t]*:=`)
+
+// celDefinition is a named expression in a .cel file of definitions.
+type celDefinition struct {
+	name string
+	// nameStart is the offset of the name in the file, and exprStart and
+	// end those of the expression, after the :=, and its end.
+	nameStart, exprStart, end int
+}
+
+// nameRange returns the range of the definition's name in content.
+func (d celDefinition) nameRange(content string) protocol.Range {
+	return offsetRange(content, d.nameStart, d.nameStart+len(d.name))
+}
+
+// parseDefinitions returns the definitions in content, a .cel file, which
+// has none if it's a single expression.
+func parseDefinitions(content string) []celDefinition {
+	matches := definitionPattern.FindAllStringSubmatchIndex(content, -1)
+	defs := make([]celDefinition, 0, len(matches))
+	for i, m := range matches {
+		next := len(content)
+		if i+1 < len(matches) {
+			next = matches[i+1][0]
+		}
+		// The blank lines and comments before the next definition belong
+		// to it, not to the expression.
+		body := content[m[1]:next]
+		for {
+			body = strings.TrimRight(body, " \t\r\n")
+			last := strings.LastIndexByte(body, '\n')
+			if last < 0 || !strings.HasPrefix(strings.TrimSpace(body[last+1:]), "//") {
+				break
+			}
+			body = body[:last]
+		}
+		defs = append(defs, celDefinition{name: content[m[2]:m[3]], nameStart: m[2], exprStart: m[1], end: m[1] + len(body)})
+	}
+	return defs
+}
+
+// definitionExpressions returns the expressions of the .cel file of
+// definitions at path. Each is analyzed with the environment of the config
+// file that applies to the file, if any, and a variable for each name
+// defined before it, whose value is used to evaluate inlay hints.
+func (s *server) definitionExpressions(path, content string, read func(string) ([]byte, error)) []*embeddedExpr {
+	defs := parseDefinitions(content)
+	env := s.celEnv
+	if key := (envKey{configPath: s.findConfig(filepath.Dir(path)), profile: fileProfile(content)}); key != (envKey{}) {
+		if entry := s.hostEnv(key, read); len(entry.errs) == 0 {
+			env = entry.env
+		}
+	}
+	env = withDirectives(env, content)
+
+	// Sample inputs from a file of inputs are the values of the variables
+	// the environment declares.
+	vars := inputFileVars(path, env, read)
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+	var (
+		exprs    []*embeddedExpr
+		declared = make(map[string]protocol.Range)
+	)
+	for i, d := range defs {
+		e := &embeddedExpr{
+			file:        &file{content: content[d.exprStart:d.end]},
+			offsets:     make([]int, d.end-d.exprStart+1),
+			env:         env,
+			definitions: maps.Clone(declared),
+			vars:        maps.Clone(vars),
+		}
+		for k := range e.offsets {
+			e.offsets[k] = d.exprStart + k
+		}
+		if i == 0 {
+			// Directives come before the first definition.
+			e.diagnostics = directiveDiagnostics(content[:d.nameStart], env)
+		}
+		if _, ok := declared[d.name]; ok {
+			e.diagnostics = append(e.diagnostics, hostDiagnostic(content, d.nameStart, d.nameStart+len(d.name), fmt.Sprintf("%s is already defined", d.name)))
+		}
+		if strings.TrimSpace(e.file.content) == "" {
+			e.diagnostics = append(e.diagnostics, hostDiagnostic(content, d.nameStart, d.exprStart, fmt.Sprintf("%s has no expression", d.name)))
+		}
+		exprs = append(exprs, e)
+
+		env = bindResult(e, d.name, vars, s.inlayHintOptions)
+		declared[d.name] = d.nameRange(content)
+	}
+	return exprs
+}
+
+// renameDefinition renames the definition in f, a .cel file of definitions
+// with the given expressions, whose name is at pos or that the identifier at
+// pos refers to, along with its references in the definitions after it. It
+// reports whether there's such a definition.
+func renameDefinition(f *file, exprs []*embeddedExpr, params protocol.RenameParams) (*protocol.WorkspaceEdit, bool, error) {
+	defs := parseDefinitions(f.content)
+	if len(defs) != len(exprs) {
+		return nil, false, nil
+	}
+	k, ok := definitionNameAt(defs, f.content, params.Position)
+	if !ok {
+		e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+		if !ok {
+			return nil, false, nil
+		}
+		name := topLevelIdentifierAt(e, pos)
+		if _, ok := e.definitions[name]; !ok {
+			return nil, false, nil
+		}
+		for i := range defs {
+			if exprs[i] == e {
+				break
+			}
+			if defs[i].name == name {
+				k = i
+			}
+		}
+	}
+	if err := validateNewName(params.NewName); err != nil {
+		return nil, true, err
+	}
+
+	name := defs[k].name
+	edits := []protocol.TextEdit{{Range: defs[k].nameRange(f.content), NewText: params.NewName}}
+	for j := k + 1; j < len(defs); j++ {
+		e := exprs[j]
+		if parsed, iss := e.env.Parse(e.file.content); iss.Err() == nil {
+			native := parsed.NativeRep()
+			for _, edit := range CollectIdentifierOccurrences(native.Expr(), native.SourceInfo(), e.file.content, name, params.NewName) {
+				edit.Range = e.hostRange(f.content, edit.Range)
+				edits = append(edits, edit)
+			}
+		}
+		// A redefinition's own expression refers to the definition, but
+		// the definitions after it don't.
+		if defs[j].name == name {
+			break
+		}
+	}
+	return &protocol.WorkspaceEdit{
+		Changes: map[protocol.DocumentURI][]protocol.TextEdit{params.TextDocument.URI: edits},
+	}, true, nil
+}
+
+// definitionNameAt returns the index of the definition whose name is at pos
+// in content.
+func definitionNameAt(defs []celDefinition, content string, pos protocol.Position) (int, bool) {
+	offset := lineColToByteOffset(content, pos.Line, pos.Character)
+	for i, d := range defs {
+		if offset >= d.nameStart && offset <= d.nameStart+len(d.name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// topLevelIdentifierAt returns the name of the identifier at pos in e, if
+// it's a variable that isn't bound by a comprehension.
+func topLevelIdentifierAt(e *embeddedExpr, pos protocol.Position) string {
+	parsed, iss := e.env.Parse(e.file.content)
+	if iss.Err() != nil {
+		return ""
+	}
+	native := parsed.NativeRep()
+	offset := lineColToByteOffset(e.file.content, pos.Line, pos.Character)
+	info := findIdentifierAtPosition(native.Expr(), native.SourceInfo(), e.file.content, offset)
+	if info == nil || info.kind == identifierKindFunction {
+		return ""
+	}
+	if _, ok := determineIdentifierScope(info.exprID, info.name, native.Expr(), native.SourceInfo(), e.file.content).(topLevelScope); !ok {
+		return ""
+	}
+	return info.name
+}
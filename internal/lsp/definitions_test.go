@@ -0,0 +1,136 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// lineRange returns the range of the given columns of a line.
+func lineRange(line, start, end uint32) protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{Line: line, Character: start},
+		End:   protocol.Position{Line: line, Character: end},
+	}
+}
+
+func TestDefinitionsDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/definitions/limits.cel"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/definitions/invalid.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{
+		"unknown directive bogus",
+		"undeclared reference to 'count' (in container '')",
+		"count is already defined",
+		"empty has no expression",
+	})
+	be.Equal(t, diags[1].Range, lineRange(1, 9, 18))
+	be.Equal(t, diags[2].Range, lineRange(3, 0, 5))
+}
+
+func TestDefinitionsInlayHints(t *testing.T) {
+	t.Parallel()
+
+	var labels []string
+	for _, hint := range getInlayHints(t, "testdata/definitions/limits.cel") {
+		labels = append(labels, hint.Label[0].Value)
+	}
+	be.Equal(t, labels, []string{
+		`→ {"max": 10, "min": 2} (map(string, int))`,
+		"→ 9 (int)",
+		"→ true (bool)",
+	})
+}
+
+func TestDefinitionsBudgets(t *testing.T) {
+	t.Parallel()
+
+	var labels []string
+	options := map[string]any{"inlayHints": map[string]any{"costLimit": 1000}}
+	for _, hint := range getInlayHintsWithOptions(t, "testdata/definitions/expensive.cel", options) {
+		labels = append(labels, hint.Label[0].Value)
+	}
+	// total isn't bound, so more has no value either.
+	be.Equal(t, labels, []string{"→ no value: evaluation exceeded the cost limit of 1000"})
+}
+
+func TestDefinitionsHover(t *testing.T) {
+	t.Parallel()
+
+	const file = "testdata/definitions/limits.cel"
+	requireHoverContains(t, file, 4, 14, "map(string, int)", "earlier definition")
+	requireHoverContains(t, file, 6, 8, "int", "definition referencing another")
+}
+
+func TestDefinitionsDocumentSymbols(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/definitions/limits.cel"))
+	var symbols []protocol.DocumentSymbol
+	err := conn.Call(t.Context(), "textDocument/documentSymbol", protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	}, &symbols)
+	be.Err(t, err, nil)
+	be.Equal(t, symbols, []protocol.DocumentSymbol{
+		{Name: "limits", Detail: "map(string, int)", Kind: protocol.Variable, Range: lineRange(1, 0, 31), SelectionRange: lineRange(1, 0, 6)},
+		{Name: "replicas", Detail: "int", Kind: protocol.Variable, Range: lineRange(4, 0, 26), SelectionRange: lineRange(4, 0, 8)},
+		{Name: "ok", Detail: "bool", Kind: protocol.Variable, Range: lineRange(6, 0, 54), SelectionRange: lineRange(6, 0, 2)},
+	})
+
+	// A single expression has no symbols.
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/inlay_hints/arithmetic.cel"))
+	err = conn.Call(t.Context(), "textDocument/documentSymbol", protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	}, &symbols)
+	be.Err(t, err, nil)
+	be.Equal(t, len(symbols), 0)
+}
+
+func TestDefinitionsRename(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/definitions/limits.cel"))
+	tests := []struct {
+		name string
+		pos  protocol.Position
+		want []protocol.Range
+	}{
+		{
+			"from a reference",
+			protocol.Position{Line: 6, Character: 20},
+			[]protocol.Range{lineRange(1, 0, 6), lineRange(4, 12, 18), lineRange(6, 18, 24), lineRange(6, 44, 50)},
+		},
+		{
+			"from the definition",
+			protocol.Position{Line: 4, Character: 3},
+			[]protocol.Range{lineRange(4, 0, 8), lineRange(6, 6, 14), lineRange(6, 32, 40)},
+		},
+	}
+	for _, tt := range tests {
+		edit := requestRename(t, conn, uri, tt.pos, "renamed")
+		be.True(t, edit != nil)
+		var got []protocol.Range
+		for _, e := range edit.Changes[uri] {
+			be.Equal(t, e.NewText, "renamed")
+			got = append(got, e.Range)
+		}
+		be.Equal(t, got, tt.want)
+	}
+
+	r, ok := requestPrepareRename(t, conn, uri, protocol.Position{Line: 1, Character: 2}).(map[string]any)
+	be.True(t, ok)
+	be.Equal(t, r["end"], any(map[string]any{"line": float64(1), "character": float64(6)}))
+}
+
+func TestDefinitionsDefinition(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/definitions/limits.cel"))
+	locs := requestDefinition(t, conn, uri, protocol.Position{Line: 6, Character: 8})
+	be.Equal(t, locs, []protocol.Location{{URI: uri, Range: lineRange(4, 0, 8)}})
+}
@@ -46,34 +46,62 @@ func (s *server) diagnosticFull(req *jsonrpc2.Request) (any, error) {
 		}, nil
 	}
 
+	var items []protocol.Diagnostic
+	if path, err := params.TextDocument.URI.Path(); err == nil && path != "" && f.notebook == nil {
+		items = s.documentDiagnostics(path, content)
+	} else if exprs, ok := s.embedded(f); ok {
+		items = embeddedDiagnostics(exprs, content)
+	} else {
+		items = computeDiagnostics(content, s.envFor(params.TextDocument.URI))
+	}
+
 	return protocol.RelatedFullDocumentDiagnosticReport{
 		FullDocumentDiagnosticReport: protocol.FullDocumentDiagnosticReport{
 			Kind:  string(protocol.DiagnosticFull),
-			Items: computeDiagnostics(content, s.celEnv),
+			Items: items,
 		},
 	}, nil
 }
 
+// documentDiagnostics returns the diagnostics of the document at path with
+// the given content, which is a config file or a file it references, a host
+// document, or a CEL file.
+func (s *server) documentDiagnostics(path, content string) []protocol.Diagnostic {
+	if configPath, ok := s.configDocument(path); ok {
+		return s.configDiagnostics(path, configPath)
+	}
+	if exprs, ok := s.embeddedIn(path, content); ok {
+		return append(embeddedDiagnostics(exprs, content), s.inputDiagnostics(path, content)...)
+	}
+	return computeDiagnostics(content, s.documentEnv(path, content))
+}
+
 // computeDiagnostics parses and type-checks a CEL file, returning LSP diagnostics.
 func computeDiagnostics(content string, celEnv *cel.Env) []protocol.Diagnostic {
 	if strings.TrimSpace(content) == "" {
 		return []protocol.Diagnostic{}
 	}
 
+	diagnostics := append([]protocol.Diagnostic{}, directiveDiagnostics(content, celEnv)...)
+	if in, ok := commentInput(content); ok {
+		_, inputDiagnostics := in.bind(celEnv)
+		diagnostics = append(diagnostics, inputDiagnostics...)
+	}
+
 	// Parse phase.
 	parsed, parseIssues := celEnv.Parse(content)
 	if parseIssues.Err() != nil {
-		return issuesToDiagnostics(content, parseIssues, protocol.SeverityError)
+		return append(diagnostics, issuesToDiagnostics(content, parseIssues, protocol.SeverityError)...)
 	}
 
 	// Check (type-check) phase.
 	_, checkIssues := celEnv.Check(parsed)
 	if checkIssues.Err() != nil {
-		return issuesToDiagnostics(content, checkIssues, protocol.SeverityWarning)
+		return append(diagnostics, issuesToDiagnostics(content, checkIssues, protocol.SeverityWarning)...)
 	}
 
 	// No errors — clear diagnostics.
-	return []protocol.Diagnostic{}
+	return diagnostics
 }
 
 // issuesToDiagnostics converts cel.Issues to LSP diagnostics.
@@ -0,0 +1,183 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// directivePrefix starts a directive comment, such as
+//
+//	// cells:profile kubernetes-crd
+//	// cells:var request map(string, dyn)
+//	// cells:container google.api
+//
+// Directives configure the environment for the file they're in, from the
+// comments that lead it.
+const directivePrefix = "// cells:"
+
+// directive is a directive comment in a CEL file.
+type directive struct {
+	// line is the 0-based line the directive is on.
+	line int
+	name string
+	arg  string
+}
+
+// parseDirectives returns the directives in content, which are comments on
+// lines of their own before the expression.
+func parseDirectives(content string) []directive {
+	var directives []directive
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") {
+			// The leading comments end where the expression starts.
+			break
+		}
+		rest, ok := strings.CutPrefix(trimmed, directivePrefix)
+		if !ok {
+			continue
+		}
+		name, arg, _ := strings.Cut(rest, " ")
+		directives = append(directives, directive{line: i, name: name, arg: strings.TrimSpace(arg)})
+	}
+	return directives
+}
+
+// variable returns the name and type of a var directive's variable.
+func (d directive) variable() (name, typeName string) {
+	name, typeName, _ = strings.Cut(d.arg, " ")
+	return name, strings.TrimSpace(typeName)
+}
+
+// fileProfile returns the profile selected by a directive in content, or "".
+func fileProfile(content string) string {
+	for _, d := range parseDirectives(content) {
+		if d.name == "profile" && validateProfile(d.arg) == nil {
+			return d.arg
+		}
+	}
+	return ""
+}
+
+// withDirectives returns celEnv extended with the variables and container
+// declared by the directives in content. Invalid directives are skipped, as
+// they're reported by directiveDiagnostics.
+func withDirectives(celEnv *cel.Env, content string) *cel.Env {
+	for _, d := range parseDirectives(content) {
+		var opt cel.EnvOption
+		switch d.name {
+		case "var":
+			name, typeName := d.variable()
+			if !isValidIdentifier(name) {
+				continue
+			}
+			opt = declareVariable(name, typeName, "")
+		case "container":
+			if !isQualifiedName(d.arg) {
+				continue
+			}
+			opt = cel.Container(d.arg)
+		default:
+			continue
+		}
+		if extended, err := celEnv.Extend(opt); err == nil {
+			celEnv = extended
+		}
+	}
+	return celEnv
+}
+
+// directiveDiagnostics reports invalid directives in content, resolving the
+// types of variables in celEnv.
+func directiveDiagnostics(content string, celEnv *cel.Env) []protocol.Diagnostic {
+	var diagnostics []protocol.Diagnostic
+	for _, d := range parseDirectives(content) {
+		var msg string
+		switch d.name {
+		case "profile":
+			if err := validateProfile(d.arg); err != nil {
+				msg = err.Error()
+			}
+		case "var":
+			name, typeName := d.variable()
+			switch {
+			case name == "":
+				msg = "var: missing variable name and type, as in cells:var request map(string, dyn)"
+			case !isValidIdentifier(name):
+				msg = fmt.Sprintf("var: invalid variable name %q", name)
+			case typeName == "":
+				msg = fmt.Sprintf("var: missing type of variable %q", name)
+			default:
+				if _, err := parseCELType(typeName, celEnv); err != nil {
+					msg = fmt.Sprintf("var: variable %q: %v", name, err)
+				}
+			}
+		case "container":
+			if !isQualifiedName(d.arg) {
+				msg = fmt.Sprintf("container: invalid container name %q", d.arg)
+			}
+		default:
+			msg = "unknown directive " + d.name
+		}
+		if msg == "" {
+			continue
+		}
+		diagnostics = append(diagnostics, protocol.Diagnostic{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: uint32(d.line)},
+				End:   endOfLine(content, d.line),
+			},
+			Severity: protocol.SeverityError,
+			Source:   serverName,
+			Message:  msg,
+		})
+	}
+	return diagnostics
+}
+
+// directiveHover returns the hover for the directive at pos in content, if
+// any: the declared variable and its type, or the container.
+func directiveHover(content string, celEnv *cel.Env, pos protocol.Position) *protocol.Hover {
+	for _, d := range parseDirectives(content) {
+		if d.line != int(pos.Line) {
+			continue
+		}
+		var md string
+		switch d.name {
+		case "var":
+			name, typeName := d.variable()
+			if md = celVariableHover(name, celEnv); md == "" && name != "" && typeName != "" {
+				md = fmt.Sprintf("**Variable**: `%s`\n\n**Type**: `%s`", name, typeName)
+			}
+		case "container":
+			if d.arg != "" {
+				md = fmt.Sprintf("**Container**: `%s`", d.arg)
+			}
+		}
+		if md == "" {
+			return nil
+		}
+		return &protocol.Hover{
+			Contents: protocol.MarkupContent{Kind: protocol.Markdown, Value: md},
+			Range: protocol.Range{
+				Start: protocol.Position{Line: uint32(d.line)},
+				End:   endOfLine(content, d.line),
+			},
+		}
+	}
+	return nil
+}
+
+// isQualifiedName reports whether s is a dotted sequence of identifiers,
+// such as a container name.
+func isQualifiedName(s string) bool {
+	for part := range strings.SplitSeq(s, ".") {
+		if !isValidIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestDirectiveDeclarations(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/directives/vars.cel"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/directives/invalid.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{
+		"var: missing variable name and type, as in cells:var request map(string, dyn)",
+		`var: invalid variable name "1x"`,
+		`var: missing type of variable "name"`,
+		`var: variable "count": invalid type "list(int": missing ')'`,
+		`var: variable "user": invalid type "acme.User": undefined type name: "acme.User"`,
+		`container: invalid container name "google..api"`,
+		"unknown directive bogus",
+	})
+	for i, d := range diags {
+		be.Equal(t, d.Range.Start.Line, uint32(i))
+	}
+}
+
+func TestDirectiveHover(t *testing.T) {
+	t.Parallel()
+
+	const file = "testdata/directives/vars.cel"
+	requireHoverContains(t, file, 2, 5, "**Variable**: `request`\n\n**Type**: `map(string, dyn)`", "var directive")
+	requireHoverContains(t, file, 3, 15, "**Type**: `int`", "var directive")
+	requireHoverContains(t, file, 1, 5, "**Container**: `google.protobuf`", "container directive")
+	requireHoverContains(t, file, 4, 18, "**Variable**: `limit`", "declared variable")
+	requireNoHover(t, file, 0, 5, "plain comment")
+}
@@ -20,11 +20,11 @@ func (s *server) documentHighlight(req *jsonrpc2.Request) (any, error) {
 	f := s.files[params.TextDocument.URI]
 	s.mu.Unlock()
 
-	if f == nil || f.content == "" {
+	if f == nil || f.content == "" || hostDocument(f.uri) {
 		return nil, nil
 	}
 
-	return computeDocumentHighlight(f, s.celEnv, params)
+	return computeDocumentHighlight(f, s.envFor(params.TextDocument.URI), params)
 }
 
 func computeDocumentHighlight(f *file, celEnv *cel.Env, params protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
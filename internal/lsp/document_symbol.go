@@ -0,0 +1,50 @@
+package lsp
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// documentSymbol lists the definitions of a .cel file of definitions, with
+// their types. Other documents have no symbols.
+func (s *server) documentSymbol(req *jsonrpc2.Request) (any, error) {
+	var params protocol.DocumentSymbolParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	f := s.files[params.TextDocument.URI]
+	s.mu.Unlock()
+
+	symbols := []protocol.DocumentSymbol{}
+	if f == nil {
+		return symbols, nil
+	}
+	if path, err := f.uri.Path(); err != nil || filepath.Ext(path) != ".cel" {
+		return symbols, nil
+	}
+	defs := parseDefinitions(f.content)
+	exprs, ok := s.embedded(f)
+	if !ok || len(exprs) != len(defs) {
+		return symbols, nil
+	}
+	for i, d := range defs {
+		e := exprs[i]
+		var detail string
+		if checked, iss := e.env.Compile(e.file.content); iss.Err() == nil {
+			detail = checked.OutputType().String()
+		}
+		symbols = append(symbols, protocol.DocumentSymbol{
+			Name:           d.name,
+			Detail:         detail,
+			Kind:           protocol.Variable,
+			Range:          offsetRange(f.content, d.nameStart, d.end),
+			SelectionRange: d.nameRange(f.content),
+		})
+	}
+	return symbols, nil
+}
@@ -0,0 +1,231 @@
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// embeddedExpr is a CEL expression embedded in another kind of document,
+// its host, such as a string literal in a .proto file.
+type embeddedExpr struct {
+	// file holds the expression's source, with any escaping of the host
+	// removed.
+	file *file
+	// offsets maps each byte offset in the expression's source, and its
+	// end, to a byte offset in the host.
+	offsets []int
+	env     *cel.Env
+	// diagnostics are problems with how the expression is embedded, such
+	// as an unknown environment, at their positions in the host.
+	diagnostics []protocol.Diagnostic
+	// definitions locates, in the host, the declarations of the variables
+	// the host declares for the expression, by name.
+	definitions map[string]protocol.Range
+	// vars holds the values of the variables the host binds for the
+	// expression, such as the results of earlier notebook cells, for
+	// evaluating it.
+	vars map[string]any
+}
+
+// hostPosition translates a position in the expression to one in host.
+func (e *embeddedExpr) hostPosition(host string, pos protocol.Position) protocol.Position {
+	offset := lineColToByteOffset(e.file.content, pos.Line, pos.Character)
+	if offset < 0 {
+		offset = len(e.file.content)
+	}
+	line, col := byteOffsetToLineCol(host, e.offsets[offset])
+	return protocol.Position{Line: line, Character: col}
+}
+
+// hostRange translates a range in the expression to one in host.
+func (e *embeddedExpr) hostRange(host string, r protocol.Range) protocol.Range {
+	return protocol.Range{Start: e.hostPosition(host, r.Start), End: e.hostPosition(host, r.End)}
+}
+
+// offsetRange returns the range of the bytes of text between start and end.
+func offsetRange(text string, start, end int) protocol.Range {
+	startLine, startCol := byteOffsetToLineCol(text, start)
+	endLine, endCol := byteOffsetToLineCol(text, end)
+	return protocol.Range{
+		Start: protocol.Position{Line: startLine, Character: startCol},
+		End:   protocol.Position{Line: endLine, Character: endCol},
+	}
+}
+
+// position translates a position in host to one in the expression, if it's
+// within the expression. A position within an escape sequence is the
+// position of the character it escapes.
+func (e *embeddedExpr) position(host string, pos protocol.Position) (protocol.Position, bool) {
+	offset := lineColToByteOffset(host, pos.Line, pos.Character)
+	if offset < e.offsets[0] || offset > e.offsets[len(e.offsets)-1] {
+		return protocol.Position{}, false
+	}
+	i := sort.Search(len(e.offsets), func(i int) bool { return e.offsets[i] > offset }) - 1
+	line, col := byteOffsetToLineCol(e.file.content, i)
+	return protocol.Position{Line: line, Character: col}, true
+}
+
+// bindResult returns e's environment with a variable of the given name,
+// whose type is that of e, and sets the variable's value in vars to what e
+// evaluates to, or removes it if e doesn't evaluate. The variable is dyn if
+// e doesn't compile. Evaluation is bounded by the budgets of opts, as that
+// of inlay hints is, since it's redone whenever the document changes.
+func bindResult(e *embeddedExpr, name string, vars map[string]any, opts inlayHintOptions) *cel.Env {
+	t := cel.DynType
+	delete(vars, name)
+	if checked, iss := e.env.Compile(e.file.content); iss.Err() == nil {
+		t = checked.OutputType()
+		opts.Subexpressions = false
+		if val, _, err := evaluate(context.Background(), checked, e.env, e.vars, opts); err == nil {
+			vars[name] = val
+		}
+	}
+	extended, err := e.env.Extend(cel.Variable(name, t))
+	if err != nil {
+		return e.env
+	}
+	return extended
+}
+
+// embedder extracts the CEL expressions embedded in the host document at
+// path, using read to read any other files their environments depend on.
+type embedder func(s *server, path, content string, read func(string) ([]byte, error)) []*embeddedExpr
+
+// embedders are keyed by the extension of the documents they extract
+// expressions from.
+var embedders = map[string]embedder{
+	".go":    (*server).goExpressions,
+	".md":    (*server).markdownExpressions,
+	".proto": (*server).protoExpressions,
+	".yaml":  (*server).yamlExpressions,
+	".yml":   (*server).yamlExpressions,
+	".json":  (*server).yamlExpressions,
+}
+
+// hostDocument reports whether the document at uri is a host document,
+// whose content isn't CEL itself.
+func hostDocument(uri protocol.DocumentURI) bool {
+	path, err := uri.Path()
+	if err != nil || path == "" {
+		return false
+	}
+	_, ok := embedders[filepath.Ext(path)]
+	return ok
+}
+
+// embedEntry caches the expressions extracted from a host document.
+type embedEntry struct {
+	content string
+	// inputs holds the contents of the other files the expressions'
+	// environments were built from, keyed by path.
+	inputs map[string][]byte
+	stamps map[string]fileStamp
+	exprs  []*embeddedExpr
+}
+
+// embedded returns the expressions embedded in f, and whether f is a host
+// document, whose content isn't CEL itself.
+func (s *server) embedded(f *file) ([]*embeddedExpr, bool) {
+	if f.notebook != nil {
+		return s.cellExpressions(f), true
+	}
+	path, err := f.uri.Path()
+	if err != nil || path == "" {
+		return nil, false
+	}
+	return s.embeddedIn(path, f.content)
+}
+
+// embeddedIn is like embedded, for the document at path with the given
+// content. A .cel file of definitions is a host of its definitions'
+// expressions.
+func (s *server) embeddedIn(path, content string) ([]*embeddedExpr, bool) {
+	extract, ok := embedders[filepath.Ext(path)]
+	if !ok && filepath.Ext(path) == ".cel" && definitionPattern.MatchString(content) {
+		extract, ok = (*server).definitionExpressions, true
+	}
+	if !ok {
+		return nil, false
+	}
+
+	uri := protocol.URIFromPath(path)
+	s.mu.Lock()
+	cached := s.embeds[uri]
+	s.mu.Unlock()
+	if cached != nil && cached.content == content && !s.inputsChanged(cached.stamps) {
+		return cached.exprs, true
+	}
+
+	entry := &embedEntry{content: content, inputs: make(map[string][]byte), stamps: make(map[string]fileStamp)}
+	entry.exprs = extract(s, path, content, s.recordingReader(entry.inputs, entry.stamps))
+	for _, e := range entry.exprs {
+		e.file.uri = uri
+	}
+
+	s.mu.Lock()
+	s.embeds[uri] = entry
+	s.mu.Unlock()
+	return entry.exprs, true
+}
+
+// embeddedAt returns the expression containing pos in host, and pos
+// translated into it.
+func embeddedAt(exprs []*embeddedExpr, host string, pos protocol.Position) (*embeddedExpr, protocol.Position, bool) {
+	for _, e := range exprs {
+		if p, ok := e.position(host, pos); ok {
+			return e, p, true
+		}
+	}
+	return nil, protocol.Position{}, false
+}
+
+// embeddedDiagnostics returns the diagnostics for each expression, at their
+// positions in host.
+func embeddedDiagnostics(exprs []*embeddedExpr, host string) []protocol.Diagnostic {
+	diagnostics := []protocol.Diagnostic{}
+	for _, e := range exprs {
+		diagnostics = append(diagnostics, e.diagnostics...)
+		for _, d := range computeDiagnostics(e.file.content, e.env) {
+			d.Range = e.hostRange(host, d.Range)
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// embeddedSemanticTokens returns the semantic tokens of each expression, at
+// their positions in host. Tokens that span lines of the host, such as
+// those split across concatenated strings, are dropped.
+func embeddedSemanticTokens(exprs []*embeddedExpr, host string) *protocol.SemanticTokens {
+	var tokens []tokenInfo
+	for _, e := range exprs {
+		for _, tok := range collectSemanticTokens(e.file, e.env) {
+			start := lineColToByteOffset(e.file.content, tok.line, tok.col)
+			end := lineColToByteOffset(e.file.content, tok.line, tok.col+tok.length)
+			if start < 0 || end < 0 {
+				continue
+			}
+			hostStart, hostEnd := e.offsets[start], e.offsets[end]
+			var length uint32
+			for _, r := range host[hostStart:hostEnd] {
+				if r == '\n' {
+					length = 0
+					break
+				}
+				length += uint32(utf16.RuneLen(r))
+			}
+			if length == 0 {
+				continue
+			}
+			tok.line, tok.col = byteOffsetToLineCol(host, hostStart)
+			tok.length = length
+			tokens = append(tokens, tok)
+		}
+	}
+	return encodeTokens(tokens)
+}
@@ -0,0 +1,379 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// envEntry caches the CEL environment built from a single config file.
+type envEntry struct {
+	// inputs holds the contents of the config file and every file it
+	// references, keyed by path. The entry is rebuilt when any of them
+	// change.
+	inputs map[string][]byte
+	// stamps identify the version of each input, and of each directory
+	// that was listed, that the entry was built from.
+	stamps map[string]fileStamp
+	env    *cel.Env
+	errs   []configError
+	// published records whether diagnostics for the inputs have been sent
+	// to the client.
+	published bool
+}
+
+// envKey identifies a cached environment by the config file it's built from,
+// if any, and the profile selected by a document's directive, if any.
+type envKey struct {
+	configPath string
+	profile    string
+}
+
+// envFor returns the CEL environment for the document at uri. The
+// environment is built from the nearest config file in the document's
+// directory or its ancestors, and the profile the document selects, falling
+// back to the default environment when there is neither or it cannot be
+// loaded. The variables and container the document's directives declare are
+// added to it.
+func (s *server) envFor(uri protocol.DocumentURI) *cel.Env {
+	var content string
+	s.mu.Lock()
+	if f := s.files[uri]; f != nil {
+		content = f.content
+	}
+	s.mu.Unlock()
+	path, err := uri.Path()
+	if err != nil {
+		path = ""
+	}
+	return s.documentEnv(path, content)
+}
+
+// documentEnv is like envFor, for the document at path, if any, with the
+// given content.
+func (s *server) documentEnv(path, content string) *cel.Env {
+	key := envKey{profile: fileProfile(content)}
+	if path != "" {
+		key.configPath = s.findConfig(filepath.Dir(path))
+	}
+	env := s.celEnv
+	if key != (envKey{}) {
+		if entry := s.loadEnv(key); len(entry.errs) == 0 {
+			env = entry.env
+		}
+	}
+	return withDirectives(env, content)
+}
+
+// findConfig walks up from dir looking for a config file. The search stops
+// at the workspace root containing dir, if any, or at the filesystem root.
+// Results are cached until a config file is opened or closed, or the client
+// reports that watched files changed.
+func (s *server) findConfig(dir string) string {
+	s.mu.Lock()
+	roots := s.roots
+	configPath, ok := s.configs[dir]
+	s.mu.Unlock()
+	if ok {
+		return configPath
+	}
+
+	start := dir
+	for {
+		candidate := filepath.Join(dir, configFileName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			configPath = candidate
+			break
+		}
+		parent := filepath.Dir(dir)
+		if slices.Contains(roots, dir) || parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	s.mu.Lock()
+	s.configs[start] = configPath
+	s.mu.Unlock()
+	return configPath
+}
+
+// isConfigURI reports whether uri is that of a config file, whose opening
+// or closing may change which config applies to other documents.
+func isConfigURI(uri protocol.DocumentURI) bool {
+	path, err := uri.Path()
+	return err == nil && filepath.Base(path) == configFileName
+}
+
+// forgetConfigs clears the cached results of findConfig.
+func (s *server) forgetConfigs() {
+	s.mu.Lock()
+	clear(s.configs)
+	s.mu.Unlock()
+}
+
+// loadEnv returns the environment for key, rebuilding it if the config or
+// any file it references has changed since it was last loaded. Changes are
+// detected by stamp, so that files aren't reread on every request.
+func (s *server) loadEnv(key envKey) *envEntry {
+	s.mu.Lock()
+	cached := s.envs[key]
+	s.mu.Unlock()
+
+	if cached != nil && !s.inputsChanged(cached.stamps) {
+		return cached
+	}
+
+	entry := &envEntry{inputs: make(map[string][]byte), stamps: make(map[string]fileStamp)}
+	entry.env, entry.errs = s.buildEnv(key, s.recordingReader(entry.inputs, entry.stamps), s.recordingLister(entry.stamps))
+
+	s.mu.Lock()
+	s.envs[key] = entry
+	s.mu.Unlock()
+	return entry
+}
+
+// hostEnv is like loadEnv, for the expressions of a host document. It also
+// reads the environment's inputs with read, which records them, so that the
+// expressions are extracted again when any of them change.
+func (s *server) hostEnv(key envKey, read func(string) ([]byte, error)) *envEntry {
+	entry := s.loadEnv(key)
+	for path := range entry.inputs {
+		_, _ = read(path)
+	}
+	return entry
+}
+
+// fileStamp identifies a version of a file or directory without reading it:
+// by its content if it's an open document, and otherwise by its size and
+// modification time.
+type fileStamp struct {
+	open    bool
+	content string
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func (a fileStamp) equal(b fileStamp) bool {
+	return a.open == b.open && a.content == b.content && a.exists == b.exists &&
+		a.size == b.size && a.modTime.Equal(b.modTime)
+}
+
+// stamp returns the current stamp of the file or directory at path.
+func (s *server) stamp(path string) fileStamp {
+	s.mu.Lock()
+	f := s.files[protocol.URIFromPath(path)]
+	s.mu.Unlock()
+	if f != nil {
+		return fileStamp{open: true, content: f.content}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// inputsChanged reports whether any of the stamped files or directories
+// have changed since they were stamped.
+func (s *server) inputsChanged(stamps map[string]fileStamp) bool {
+	for path, stamp := range stamps {
+		if !s.stamp(path).equal(stamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingReader returns a function that reads files with readFile,
+// recording the contents of each in inputs and its stamp in stamps. Missing
+// files are recorded too, so that creating one is noticed.
+func (s *server) recordingReader(inputs map[string][]byte, stamps map[string]fileStamp) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		// Stamp the file before reading it, so that a change in between
+		// causes another rebuild rather than being missed.
+		stamps[path] = s.stamp(path)
+		data, err := s.readFile(path)
+		inputs[path] = data
+		return data, err
+	}
+}
+
+// recordingLister returns a function that lists directories, recording the
+// stamp of each in stamps, so that adding or removing a file is noticed.
+func (s *server) recordingLister(stamps map[string]fileStamp) func(string) ([]fs.DirEntry, error) {
+	return func(dir string) ([]fs.DirEntry, error) {
+		stamps[dir] = s.stamp(dir)
+		return os.ReadDir(dir)
+	}
+}
+
+// readFile returns the contents of the file at path, preferring the content
+// of an open document over what's on disk so that unsaved edits take effect
+// immediately.
+func (s *server) readFile(path string) ([]byte, error) {
+	s.mu.Lock()
+	f := s.files[protocol.URIFromPath(path)]
+	s.mu.Unlock()
+	if f != nil {
+		return []byte(f.content), nil
+	}
+	return os.ReadFile(path)
+}
+
+// buildEnv loads the config file of key, if any, along with any files it
+// references, and creates the environment it declares for key's profile.
+func (s *server) buildEnv(key envKey, read func(string) ([]byte, error), list func(string) ([]fs.DirEntry, error)) (*cel.Env, []configError) {
+	configPath := key.configPath
+	cfg := &config{}
+	var dir string
+	if configPath != "" {
+		data, err := read(configPath)
+		if err != nil {
+			return nil, []configError{{path: configPath, line: -1, msg: err.Error()}}
+		}
+		cfg, err = parseConfig(data)
+		if err != nil {
+			return nil, configErrors(configPath, data, err)
+		}
+		dir = filepath.Dir(configPath)
+	}
+
+	profile := cfg.Profile
+	if key.profile != "" {
+		profile = key.profile
+	}
+	descs, errs := cfg.loadProtoTypes(dir, read, list)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var opts []cel.EnvOption
+	// Types come first, so that the profile and later declarations can refer
+	// to them.
+	if len(descs) > 0 {
+		opts = append(opts, protoTypeDescs(descs))
+	}
+	profileOpts, errs := cfg.profileOptions(profile, dir, read)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	envConfigs, errs := cfg.loadEnvironments(dir, read)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	opts = append(opts, profileOpts...)
+	opts = append(opts, cfg.envOptions(envConfigs)...)
+	newEnv := s.newEnv
+	if subsetsStdLib(envConfigs) {
+		newEnv = s.newCustomEnv
+	}
+	celEnv, err := newEnv(opts...)
+	if err != nil {
+		// Errors from referenced environment files are attributed to the
+		// file that caused them.
+		errPath := configPath
+		var fe *fileError
+		if errors.As(err, &fe) {
+			errPath, err = fe.path, fe.err
+		}
+		data, _ := read(errPath)
+		return nil, configErrors(errPath, data, err)
+	}
+	return celEnv, nil
+}
+
+// newEnv creates a CEL environment from the server's base options followed
+// by opts.
+func (s *server) newEnv(opts ...cel.EnvOption) (*cel.Env, error) {
+	return cel.NewEnv(append(slices.Clone(s.envOptions), opts...)...)
+}
+
+// newCustomEnv is like newEnv, but the standard library is only included if
+// opts include it.
+func (s *server) newCustomEnv(opts ...cel.EnvOption) (*cel.Env, error) {
+	return cel.NewCustomEnv(append(slices.Clone(s.envOptions), opts...)...)
+}
+
+// configDocument reports whether the document at path is a config file, or
+// a file referenced by one, returning the path of the config file.
+func (s *server) configDocument(path string) (string, bool) {
+	if filepath.Base(path) == configFileName {
+		return path, true
+	}
+	configPath := s.findConfig(filepath.Dir(path))
+	if configPath == "" {
+		return "", false
+	}
+	entry := s.loadEnv(envKey{configPath: configPath})
+	if data, ok := entry.inputs[path]; ok && data != nil {
+		return configPath, true
+	}
+	return "", false
+}
+
+// publishConfigDiagnostics pushes diagnostics for the config file at
+// configPath and every file it references. Diagnostics are only sent when
+// the environment has been rebuilt since they were last published.
+func (s *server) publishConfigDiagnostics(conn *jsonrpc2.Conn, configPath string) {
+	if configPath == "" {
+		return
+	}
+	entry := s.loadEnv(envKey{configPath: configPath})
+	s.mu.Lock()
+	published := entry.published
+	entry.published = true
+	s.mu.Unlock()
+	if published {
+		return
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(entry.inputs)) {
+		if entry.inputs[path] == nil {
+			continue
+		}
+		_ = conn.Notify(context.Background(), "textDocument/publishDiagnostics", protocol.PublishDiagnosticsParams{
+			URI:         protocol.URIFromPath(path),
+			Diagnostics: s.configDiagnostics(path, configPath),
+		})
+	}
+}
+
+// configDiagnostics returns the diagnostics for the file at path, which is
+// either the config file at configPath or a file it references. Files that
+// embed CEL, such as .proto files, get the diagnostics of their expressions
+// too.
+func (s *server) configDiagnostics(path, configPath string) []protocol.Diagnostic {
+	entry := s.loadEnv(envKey{configPath: configPath})
+	content := entry.inputs[path]
+	diagnostics := []protocol.Diagnostic{}
+	for _, e := range entry.errs {
+		if e.path != path {
+			continue
+		}
+		line := max(e.line, 0)
+		diagnostics = append(diagnostics, protocol.Diagnostic{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: uint32(line)},
+				End:   endOfLine(string(content), line),
+			},
+			Severity: protocol.SeverityError,
+			Source:   serverName,
+			Message:  e.msg,
+		})
+	}
+	if exprs, ok := s.embeddedIn(path, string(content)); ok {
+		diagnostics = append(diagnostics, embeddedDiagnostics(exprs, string(content))...)
+	}
+	return diagnostics
+}
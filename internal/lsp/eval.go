@@ -0,0 +1,256 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// exprName names an expression given to Eval on its own, rather than in a
+// file, in reports.
+const exprName = "<expression>"
+
+// An Evaluation is an expression for Eval to evaluate, and the values of its
+// variables.
+type Evaluation struct {
+	// Path is the path of the file the expression is in, if any, whose
+	// config file, directives and sample inputs apply to it. Without one,
+	// the config file that applies to the current directory does.
+	Path string
+	Expr string
+	// InputPath is the path of a file of inputs, if any: a JSON or YAML
+	// mapping of variable names to values. Variables that aren't declared
+	// are declared as dyn.
+	InputPath string
+	// Vars bind variables, each as name=type:value or name=value, where
+	// the value is written as in a file of inputs. A variable that isn't
+	// declared is declared with the type, or as dyn.
+	Vars []string
+}
+
+// A Result is the value an expression evaluates to.
+type Result struct {
+	Value ref.Val
+	// Type is the type the expression is checked to have.
+	Type *cel.Type
+}
+
+// String returns the value and its type, as inlay hints show them.
+func (r *Result) String() string {
+	return fmt.Sprintf("%s (%s)", resultToString(r.Value), r.Type)
+}
+
+// MarshalJSON returns the value, as JSON, and its type. Values without a
+// JSON representation, such as types, are given as they're shown by String.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	var value json.RawMessage
+	if native, err := r.Value.ConvertToNative(reflect.TypeFor[*structpb.Value]()); err == nil {
+		value, err = protojson.Marshal(native.(*structpb.Value))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		value, _ = json.Marshal(resultToString(r.Value))
+	}
+	return json.Marshal(struct {
+		Value json.RawMessage `json:"value"`
+		Type  string          `json:"type"`
+	}{value, r.Type.String()})
+}
+
+// Eval evaluates ev's expression in the environment the server gives the
+// file it's in. If the expression or its inputs are invalid, or evaluating
+// it fails, there's no result, and reports of the problems, at their
+// positions in the expression and the file of inputs, are returned instead.
+// The error is for inputs that can't be read, variable bindings that are
+// malformed, and cancellation of ctx.
+func Eval(ctx context.Context, ev Evaluation, opts ...Option) (*Result, []Report, error) {
+	s, err := newServer(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name, path := exprName, ev.Path
+	if path == "" {
+		path = exprName
+	} else {
+		name = ev.Path
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, nil, err
+	}
+	env := s.documentEnv(path, ev.Expr)
+
+	// The sample inputs the editor would use come first, and the inputs
+	// given to Eval override them.
+	vars := make(map[string]any)
+	if ev.Path != "" {
+		maps.Copy(vars, inputFileVars(path, env, s.readFile))
+	}
+	if in, ok := commentInput(ev.Expr); ok {
+		inputs, _ := in.bind(env)
+		maps.Copy(vars, inputs)
+	}
+
+	bindings := make([]varBinding, 0, len(ev.Vars))
+	for _, v := range ev.Vars {
+		b, err := parseVarBinding(v, env)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b.declare {
+			if env, err = env.Extend(cel.Variable(b.name, b.typ)); err != nil {
+				return nil, nil, fmt.Errorf("--var %s: %w", v, err)
+			}
+		}
+		bindings = append(bindings, b)
+	}
+
+	var reports []Report
+	if ev.InputPath != "" {
+		data, err := os.ReadFile(ev.InputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		in := &sampleInput{host: string(data), text: string(data)}
+		if env, err = declareInputs(env, in); err != nil {
+			return nil, nil, err
+		}
+		inputs, diagnostics := in.bind(env)
+		if len(diagnostics) > 0 {
+			reports = append(reports, Report{Path: ev.InputPath, Content: string(data), Diagnostics: diagnostics})
+		}
+		maps.Copy(vars, inputs)
+	}
+
+	c := &inputConverter{provider: env.CELTypeProvider(), adapter: env.CELTypeAdapter()}
+	for _, b := range bindings {
+		val, err := c.convert(b.value, b.typ)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--var %s: %s", b.arg, err.msg)
+		}
+		vars[b.name] = val
+	}
+
+	if diagnostics := computeDiagnostics(ev.Expr, env); len(diagnostics) > 0 {
+		reports = append(reports, Report{Path: name, Content: ev.Expr, Diagnostics: diagnostics})
+	}
+	if len(reports) > 0 {
+		return nil, reports, nil
+	}
+
+	checked, iss := env.Compile(ev.Expr)
+	if iss.Err() != nil {
+		return nil, nil, iss.Err()
+	}
+	prog, err := env.Program(checked, cel.InterruptCheckFrequency(interruptCheckFrequency))
+	if err != nil {
+		return nil, nil, err
+	}
+	val, _, err := prog.ContextEval(ctx, vars)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, []Report{{Path: name, Content: ev.Expr, Diagnostics: []protocol.Diagnostic{evalDiagnostic(ev.Expr, checked, err)}}}, nil
+	}
+	return &Result{Value: val, Type: checked.OutputType()}, nil, nil
+}
+
+// evalDiagnostic returns the diagnostic for err, the error of evaluating the
+// checked expr, at the subexpression that failed if it's known.
+func evalDiagnostic(expr string, checked *cel.Ast, err error) protocol.Diagnostic {
+	start, end := 0, len(strings.TrimRight(expr, " \t\r\n"))
+	var celErr *types.Err
+	if errors.As(err, &celErr) && celErr.NodeID() != 0 {
+		if r, ok := checked.NativeRep().SourceInfo().GetOffsetRange(celErr.NodeID()); ok && r.Stop > r.Start {
+			start, end = celRuneOffsetToByteOffset(expr, r.Start), celRuneOffsetToByteOffset(expr, r.Stop)
+		}
+	}
+	return hostDiagnostic(expr, start, end, err.Error())
+}
+
+// varBinding is a variable binding given to Eval.
+type varBinding struct {
+	// arg is the binding as it was given.
+	arg  string
+	name string
+	typ  *cel.Type
+	// declare is set if the variable isn't declared.
+	declare bool
+	value   *yaml.Node
+}
+
+// parseVarBinding parses arg, a variable binding, as name=type:value or
+// name=value, with the types of variables declared in celEnv.
+func parseVarBinding(arg string, celEnv *cel.Env) (varBinding, error) {
+	name, rest, ok := strings.Cut(arg, "=")
+	if !ok || !isValidIdentifier(name) {
+		return varBinding{}, fmt.Errorf("--var %s: expected name=type:value or name=value", arg)
+	}
+	b := varBinding{arg: arg, name: name, typ: cel.DynType, declare: true}
+	for _, v := range celEnv.Variables() {
+		if v.Name() == name {
+			b.typ, b.declare = v.Type(), false
+		}
+	}
+
+	// The value may itself contain a colon, so what comes before the first
+	// one is only a type if it's a valid one.
+	value := rest
+	if typeName, after, ok := strings.Cut(rest, ":"); ok {
+		if t, err := parseCELType(strings.TrimSpace(typeName), celEnv); err == nil {
+			if !b.declare && !t.IsExactType(b.typ) {
+				return varBinding{}, fmt.Errorf("--var %s: %s is declared as %s", arg, name, b.typ)
+			}
+			b.typ, value = t, after
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return varBinding{}, fmt.Errorf("--var %s: %v", arg, err)
+	}
+	b.value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	if len(doc.Content) > 0 {
+		b.value = doc.Content[0]
+	}
+	return b, nil
+}
+
+// declareInputs returns celEnv extended with a dyn variable for each input
+// of in that isn't declared.
+func declareInputs(celEnv *cel.Env, in *sampleInput) (*cel.Env, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(in.text), &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		// bind reports malformed inputs.
+		return celEnv, nil
+	}
+	declared := make(map[string]bool)
+	for _, v := range celEnv.Variables() {
+		declared[v.Name()] = true
+	}
+	var opts []cel.EnvOption
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if name := root.Content[i].Value; isValidIdentifier(name) && !declared[name] {
+			declared[name] = true
+			opts = append(opts, cel.Variable(name, cel.DynType))
+		}
+	}
+	return celEnv.Extend(opts...)
+}
@@ -0,0 +1,142 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+func TestEval(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		ev   lsp.Evaluation
+		want string
+	}{
+		{
+			name: "expression",
+			ev:   lsp.Evaluation{Expr: "[1, 2, 3].map(x, x * 2)"},
+			want: "[2, 4, 6] (list(int))",
+		},
+		{
+			name: "vars",
+			ev:   lsp.Evaluation{Expr: "x + y", Vars: []string{"x=int:3", "y=4"}},
+			want: "7 (int)",
+		},
+		{
+			name: "var with colon",
+			ev:   lsp.Evaluation{Expr: "url", Vars: []string{"url=https://example.com"}},
+			want: `"https://example.com" (dyn)`,
+		},
+		{
+			name: "message var",
+			ev:   lsp.Evaluation{Expr: "t", Vars: []string{"t=google.protobuf.Timestamp:2024-05-01T00:00:00Z"}},
+			want: `timestamp("2024-05-01T00:00:00Z") (google.protobuf.Timestamp)`,
+		},
+		{
+			name: "file with sample inputs",
+			ev:   lsp.Evaluation{Path: "testdata/eval/limit.cel", Expr: readFile(t, "testdata/eval/limit.cel")},
+			want: "5 (int)",
+		},
+		{
+			name: "input file",
+			ev:   lsp.Evaluation{Path: "testdata/eval/limit.cel", Expr: readFile(t, "testdata/eval/limit.cel"), InputPath: "testdata/eval/inputs.yaml"},
+			want: "2 (int)",
+		},
+		{
+			name: "var overrides input file",
+			ev:   lsp.Evaluation{Path: "testdata/eval/limit.cel", Expr: readFile(t, "testdata/eval/limit.cel"), InputPath: "testdata/eval/inputs.yaml", Vars: []string{"n=10"}},
+			want: "1 (int)",
+		},
+		{
+			name: "undeclared inputs",
+			ev:   lsp.Evaluation{Expr: "tags", InputPath: "testdata/eval/inputs.yaml"},
+			want: `["a", "b"] (dyn)`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			result, reports, err := lsp.Eval(t.Context(), tc.ev)
+			be.Err(t, err, nil)
+			be.Equal(t, len(reports), 0)
+			be.Equal(t, result.String(), tc.want)
+		})
+	}
+}
+
+func TestEvalReports(t *testing.T) {
+	t.Parallel()
+
+	// Evaluation errors are reported at the subexpression that failed.
+	_, reports, err := lsp.Eval(t.Context(), lsp.Evaluation{
+		Path: "testdata/eval/limit.cel",
+		Expr: readFile(t, "testdata/eval/limit.cel"),
+		Vars: []string{"n=0"},
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+	be.Equal(t, reports[0].Path, "testdata/eval/limit.cel")
+	be.Equal(t, reports[0].Diagnostics[0].Message, "division by zero")
+	be.Equal(t, reports[0].Diagnostics[0].Range, lineRange(2, 6, 7))
+
+	// So are problems with the expression, and with the file of inputs.
+	_, reports, err = lsp.Eval(t.Context(), lsp.Evaluation{
+		Path:      "testdata/eval/limit.cel",
+		Expr:      "// cells:var limit int\nlimit + missing",
+		InputPath: "testdata/eval/invalid.yaml",
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 2)
+	be.Equal(t, reports[0].Path, "testdata/eval/invalid.yaml")
+	be.Equal(t, reports[0].Diagnostics[0].Message, "limit: expected int")
+	be.Equal(t, reports[1].Path, "testdata/eval/limit.cel")
+	be.Equal(t, reports[1].Diagnostics[0].Range, lineRange(1, 8, 15))
+
+	// An expression given on its own is named for the reports.
+	_, reports, err = lsp.Eval(t.Context(), lsp.Evaluation{Expr: "1 +"})
+	be.Err(t, err, nil)
+	be.Equal(t, reports[0].Path, "<expression>")
+}
+
+func TestEvalVarErrors(t *testing.T) {
+	t.Parallel()
+
+	limit := readFile(t, "testdata/eval/limit.cel")
+	for _, v := range []string{"n", "1x=2", "n=five", "n=string:five", "n=[1"} {
+		_, _, err := lsp.Eval(t.Context(), lsp.Evaluation{Path: "testdata/eval/limit.cel", Expr: limit, Vars: []string{v}})
+		be.True(t, err != nil)
+	}
+}
+
+func TestEvalJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{`{"a": [1, 2.5, true, null]}`, `{"value":{"a":[1,2.5,true,null]},"type":"map(string, list(dyn))"}`},
+		{`duration("90s")`, `{"value":"90s","type":"google.protobuf.Duration"}`},
+		// Types have no JSON representation.
+		{`int`, `{"value":"int","type":"type(int)"}`},
+	} {
+		result, _, err := lsp.Eval(t.Context(), lsp.Evaluation{Expr: tc.expr})
+		be.Err(t, err, nil)
+		data, err := json.Marshal(result)
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), tc.want)
+	}
+}
+
+// readFile returns the content of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	be.Err(t, err, nil)
+	return string(content)
+}
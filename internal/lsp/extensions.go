@@ -0,0 +1,187 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/env"
+	"github.com/google/cel-go/ext"
+	"go.yaml.in/yaml/v3"
+)
+
+// extensionNames are the short names of the extension libraries in cel-go's
+// ext package, as accepted by ext.ExtensionOptionFactory, plus the optional
+// types library from the cel package.
+var extensionNames = []string{
+	"bindings",
+	"encoders",
+	"lists",
+	"math",
+	"optional",
+	"protos",
+	"regex",
+	"sets",
+	"strings",
+	"two-var-comprehensions",
+}
+
+// extension selects one of cel-go's extension libraries. It's written either
+// as a bare name, which selects the latest version, or as a name and version:
+//
+//	extensions:
+//	  - strings
+//	  - name: math
+//	    version: 1
+type extension struct {
+	Name string `yaml:"name" json:"name"`
+	// Version is a version number or "latest", the default.
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+// UnmarshalYAML accepts either a bare name or a mapping.
+func (e *extension) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Name = node.Value
+		return nil
+	}
+	type plain extension
+	return node.Decode((*plain)(e))
+}
+
+// UnmarshalJSON accepts either a bare name or an object, whose version may be
+// a number or a string.
+func (e *extension) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.Name); err == nil {
+		return nil
+	}
+	var obj struct {
+		Name    string          `json:"name"`
+		Version json.RawMessage `json:"version"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	e.Name = obj.Name
+	// A null version, like a missing one, selects the latest.
+	if version := string(obj.Version); version != "null" {
+		e.Version = strings.Trim(version, `"`)
+	}
+	return nil
+}
+
+// envExtension returns the extension in cel-go's config format.
+func (e extension) envExtension() *env.Extension {
+	version := e.Version
+	if version == "" {
+		version = "latest"
+	}
+	return &env.Extension{Name: e.Name, Version: version}
+}
+
+// validate reports whether the extension names a known library and version.
+func (e extension) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("extension: missing name")
+	}
+	if _, err := e.envExtension().VersionNumber(); err != nil {
+		return fmt.Errorf("extension %q: invalid version %q", e.Name, e.Version)
+	}
+	if _, ok := e.option(); !ok {
+		return fmt.Errorf("extension %q: unknown library, expected one of %s", e.Name, strings.Join(extensionNames, ", "))
+	}
+	return nil
+}
+
+// option returns the option enabling the extension, if it names a known
+// library.
+func (e extension) option() (cel.EnvOption, bool) {
+	envExt := e.envExtension()
+	if e.Name == "optional" {
+		// The optional types library isn't in the ext package, so the
+		// factory doesn't know about it.
+		version, _ := envExt.VersionNumber()
+		return cel.OptionalTypes(cel.OptionalTypesVersion(version)), true
+	}
+	return ext.ExtensionOptionFactory(envExt)
+}
+
+// extensionOptions returns the options enabling the given extensions, which
+// must already be validated.
+func extensionOptions(exts []extension) []cel.EnvOption {
+	var opts []cel.EnvOption
+	for _, e := range exts {
+		if opt, ok := e.option(); ok {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// namespacedMacro describes a receiver-style macro from an extension library
+// that is only expanded when called on a namespace, like math.greatest(...).
+// cel-go doesn't document these, so their descriptions are kept here.
+type namespacedMacro struct {
+	namespace   string
+	description string
+}
+
+var namespacedMacros = map[string]namespacedMacro{
+	"greatest": {"math", "Returns the greatest of its numeric arguments, or of the elements of a single numeric list."},
+	"least":    {"math", "Returns the least of its numeric arguments, or of the elements of a single numeric list."},
+	"bind":     {"cel", "Binds a name to the value of an expression, for use within a second expression: `cel.bind(name, init, expr)`."},
+	"getExt":   {"proto", "Returns the value of a protobuf extension field of a message: `proto.getExt(msg, fully.qualified.extension)`."},
+	"hasExt":   {"proto", "Tests whether a protobuf extension field of a message is set: `proto.hasExt(msg, fully.qualified.extension)`."},
+}
+
+// isNamespacedMacroCall reports whether call is a namespaced macro called on
+// its namespace, as recorded in the source info's macro calls.
+func isNamespacedMacroCall(call ast.CallExpr) bool {
+	m, ok := namespacedMacros[call.FunctionName()]
+	if !ok || !call.IsMemberFunction() {
+		return false
+	}
+	target := call.Target()
+	return target.Kind() == ast.IdentKind && target.AsIdent() == m.namespace
+}
+
+// qualifiedFunctionName returns the name of the namespaced function a
+// member-style call refers to, such as "math.abs" for math.abs(x), if the
+// environment declares it. The parser can't tell these apart from method
+// calls on a variable, so they're resolved against the environment.
+func qualifiedFunctionName(call ast.CallExpr, celEnv *cel.Env) (string, bool) {
+	if !call.IsMemberFunction() {
+		return "", false
+	}
+	qualifier, ok := qualifiedIdent(call.Target())
+	if !ok {
+		return "", false
+	}
+	name := qualifier + "." + call.FunctionName()
+	if _, ok := celEnv.Functions()[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// qualifiedIdent returns the dotted name of an identifier or a chain of field
+// selections on one, like "a.b.c".
+func qualifiedIdent(expr ast.Expr) (string, bool) {
+	switch expr.Kind() {
+	case ast.IdentKind:
+		return expr.AsIdent(), true
+	case ast.SelectKind:
+		sel := expr.AsSelect()
+		if sel.IsTestOnly() {
+			return "", false
+		}
+		operand, ok := qualifiedIdent(sel.Operand())
+		if !ok {
+			return "", false
+		}
+		return operand + "." + sel.FieldName(), true
+	}
+	return "", false
+}
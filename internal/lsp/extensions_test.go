@@ -0,0 +1,83 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestExtensionsDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/extensions/uses_extensions.cel"))
+	be.Equal(t, len(pullDiagnostics(t, conn, uri)), 0)
+
+	// Without a config, extension functions are undeclared.
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/extensions_default/upper_ascii.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "undeclared reference to 'upperAscii'"))
+}
+
+func TestExtensionsInitializationOptions(t *testing.T) {
+	t.Parallel()
+
+	file := getAbsPath(t, "testdata/extensions_default/upper_ascii.cel")
+	conn, uri := setupLSPServerWithOptions(t, file, map[string]any{
+		"extensions": []any{
+			"strings",
+			map[string]any{"name": "math", "version": 2},
+			map[string]any{"name": "lists", "version": nil},
+		},
+	})
+	be.Equal(t, len(pullDiagnostics(t, conn, uri)), 0)
+}
+
+func TestExtensionsHover(t *testing.T) {
+	t.Parallel()
+
+	file := "testdata/extensions/uses_extensions.cel"
+	requireHoverContains(t, file, 0, 8, "upperAscii", "member function from the strings extension")
+	requireHoverContains(t, file, 0, 40, "**Macro**: `math.greatest`", "namespaced macro")
+	requireHoverContains(t, file, 0, 63, "math.abs", "namespaced function, on its namespace")
+	requireHoverContains(t, file, 0, 66, "math.abs", "namespaced function, on its name")
+	requireHoverContains(t, file, 0, 118, "**Macro**: `cel.bind`", "bind macro")
+}
+
+func TestExtensionsCompletion(t *testing.T) {
+	t.Parallel()
+
+	result := requestInvokedAtEnd(t, "testdata/extensions/string_dot.cel")
+	be.True(t, containsLabel(result.Items, "upperAscii"))
+
+	// After a namespace, only its functions and macros are offered.
+	result = requestInvokedAtEnd(t, "testdata/extensions/math_dot.cel")
+	be.True(t, containsLabel(result.Items, "abs"))
+	be.True(t, containsLabel(result.Items, "greatest"))
+	be.True(t, !containsLabel(result.Items, "upperAscii"))
+
+	result = requestInvokedCompletion(t, "testdata/extensions/uses_extensions.cel")
+	be.True(t, containsLabel(result.Items, "math.greatest"))
+	be.True(t, containsLabel(result.Items, "math.abs"))
+	be.True(t, containsLabel(result.Items, "proto.getExt"))
+	be.True(t, containsLabel(result.Items, "proto.hasExt"))
+	be.True(t, !containsLabel(result.Items, "getExt"))
+}
+
+func TestExtensionsSignatureHelp(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/extensions/namespaced_call.cel"))
+	result := requestSignatureHelp(t, conn, uri, protocol.Position{Line: 0, Character: 9})
+	be.True(t, result != nil)
+	be.True(t, len(result.Signatures) > 0)
+	be.True(t, containsSubstring([]string{result.Signatures[0].Label}, "math.abs("))
+}
+
+func TestExtensionsInlayHints(t *testing.T) {
+	t.Parallel()
+
+	hints := getInlayHints(t, "testdata/extensions/uses_extensions.cel")
+	be.Equal(t, len(hints), 1)
+	be.True(t, containsSubstring([]string{hints[0].Label[0].Value}, "true"))
+}
@@ -7,4 +7,6 @@ type file struct {
 	uri     protocol.DocumentURI
 	version int32
 	content string
+	// notebook is the notebook the document is a cell of, if any.
+	notebook *notebook
 }
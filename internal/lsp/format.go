@@ -2,6 +2,9 @@ package lsp
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/cel-go/cel"
@@ -9,6 +12,43 @@ import (
 	"github.com/stefanvanburen/cells/internal/lsp/protocol"
 )
 
+// ErrSkipped is the error of formatting a file that's left as it is, because
+// formatting it would lose something, such as its comments.
+var ErrSkipped = errors.New("skipped")
+
+// A Formatter formats .cel files, parsing each in the environment of the
+// config file that applies to it.
+type Formatter struct {
+	s *server
+}
+
+// NewFormatter returns a Formatter.
+func NewFormatter(opts ...Option) (*Formatter, error) {
+	s, err := newServer(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Formatter{s: s}, nil
+}
+
+// Format returns content, a .cel file at path, formatted. If path is empty,
+// the default environment applies. The error wraps ErrSkipped if the file
+// isn't formatted because formatting it would lose something, and is a
+// syntax error if it can't be parsed.
+func (f *Formatter) Format(path, content string) (string, error) {
+	if definitionPattern.MatchString(content) {
+		return content, fmt.Errorf("%w: files of definitions aren't formatted", ErrSkipped)
+	}
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		path = abs
+	}
+	return formatCEL(content, f.s.documentEnv(path, content))
+}
+
 func (s *server) formatting(req *jsonrpc2.Request) (any, error) {
 	var params protocol.DocumentFormattingParams
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
@@ -19,11 +59,11 @@ func (s *server) formatting(req *jsonrpc2.Request) (any, error) {
 	f := s.files[params.TextDocument.URI]
 	s.mu.Unlock()
 
-	if f == nil {
+	if f == nil || hostDocument(f.uri) {
 		return nil, nil
 	}
 
-	formatted, err := formatCEL(f.content, s.celEnv)
+	formatted, err := formatCEL(f.content, s.envFor(f.uri))
 	if err != nil {
 		// If formatting fails (e.g., parse error), return no edits.
 		return nil, nil
@@ -62,7 +102,7 @@ func formatCEL(content string, celEnv *cel.Env) (string, error) {
 	leading, expr, trailing, ok := splitComments(content)
 	if !ok {
 		// Comments interleaved with expression — unsafe to format.
-		return content, nil
+		return content, fmt.Errorf("%w: comments within the expression would be lost", ErrSkipped)
 	}
 
 	ast, iss := celEnv.Parse(expr)
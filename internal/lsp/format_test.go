@@ -2,6 +2,7 @@ package lsp_test
 
 import (
 	"context"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
@@ -128,3 +129,38 @@ func applyEdits(content string, edits []protocol.TextEdit) string {
 	// We always emit a single whole-document replacement.
 	return edits[0].NewText
 }
+
+func TestFormatter(t *testing.T) {
+	t.Parallel()
+
+	formatter, err := lsp.NewFormatter()
+	be.Err(t, err, nil)
+
+	// Files are formatted as they are by textDocument/formatting.
+	input, err := os.ReadFile("testdata/format/spacing.input.cel")
+	be.Err(t, err, nil)
+	golden, err := os.ReadFile("testdata/format/spacing.golden.cel")
+	be.Err(t, err, nil)
+	got, err := formatter.Format("testdata/format/spacing.input.cel", string(input))
+	be.Err(t, err, nil)
+	be.Equal(t, got, string(golden))
+
+	// Standard input has no path.
+	got, err = formatter.Format("", "1+  2\n")
+	be.Err(t, err, nil)
+	be.Equal(t, got, "1 + 2\n")
+
+	// Files whose formatting would lose something are skipped.
+	interleaved, err := os.ReadFile("testdata/format/interleaved_comment.input.cel")
+	be.Err(t, err, nil)
+	got, err = formatter.Format("testdata/format/interleaved_comment.input.cel", string(interleaved))
+	be.Err(t, err, lsp.ErrSkipped)
+	be.Equal(t, got, string(interleaved))
+
+	_, err = formatter.Format("", "limits := {'max': 10}\n")
+	be.Err(t, err, lsp.ErrSkipped)
+
+	// Invalid expressions can't be formatted.
+	_, err = formatter.Format("", "1 +\n")
+	be.True(t, err != nil && !errors.Is(err, lsp.ErrSkipped))
+}
@@ -0,0 +1,173 @@
+package lsp
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+)
+
+// celImportPath is the import path of cel-go's main package, whose
+// environments' Compile and Parse methods take expressions.
+const celImportPath = "github.com/google/cel-go/cel"
+
+// goExpressions returns the CEL expressions in the Go source file at path:
+// the string literals passed to the Compile and Parse methods of a cel-go
+// environment, and those marked with a "// cel" comment on the line before
+// or a "/* cel */" comment before them on the same line. They're analyzed
+// with the environment of the config file that applies to the file, if any.
+func (s *server) goExpressions(path, content string, read func(string) ([]byte, error)) []*embeddedExpr {
+	fset := token.NewFileSet()
+	// Even a file with syntax errors is partially parsed.
+	f, _ := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+	if f == nil {
+		return nil
+	}
+
+	// markers holds the cel comments, by line.
+	type marker struct {
+		// end is the offset of the end of the comment.
+		end int
+		// line is set for line comments, which mark the literals on the
+		// next line too.
+		line bool
+	}
+	markers := make(map[int]marker)
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			text := strings.TrimSuffix(c.Text[2:], "*/")
+			if strings.TrimSpace(text) == "cel" {
+				end := fset.Position(c.End())
+				markers[end.Line] = marker{end: end.Offset, line: strings.HasPrefix(c.Text, "//")}
+			}
+		}
+	}
+	// args holds the literals passed to Compile and Parse.
+	args := make(map[*ast.BasicLit]bool)
+	importsCEL := false
+	// packages holds the names of the imported packages, whose Compile and
+	// Parse functions, such as regexp.Compile and url.Parse, aren't cel-go
+	// environments' methods. A package is assumed to be named for the last
+	// element of its path unless it's imported with another name.
+	packages := make(map[string]bool)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if p == celImportPath {
+			importsCEL = true
+		}
+		if spec.Name != nil {
+			packages[spec.Name.Name] = true
+		} else {
+			packages[p[strings.LastIndex(p, "/")+1:]] = true
+		}
+	}
+
+	env := s.goEnv(path, read)
+	var exprs []*embeddedExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if x, ok := sel.X.(*ast.Ident); ok && packages[x.Name] {
+				return true
+			}
+			if importsCEL && (sel.Sel.Name == "Compile" || sel.Sel.Name == "Parse") && len(n.Args) == 1 {
+				if lit, ok := n.Args[0].(*ast.BasicLit); ok {
+					args[lit] = true
+				}
+			}
+		case *ast.BasicLit:
+			if n.Kind != token.STRING {
+				return true
+			}
+			pos := fset.Position(n.Pos())
+			marked := markers[pos.Line-1].line
+			if m, ok := markers[pos.Line]; ok && m.end <= pos.Offset {
+				marked = true
+			}
+			if args[n] || marked {
+				expr, offsets := goString(n.Value, pos.Offset)
+				exprs = append(exprs, &embeddedExpr{file: &file{content: expr}, offsets: offsets, env: env})
+			}
+		}
+		return true
+	})
+	return exprs
+}
+
+// goEnv returns the environment for the expressions in the Go source file
+// at path.
+func (s *server) goEnv(path string, read func(string) ([]byte, error)) *cel.Env {
+	configPath := s.findConfig(filepath.Dir(path))
+	if configPath == "" {
+		return s.celEnv
+	}
+	entry := s.hostEnv(envKey{configPath: configPath}, read)
+	if len(entry.errs) > 0 {
+		return s.celEnv
+	}
+	return entry.env
+}
+
+// goString returns the value of the Go string literal lit, which starts at
+// offset start, and the offset of the source of each of its bytes and its
+// end. The bytes of an escape sequence are mapped to where it starts.
+func goString(lit string, start int) (string, []int) {
+	if len(lit) < 2 {
+		return "", []int{start}
+	}
+	body := lit[1 : len(lit)-1]
+	var (
+		value   []byte
+		offsets []int
+	)
+	if lit[0] == '`' {
+		// Carriage returns are discarded from raw strings.
+		for i := 0; i < len(body); i++ {
+			if body[i] != '\r' {
+				value = append(value, body[i])
+				offsets = append(offsets, start+1+i)
+			}
+		}
+		return string(value), append(offsets, start+len(lit)-1)
+	}
+	for i := 0; i < len(body); {
+		r, multibyte, tail, err := strconv.UnquoteChar(body[i:], '"')
+		if err != nil {
+			// The literal is invalid, which the Go compiler reports.
+			value = append(value, body[i])
+			offsets = append(offsets, start+1+i)
+			i++
+			continue
+		}
+		n := len(body) - i - len(tail)
+		if !multibyte {
+			value = append(value, byte(r))
+			offsets = append(offsets, start+1+i)
+		} else if b := utf8.AppendRune(nil, r); strings.HasPrefix(body[i:], string(b)) {
+			// A character written as itself.
+			value = append(value, b...)
+			for k := range b {
+				offsets = append(offsets, start+1+i+k)
+			}
+		} else {
+			value = append(value, b...)
+			for range b {
+				offsets = append(offsets, start+1+i)
+			}
+		}
+		i += n
+	}
+	return string(value), append(offsets, start+len(lit)-1)
+}
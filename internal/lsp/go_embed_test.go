@@ -0,0 +1,63 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestGoEmbedDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	// The position of the error accounts for the escapes before it, and
+	// only the marked literals and those passed to Compile and Parse are
+	// analyzed.
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/go_embed/rules.go"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{"found no matching overload for '>' applied to '(int, string)'"})
+	be.Equal(t, diags[0].Range.Start, protocol.Position{Line: 17, Character: 85})
+
+	// The Compile and Parse functions of other packages don't take CEL.
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/go_embed/packages.go"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+	requireHoverContains(t, "testdata/go_embed/packages.go", 16, 25, "**Variable**: `request`", "environment's Parse")
+}
+
+func TestGoEmbedHover(t *testing.T) {
+	t.Parallel()
+
+	const file = "testdata/go_embed/rules.go"
+	tests := []struct {
+		name     string
+		line     uint32
+		char     uint32
+		contains string
+	}{
+		{"raw string", 9, 20, "**Variable**: `request`"},
+		{"second line of raw string", 10, 16, "test whether a string starts with a substring prefix"},
+		{"inline marker", 12, 33, "**Variable**: `request`"},
+		{"compile argument", 17, 75, "**Variable**: `request`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			requireHoverContains(t, file, tt.line, tt.char, tt.contains, tt.name)
+		})
+	}
+	requireNoHover(t, file, 23, 15, "unmarked literal")
+}
+
+func TestGoEmbedSemanticTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens := getSemanticTokens(t, "testdata/go_embed/rules.go")
+	assertTokens(t, tokens, []expectedToken{
+		{9, 19, 7, stProperty, "request in raw string"},
+		{10, 25, 8, stString, "string on second line of raw string"},
+		{17, 51, 15, stString, "string with escapes"},
+		{20, 26, 1, stNumber, "number in Parse argument"},
+	})
+	// Nothing outside the expressions is highlighted.
+	be.True(t, !findTokenOnLine(tokens, 14, stProperty))
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"slices"
 	"strings"
 
 	"github.com/google/cel-go/cel"
@@ -29,7 +30,18 @@ func (s *server) hover(req *jsonrpc2.Request) (any, error) {
 		return nil, nil
 	}
 
-	return computeHover(f, s.celEnv, params.Position)
+	if exprs, ok := s.embedded(f); ok {
+		e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+		if !ok {
+			return nil, nil
+		}
+		hover, err := computeHover(e.file, e.env, pos)
+		if hover != nil {
+			hover.Range = e.hostRange(f.content, hover.Range)
+		}
+		return hover, err
+	}
+	return computeHover(f, s.envFor(f.uri), params.Position)
 }
 
 // hoverInfo represents hover documentation for a CEL element.
@@ -40,6 +52,10 @@ type hoverInfo struct {
 }
 
 func computeHover(f *file, celEnv *cel.Env, pos protocol.Position) (*protocol.Hover, error) {
+	if hover := directiveHover(f.content, celEnv, pos); hover != nil {
+		return hover, nil
+	}
+
 	parsed, issues := celEnv.Parse(f.content)
 	if issues.Err() != nil {
 		return nil, nil
@@ -69,6 +85,9 @@ func computeHover(f *file, celEnv *cel.Env, pos protocol.Position) (*protocol.Ho
 
 	walkCELExprForHover(nativeAST.Expr(), sourceInfo, f.content, celEnv, collectHover, nil)
 	collectMacroHovers(sourceInfo, f.content, celEnv, collectHover)
+	if checked, issues := celEnv.Check(parsed); issues.Err() == nil {
+		collectFieldHovers(checked, f.content, celEnv, collectHover)
+	}
 
 	// Find the most specific (smallest) hover that contains the target offset.
 	var best *hoverInfo
@@ -119,26 +138,52 @@ func walkCELExprForHover(
 	switch expr.Kind() {
 	case ast.IdentKind:
 		identName := expr.AsIdent()
-		if hasOffset && isCELKeyword(identName) {
-			byteStart, byteStop := celOffsetRangeToByteRange(exprString, offsetRange)
+		if !hasOffset {
+			break
+		}
+		byteStart, byteStop := celOffsetRangeToByteRange(exprString, offsetRange)
+		if isCELKeyword(identName) {
 			collectHover(byteStart, byteStop, celKeywordHover(identName))
+		} else if !compVars[identName] {
+			collectHover(byteStart, byteStop, celVariableHover(identName, celEnv))
 		}
 
 	case ast.SelectKind:
 		sel := expr.AsSelect()
+		// A variable with a qualified name, such as variables.x, spans the
+		// whole selection.
+		if name, root, ok := qualifiedIdentName(expr); ok && hasOffset {
+			if md := celVariableHover(name, celEnv); md != "" {
+				rootRange, _ := sourceInfo.GetOffsetRange(root.ID())
+				start, _ := celOffsetRangeToByteRange(exprString, rootRange)
+				_, dotEnd := celOffsetRangeToByteRange(exprString, offsetRange)
+				collectHover(start, dotEnd+len(sel.FieldName()), md)
+				break
+			}
+		}
 		if sel.Operand() != nil {
 			walkCELExprForHover(sel.Operand(), sourceInfo, exprString, celEnv, collectHover, compVars)
 		}
 
 	case ast.CallKind:
 		call := expr.AsCall()
-		if call.IsMemberFunction() {
+		funcName := call.FunctionName()
+		qualifiedName, isQualified := qualifiedFunctionName(call, celEnv)
+		if call.IsMemberFunction() && !isQualified {
 			walkCELExprForHover(call.Target(), sourceInfo, exprString, celEnv, collectHover, compVars)
 		}
 
-		funcName := call.FunctionName()
-
-		if _, isOperator := celOperatorSymbol(funcName); isOperator {
+		if isQualified {
+			// A namespaced function, e.g. math.abs(x), whose name spans the
+			// call's target.
+			targetStart := sourceInfo.GetStartLocation(call.Target().ID())
+			if targetStart.Line() > 0 {
+				targetByteOffset := celRuneOffsetToByteOffset(exprString, int32(targetStart.Column())+sourceInfo.ComputeOffset(int32(targetStart.Line()), 0))
+				if _, end := findMethodNameAfterDot(targetByteOffset, funcName, exprString); end >= 0 {
+					collectHover(targetByteOffset, end, celFunctionHover(qualifiedName, celEnv))
+				}
+			}
+		} else if _, isOperator := celOperatorSymbol(funcName); isOperator {
 			if hasOffset {
 				byteStart, byteStop := celOffsetRangeToByteRange(exprString, offsetRange)
 				collectHover(byteStart, byteStop, celFunctionHover(funcName, celEnv))
@@ -240,7 +285,7 @@ func collectMacroHovers(
 		}
 		call := macroExpr.AsCall()
 		funcName := call.FunctionName()
-		if !isCELMacroFunction(funcName) {
+		if !isCELMacroFunction(funcName) && !isNamespacedMacroCall(call) {
 			continue
 		}
 
@@ -274,6 +319,36 @@ func collectMacroHovers(
 	}
 }
 
+// collectFieldHovers processes field selections on message types for hover
+// info. The type of each operand is only known after checking, so this works
+// from the checked AST rather than the parsed one.
+func collectFieldHovers(
+	checked *cel.Ast,
+	exprString string,
+	celEnv *cel.Env,
+	collectHover func(byteStart, byteEnd int, markdown string),
+) {
+	nativeAST := checked.NativeRep()
+	sourceInfo := nativeAST.SourceInfo()
+	for _, expr := range ast.MatchDescendants(ast.NavigateAST(nativeAST), ast.KindMatcher(ast.SelectKind)) {
+		sel := expr.AsSelect()
+		operandType := nativeAST.GetType(sel.Operand().ID())
+		if operandType.Kind() != types.StructKind {
+			continue
+		}
+		offsetRange, hasOffset := sourceInfo.GetOffsetRange(expr.ID())
+		if !hasOffset {
+			continue
+		}
+		// The offset of a select expression is that of its dot.
+		byteStart, _ := celOffsetRangeToByteRange(exprString, offsetRange)
+		start, end := findMethodNameAfterDot(byteStart, sel.FieldName(), exprString)
+		if start >= 0 {
+			collectHover(start, end, celFieldHover(operandType.TypeName(), sel.FieldName(), nativeAST.GetType(expr.ID()), celEnv))
+		}
+	}
+}
+
 // --- Documentation rendering ---
 
 // celKeywordHover returns hover markdown for a CEL keyword.
@@ -290,6 +365,50 @@ func celKeywordHover(name string) string {
 	}
 }
 
+// celVariableHover returns hover markdown for a variable declared in the
+// environment, or "" if no such variable is declared.
+// qualifiedIdentName returns the dotted name of a selection from an
+// identifier, such as "a.b.c", and the identifier.
+func qualifiedIdentName(expr ast.Expr) (string, ast.Expr, bool) {
+	var fields []string
+	for expr.Kind() == ast.SelectKind && !expr.AsSelect().IsTestOnly() {
+		fields = append(fields, expr.AsSelect().FieldName())
+		expr = expr.AsSelect().Operand()
+	}
+	if expr.Kind() != ast.IdentKind || len(fields) == 0 {
+		return "", nil, false
+	}
+	name := expr.AsIdent()
+	for _, field := range slices.Backward(fields) {
+		name += "." + field
+	}
+	return name, expr, true
+}
+
+func celVariableHover(name string, celEnv *cel.Env) string {
+	for _, v := range celEnv.Variables() {
+		if v.Name() != name {
+			continue
+		}
+		md := fmt.Sprintf("**Variable**: `%s`\n\n**Type**: `%s`", name, v.Type())
+		if desc := v.Description(); desc != "" {
+			md += "\n\n" + desc
+		}
+		return md
+	}
+	return ""
+}
+
+// celFieldHover returns hover markdown for a field of a message type,
+// including the field's comment from its .proto source, if known.
+func celFieldHover(typeName, fieldName string, fieldType *types.Type, celEnv *cel.Env) string {
+	md := fmt.Sprintf("**Field**: `%s.%s`\n\n**Type**: `%s`", typeName, fieldName, fieldType)
+	if comment := fieldComment(celEnv, typeName, fieldName); comment != "" {
+		md += "\n\n" + comment
+	}
+	return md
+}
+
 // celFunctionHover returns hover markdown for a CEL function (including operators and type conversions).
 // It looks up the function declaration in the CEL environment for upstream documentation.
 func celFunctionHover(funcName string, celEnv *cel.Env) string {
@@ -319,6 +438,9 @@ func celFunctionHover(funcName string, celEnv *cel.Env) string {
 // celMacroHover returns hover markdown for a CEL macro.
 // It looks up macro documentation from the CEL environment.
 func celMacroHover(macroName string, celEnv *cel.Env) string {
+	if m, ok := namespacedMacros[macroName]; ok {
+		return fmt.Sprintf("**Macro**: `%s.%s`\n\n%s", m.namespace, macroName, m.description)
+	}
 	for _, m := range celEnv.Macros() {
 		if m.Function() != macroName {
 			continue
@@ -1,18 +1,22 @@
 package lsp
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"strings"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
 	"github.com/stefanvanburen/cells/internal/jsonrpc2"
 	"github.com/stefanvanburen/cells/internal/lsp/protocol"
 )
 
-func (s *server) inlayHints(req *jsonrpc2.Request) (any, error) {
+func (s *server) inlayHints(ctx context.Context, req *jsonrpc2.Request) (any, error) {
 	var params protocol.InlayHintParams
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
 		return nil, err
@@ -20,13 +24,30 @@ func (s *server) inlayHints(req *jsonrpc2.Request) (any, error) {
 
 	s.mu.Lock()
 	f := s.files[params.TextDocument.URI]
+	opts := s.inlayHintOptions
 	s.mu.Unlock()
 
 	if f == nil || f.content == "" {
 		return []protocol.InlayHint{}, nil
 	}
 
-	hints, _ := computeInlayHints(f, s.celEnv)
+	var hints []protocol.InlayHint
+	if exprs, ok := s.embedded(f); ok {
+		for _, e := range exprs {
+			exprHints, _ := computeInlayHints(ctx, e.file, e.env, e.vars, opts)
+			for _, hint := range exprHints {
+				hint.Position = e.hostPosition(f.content, hint.Position)
+				hints = append(hints, hint)
+			}
+		}
+	} else {
+		env := s.envFor(f.uri)
+		var vars map[string]any
+		if path, err := f.uri.Path(); err == nil && path != "" {
+			vars = inputFileVars(path, env, s.readFile)
+		}
+		hints, _ = computeInlayHints(ctx, f, env, vars, opts)
+	}
 
 	// Filter hints to only those within the requested range
 	var filtered []protocol.InlayHint
@@ -39,11 +60,24 @@ func (s *server) inlayHints(req *jsonrpc2.Request) (any, error) {
 }
 
 // computeInlayHints returns inlay hints for CEL expressions.
-// Currently shows evaluation results for valid expressions.
-func computeInlayHints(f *file, celEnv *cel.Env) ([]protocol.InlayHint, error) {
+// Currently shows evaluation results for valid expressions, with vars
+// holding the values of any variables that are known. An @input comment in
+// the file overrides them. With opts.Subexpressions, there are also hints
+// for the values of the expression's subexpressions. Evaluation is bounded
+// by the budgets of opts and by ctx.
+func computeInlayHints(ctx context.Context, f *file, celEnv *cel.Env, vars map[string]any, opts inlayHintOptions) ([]protocol.InlayHint, error) {
 	if f.content == "" {
 		return []protocol.InlayHint{}, nil
 	}
+	if in, ok := commentInput(f.content); ok {
+		inputs, _ := in.bind(celEnv)
+		vars = maps.Clone(vars)
+		if vars == nil {
+			vars = inputs
+		} else {
+			maps.Copy(vars, inputs)
+		}
+	}
 
 	parsed, issues := celEnv.Parse(f.content)
 	if issues.Err() != nil {
@@ -56,21 +90,99 @@ func computeInlayHints(f *file, celEnv *cel.Env) ([]protocol.InlayHint, error) {
 		return []protocol.InlayHint{}, nil
 	}
 
-	// Try to evaluate the entire expression
-	result, err := tryEvaluateExpression(f.content, celEnv)
+	val, details, err := evaluate(ctx, checked, celEnv, vars, opts)
+	var hints []protocol.InlayHint
+	if opts.Subexpressions && details != nil {
+		hints = subexpressionHints(f.content, checked, details.State())
+	}
 	if err != nil {
-		return []protocol.InlayHint{}, nil
+		// Evaluation that's stopped by a budget explains why there's no
+		// value; other errors have no hint.
+		if hint, ok := budgetHint(f.content, err, opts); ok {
+			hints = append(hints, hint)
+		}
+		return hints, nil
+	}
+	return append(hints, resultHint(f.content, resultToString(val), checked)), nil
+}
+
+// evaluate evaluates the checked expression with vars, within the cost limit
+// and time budget of opts. The details hold the values of subexpressions
+// with opts.Subexpressions, even if evaluation fails.
+func evaluate(ctx context.Context, checked *cel.Ast, celEnv *cel.Env, vars map[string]any, opts inlayHintOptions) (ref.Val, *cel.EvalDetails, error) {
+	progOpts := []cel.ProgramOption{
+		cel.CostLimit(opts.costLimit()),
+		// Comprehensions check whether the deadline has passed as they
+		// iterate.
+		cel.InterruptCheckFrequency(interruptCheckFrequency),
+	}
+	if opts.Subexpressions {
+		progOpts = append(progOpts, cel.EvalOptions(cel.OptTrackState))
 	}
 
+	// Compile the checked expression, in which namespaced functions from
+	// extensions (e.g. math.abs) have been resolved.
+	prog, err := celEnv.Program(checked, progOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Evaluate with the variables that are known
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout())
+	defer cancel()
+	val, details, err := prog.ContextEval(ctx, vars)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = errEvalTimeout
+	}
+	return val, details, err
+}
+
+// errEvalTimeout is the error of evaluation that's interrupted because it
+// takes longer than its time budget.
+var errEvalTimeout = errors.New("evaluation timed out")
+
+// budgetHint returns a hint, at the end of content, explaining that err
+// stopped evaluation because it exceeded a budget, if it did.
+func budgetHint(content string, err error, opts inlayHintOptions) (protocol.InlayHint, bool) {
+	var (
+		label, tooltip string
+		cancelled      interpreter.EvalCancelledError
+	)
+	switch {
+	case errors.Is(err, errEvalTimeout):
+		label = fmt.Sprintf("→ no value: evaluation took longer than %s", opts.timeout())
+		tooltip = "Raise inlayHints.timeout in the initialization options to evaluate it."
+	case errors.As(err, &cancelled) && cancelled.Cause == interpreter.CostLimitExceeded:
+		label = fmt.Sprintf("→ no value: evaluation exceeded the cost limit of %d", opts.costLimit())
+		tooltip = "Raise inlayHints.costLimit in the initialization options to evaluate it."
+	default:
+		return protocol.InlayHint{}, false
+	}
+	line, col := byteOffsetToLineCol(content, len(strings.TrimRight(content, "\n\r")))
+	return protocol.InlayHint{
+		Position:    protocol.Position{Line: line, Character: col},
+		Label:       []protocol.InlayHintLabelPart{{Value: label}},
+		Kind:        protocol.InlayHintKind(1),
+		Tooltip:     &protocol.Or_InlayHint_tooltip{Value: tooltip},
+		PaddingLeft: true,
+	}, true
+}
+
+// resultHint returns the hint for the result of the checked expression in
+// content, at its end.
+func resultHint(content, result string, checked *cel.Ast) protocol.InlayHint {
 	// Get the type of the expression
 	exprType := checked.OutputType()
 	typeStr := exprType.String()
 
 	// Create a hint at the end of the content (before any trailing newline)
-	contentLen := len(strings.TrimRight(f.content, "\n\r"))
-	endLine, endCol := byteOffsetToLineCol(f.content, contentLen)
+	contentLen := len(strings.TrimRight(content, "\n\r"))
+	endLine, endCol := byteOffsetToLineCol(content, contentLen)
 
-	hint := protocol.InlayHint{
+	return protocol.InlayHint{
 		Position: protocol.Position{Line: endLine, Character: endCol},
 		Label: []protocol.InlayHintLabelPart{
 			{
@@ -80,43 +192,28 @@ func computeInlayHints(f *file, celEnv *cel.Env) ([]protocol.InlayHint, error) {
 		Kind:        protocol.InlayHintKind(1), // Type hint kind
 		PaddingLeft: true,
 	}
-
-	return []protocol.InlayHint{hint}, nil
 }
 
-// tryEvaluateExpression attempts to parse, check, and evaluate a CEL expression.
-// Returns a human-readable string representation of the result, or an error.
-func tryEvaluateExpression(exprText string, celEnv *cel.Env) (string, error) {
-	if strings.TrimSpace(exprText) == "" {
-		return "", fmt.Errorf("empty expression")
+// resolveInlayHint fills in the tooltip of a hint whose value is truncated
+// with the whole value.
+func (s *server) resolveInlayHint(req *jsonrpc2.Request) (any, error) {
+	var hint protocol.InlayHint
+	if err := json.Unmarshal(*req.Params, &hint); err != nil {
+		return nil, err
 	}
-
-	// Parse the expression
-	parsed, parseIssues := celEnv.Parse(exprText)
-	if parseIssues.Err() != nil {
-		return "", parseIssues.Err()
+	if hint.Data == nil {
+		return hint, nil
 	}
-
-	// Type-check the expression
-	_, checkIssues := celEnv.Check(parsed)
-	if checkIssues.Err() != nil {
-		return "", checkIssues.Err()
-	}
-
-	// Compile the expression
-	prog, compileErr := celEnv.Program(parsed)
-	if compileErr != nil {
-		return "", compileErr
+	raw, err := json.Marshal(hint.Data)
+	if err != nil {
+		return nil, err
 	}
-
-	// Evaluate with no variables
-	val, _, evalErr := prog.Eval(map[string]any{})
-	if evalErr != nil {
-		return "", evalErr
+	var data inlayHintData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
 	}
-
-	// Convert the result to a human-readable string
-	return resultToString(val), nil
+	hint.Tooltip = &protocol.Or_InlayHint_tooltip{Value: data.Value}
+	return hint, nil
 }
 
 // resultToString converts a CEL value to a human-readable string for inlay hints.
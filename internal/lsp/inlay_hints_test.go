@@ -9,10 +9,17 @@ import (
 
 // getInlayHints sends a textDocument/inlayHint request and returns the result.
 func getInlayHints(t *testing.T, celFile string) []protocol.InlayHint {
+	t.Helper()
+	return getInlayHintsWithOptions(t, celFile, nil)
+}
+
+// getInlayHintsWithOptions is like getInlayHints, but sends the given
+// initializationOptions in the initialize request.
+func getInlayHintsWithOptions(t *testing.T, celFile string, initOptions any) []protocol.InlayHint {
 	t.Helper()
 	ctx := t.Context()
 	testPath := getAbsPath(t, celFile)
-	clientConn, testURI := setupLSPServer(t, testPath)
+	clientConn, testURI := setupLSPServerWithOptions(t, testPath, initOptions)
 
 	var result []protocol.InlayHint
 	err := clientConn.Call(ctx, "textDocument/inlayHint", protocol.InlayHintParams{
@@ -94,3 +101,88 @@ func TestInlayHints(t *testing.T) {
 		})
 	}
 }
+
+// subexpressionOptions enables hints for subexpressions.
+var subexpressionOptions = map[string]any{"inlayHints": map[string]any{"subexpressions": true}}
+
+func TestSubexpressionInlayHints(t *testing.T) {
+	t.Parallel()
+
+	hints := getInlayHintsWithOptions(t, "testdata/inlay_hints/subexpressions.cel", subexpressionOptions)
+	type hint struct {
+		pos   protocol.Position
+		label string
+	}
+	var got []hint
+	for _, h := range hints {
+		got = append(got, hint{h.Position, h.Label[0].Value})
+	}
+	be.Equal(t, got, []hint{
+		{protocol.Position{Line: 0, Character: 13}, "→ 5"},
+		{protocol.Position{Line: 0, Character: 17}, "→ true"},
+		{protocol.Position{Line: 1, Character: 9}, "→ false"},
+		{protocol.Position{Line: 1, Character: 47}, "→ true"},
+		{protocol.Position{Line: 1, Character: 48}, "→ true"},
+		{protocol.Position{Line: 2, Character: 25}, "→ true (bool)"},
+	})
+
+	// Without the option, only the result has a hint.
+	hints = getInlayHints(t, "testdata/inlay_hints/subexpressions.cel")
+	be.Equal(t, len(hints), 1)
+	be.Equal(t, hints[0].Label[0].Value, "→ true (bool)")
+}
+
+func TestSubexpressionInlayHintResolve(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	conn, uri := setupLSPServerWithOptions(t, getAbsPath(t, "testdata/inlay_hints/truncated.cel"), subexpressionOptions)
+	var hints []protocol.InlayHint
+	err := conn.Call(ctx, "textDocument/inlayHint", protocol.InlayHintParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range:        protocol.Range{End: protocol.Position{Line: 1000}},
+	}, &hints)
+	be.Err(t, err, nil)
+	be.True(t, len(hints) > 0)
+
+	// The comprehension's value is too long to show in full.
+	truncated := hints[0]
+	be.Equal(t, truncated.Label[0].Value, `→ ["alphaalpha", "betabeta", "g…`)
+	be.True(t, truncated.Data != nil)
+
+	var resolved protocol.InlayHint
+	err = conn.Call(ctx, "inlayHint/resolve", truncated, &resolved)
+	be.Err(t, err, nil)
+	be.True(t, resolved.Tooltip != nil)
+	be.Equal(t, resolved.Tooltip.Value, any(`["alphaalpha", "betabeta", "gammagamma", "deltadelta"]`))
+}
+
+func TestInlayHintBudgets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		options map[string]any
+		label   string
+	}{
+		{
+			name:    "cost_limit",
+			options: map[string]any{"costLimit": 1000},
+			label:   "→ no value: evaluation exceeded the cost limit of 1000",
+		},
+		{
+			name:    "timeout",
+			options: map[string]any{"costLimit": uint64(1) << 40, "timeout": "1ms"},
+			label:   "→ no value: evaluation took longer than 1ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			hints := getInlayHintsWithOptions(t, "testdata/inlay_hints/expensive.cel", map[string]any{"inlayHints": tt.options})
+			be.Equal(t, len(hints), 1)
+			be.Equal(t, hints[0].Label[0].Value, tt.label)
+			be.True(t, hints[0].Tooltip != nil)
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package lsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	"go.yaml.in/yaml/v3"
+)
+
+// inputPrefix starts a comment giving the sample inputs inlay hints evaluate
+// an expression with, as a JSON or YAML flow mapping of variable names to
+// values, such as
+//
+//	// @input {"request": {"size": 3}}
+const inputPrefix = "// @input"
+
+// inputSuffixes end the names of the files of sample inputs for a .cel
+// file, so that foo.input.json holds the inputs for foo.cel. An @input
+// comment takes precedence over them.
+var inputSuffixes = []string{".input.json", ".input.yaml", ".input.yml"}
+
+// sampleInput is a mapping of variable names to sample values, in its host:
+// a file of inputs, or a CEL file with an @input comment.
+type sampleInput struct {
+	host string
+	// text is the mapping's source, which starts at offset base in host.
+	text string
+	base int
+}
+
+// commentInput returns the sample input given by an @input comment among
+// the comments that lead content, if any.
+func commentInput(content string) (*sampleInput, bool) {
+	offset := 0
+	for line := range strings.SplitAfterSeq(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") {
+			break
+		}
+		if rest, ok := strings.CutPrefix(trimmed, inputPrefix); ok && (rest == "" || rest[0] == ' ') {
+			start := offset + strings.Index(line, inputPrefix) + len(inputPrefix)
+			return &sampleInput{host: content, text: strings.TrimRight(content[start:offset+len(line)], "\r\n"), base: start}, true
+		}
+		offset += len(line)
+	}
+	return nil, false
+}
+
+// inputFile returns the content of the file of sample inputs for the .cel
+// file at path, if there is one.
+func inputFile(path string, read func(string) ([]byte, error)) ([]byte, bool) {
+	base := strings.TrimSuffix(path, ".cel")
+	for _, suffix := range inputSuffixes {
+		if data, err := read(base + suffix); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// celPathForInput returns the path of the .cel file that the file of sample
+// inputs at path is for, if it is one.
+func celPathForInput(path string) (string, bool) {
+	for _, suffix := range inputSuffixes {
+		if base, ok := strings.CutSuffix(path, suffix); ok {
+			return base + ".cel", true
+		}
+	}
+	return "", false
+}
+
+// inputFileVars returns the sample inputs in the file of inputs for the .cel
+// file at path, if there is one, converted to CEL values of the types the
+// variables have in celEnv. Inputs that can't be converted are left out.
+func inputFileVars(path string, celEnv *cel.Env, read func(string) ([]byte, error)) map[string]any {
+	data, ok := inputFile(path, read)
+	if !ok {
+		return nil
+	}
+	vars, _ := (&sampleInput{host: string(data), text: string(data)}).bind(celEnv)
+	return vars
+}
+
+// inputDiagnostics returns the diagnostics for the inputs that can't be
+// converted in the document at path, if it's a file of inputs for a .cel
+// file.
+func (s *server) inputDiagnostics(path, content string) []protocol.Diagnostic {
+	celPath, ok := celPathForInput(path)
+	if !ok {
+		return nil
+	}
+	celContent, err := s.readFile(celPath)
+	if err != nil {
+		return nil
+	}
+	_, diagnostics := (&sampleInput{host: content, text: content}).bind(s.documentEnv(celPath, string(celContent)))
+	return diagnostics
+}
+
+// bind converts the inputs to CEL values of the types the variables have in
+// celEnv, returning them along with diagnostics, in the host, for those that
+// can't be converted.
+func (in *sampleInput) bind(celEnv *cel.Env) (map[string]any, []protocol.Diagnostic) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(in.text), &doc); err != nil {
+		return nil, []protocol.Diagnostic{hostDiagnostic(in.host, in.base, in.base+len(strings.TrimRight(in.text, "\r\n")), fmt.Sprintf("invalid input: %v", err))}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []protocol.Diagnostic{in.diagnostic(root, "input must be a mapping of variable names to values")}
+	}
+
+	declared := make(map[string]*cel.Type)
+	for _, v := range celEnv.Variables() {
+		declared[v.Name()] = v.Type()
+	}
+	c := &inputConverter{provider: celEnv.CELTypeProvider(), adapter: celEnv.CELTypeAdapter()}
+	vars := make(map[string]any)
+	var diagnostics []protocol.Diagnostic
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		t, ok := declared[key.Value]
+		if !ok {
+			diagnostics = append(diagnostics, in.diagnostic(key, fmt.Sprintf("undeclared variable %s", key.Value)))
+			continue
+		}
+		val, err := c.convert(value, t)
+		if err != nil {
+			diagnostics = append(diagnostics, in.diagnostic(err.node, fmt.Sprintf("%s: %s", key.Value, err.msg)))
+			continue
+		}
+		vars[key.Value] = val
+	}
+	return vars, diagnostics
+}
+
+// diagnostic returns an error diagnostic for the node n of the input.
+func (in *sampleInput) diagnostic(n *yaml.Node, msg string) protocol.Diagnostic {
+	start := yamlOffset(in.text, n.Line, n.Column)
+	end := start + 1
+	if n.Kind == yaml.ScalarNode {
+		_, offsets := yamlScalar(in.text, n)
+		start, end = offsets[0], max(offsets[len(offsets)-1], start+1)
+	}
+	return hostDiagnostic(in.host, in.base+start, in.base+end, msg)
+}
+
+// inputError is a value of the input that can't be converted.
+type inputError struct {
+	node *yaml.Node
+	msg  string
+}
+
+// inputConverter converts input values to CEL values.
+type inputConverter struct {
+	provider types.Provider
+	adapter  types.Adapter
+}
+
+// convert returns the value of n as a CEL value of type t.
+func (c *inputConverter) convert(n *yaml.Node, t *cel.Type) (ref.Val, *inputError) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return types.NullValue, nil
+	}
+	mismatch := func() *inputError {
+		return &inputError{node: n, msg: fmt.Sprintf("expected %s", t)}
+	}
+
+	switch t.Kind() {
+	case types.BoolKind:
+		b, err := strconv.ParseBool(n.Value)
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" || err != nil {
+			return nil, mismatch()
+		}
+		return types.Bool(b), nil
+	case types.IntKind:
+		i, err := strconv.ParseInt(n.Value, 0, 64)
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || err != nil {
+			return nil, mismatch()
+		}
+		return types.Int(i), nil
+	case types.UintKind:
+		u, err := strconv.ParseUint(n.Value, 0, 64)
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || err != nil {
+			return nil, mismatch()
+		}
+		return types.Uint(u), nil
+	case types.DoubleKind:
+		d, err := strconv.ParseFloat(n.Value, 64)
+		if n.Kind != yaml.ScalarNode || (n.Tag != "!!int" && n.Tag != "!!float") || err != nil {
+			return nil, mismatch()
+		}
+		return types.Double(d), nil
+	case types.StringKind:
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+			return nil, mismatch()
+		}
+		return types.String(n.Value), nil
+	case types.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(n.Value)
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!str" || err != nil {
+			return nil, &inputError{node: n, msg: "expected bytes, in base64"}
+		}
+		return types.Bytes(b), nil
+	case types.DurationKind:
+		d, err := time.ParseDuration(n.Value)
+		if n.Kind != yaml.ScalarNode || err != nil {
+			return nil, &inputError{node: n, msg: "expected a duration, such as 1h30m"}
+		}
+		return types.Duration{Duration: d}, nil
+	case types.TimestampKind:
+		ts, err := time.Parse(time.RFC3339Nano, n.Value)
+		if n.Kind != yaml.ScalarNode || err != nil {
+			return nil, &inputError{node: n, msg: "expected an RFC 3339 timestamp"}
+		}
+		return types.Timestamp{Time: ts}, nil
+	case types.ListKind:
+		if n.Kind != yaml.SequenceNode {
+			return nil, mismatch()
+		}
+		elems := make([]ref.Val, 0, len(n.Content))
+		for _, item := range n.Content {
+			v, err := c.convert(item, t.Parameters()[0])
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		return types.NewRefValList(c.adapter, elems), nil
+	case types.MapKind:
+		if n.Kind != yaml.MappingNode {
+			return nil, mismatch()
+		}
+		entries := make(map[ref.Val]ref.Val)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, err := c.convert(n.Content[i], t.Parameters()[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := c.convert(n.Content[i+1], t.Parameters()[1])
+			if err != nil {
+				return nil, err
+			}
+			entries[k] = v
+		}
+		return types.NewRefValMap(c.adapter, entries), nil
+	case types.StructKind:
+		if n.Kind != yaml.MappingNode {
+			return nil, mismatch()
+		}
+		fields := make(map[string]ref.Val)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			ft, ok := c.provider.FindStructFieldType(t.TypeName(), key.Value)
+			if !ok {
+				return nil, &inputError{node: key, msg: fmt.Sprintf("%s has no field %s", t.TypeName(), key.Value)}
+			}
+			v, err := c.convert(n.Content[i+1], ft.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields[key.Value] = v
+		}
+		val := c.provider.NewValue(t.TypeName(), fields)
+		if types.IsError(val) {
+			return nil, &inputError{node: n, msg: fmt.Sprint(val)}
+		}
+		return val, nil
+	default:
+		return c.dyn(n), nil
+	}
+}
+
+// dyn returns the value of n as the CEL value of the kind it's written as.
+func (c *inputConverter) dyn(n *yaml.Node) ref.Val {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		elems := make([]ref.Val, 0, len(n.Content))
+		for _, item := range n.Content {
+			elems = append(elems, c.dyn(item))
+		}
+		return types.NewRefValList(c.adapter, elems)
+	case yaml.MappingNode:
+		entries := make(map[ref.Val]ref.Val)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			entries[types.String(n.Content[i].Value)] = c.dyn(n.Content[i+1])
+		}
+		return types.NewRefValMap(c.adapter, entries)
+	case yaml.AliasNode:
+		return c.dyn(n.Alias)
+	}
+	switch n.Tag {
+	case "!!null":
+		return types.NullValue
+	case "!!bool":
+		if b, err := strconv.ParseBool(n.Value); err == nil {
+			return types.Bool(b)
+		}
+	case "!!int":
+		if i, err := strconv.ParseInt(n.Value, 0, 64); err == nil {
+			return types.Int(i)
+		}
+	case "!!float":
+		if d, err := strconv.ParseFloat(n.Value, 64); err == nil {
+			return types.Double(d)
+		}
+	}
+	return types.String(n.Value)
+}
@@ -0,0 +1,52 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestInputInlayHints(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"testdata/input/request.cel", []string{"→ true (bool)"}},
+		{"testdata/input/comment.cel", []string{`→ "hello, world" (string)`}},
+		// Inputs that can't be converted aren't bound.
+		{"testdata/input/invalid.cel", nil},
+		{"testdata/input/comment_invalid.cel", nil},
+	}
+	for _, tt := range tests {
+		var labels []string
+		for _, hint := range getInlayHints(t, tt.file) {
+			labels = append(labels, hint.Label[0].Value)
+		}
+		be.Equal(t, labels, tt.want)
+	}
+}
+
+func TestInputDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/input/request.input.json"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/input/invalid.input.yaml"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{
+		"limit: expected int",
+		"tags: expected string",
+		"created: expected an RFC 3339 timestamp",
+		"undeclared variable unknown",
+	})
+	be.Equal(t, diags[0].Range, lineRange(0, 8, 12))
+	be.Equal(t, diags[1].Range, lineRange(1, 10, 11))
+
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/input/comment_invalid.cel"))
+	diags = pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{"count: expected int"})
+	be.Equal(t, diags[0].Range, lineRange(1, 21, 26))
+}
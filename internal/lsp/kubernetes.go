@@ -0,0 +1,589 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The Kubernetes profiles declare the variables the API server makes available
+// to CEL expressions.
+const (
+	// profileKubernetesAdmission is for ValidatingAdmissionPolicy and
+	// MutatingAdmissionPolicy expressions.
+	profileKubernetesAdmission = "kubernetes-admission"
+	// profileKubernetesCRD is for x-kubernetes-validations rules in
+	// CustomResourceDefinitions.
+	profileKubernetesCRD = "kubernetes-crd"
+)
+
+// kubernetesConfig configures the types of the Kubernetes profiles'
+// variables. Without schemas, objects are dynamically typed.
+type kubernetesConfig struct {
+	// Schema is the path, relative to the config file, of an OpenAPI v3
+	// schema in JSON or YAML, or of a CustomResourceDefinition manifest. It
+	// types object and oldObject, or self and oldSelf for CRD rules.
+	Schema string `yaml:"schema,omitempty"`
+	// ParamsSchema is like Schema, but types params.
+	ParamsSchema string `yaml:"params_schema,omitempty"`
+	// Self is the dotted path of the property within Schema at which CRD
+	// rules are evaluated, e.g. "spec.template". It defaults to the root.
+	Self string `yaml:"self,omitempty"`
+}
+
+//go:embed kubernetes.proto
+var kubernetesProto string
+
+// kubernetesTypes returns the descriptor of the types declared by
+// kubernetes.proto, linked against the well-known types registered in this
+// binary so that they are shared with CEL's.
+var kubernetesTypes = sync.OnceValues(func() (protoreflect.FileDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+				"kubernetes.proto": kubernetesProto,
+			}),
+		}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	files, err := compiler.Compile(context.Background(), "kubernetes.proto")
+	if err != nil {
+		return nil, err
+	}
+	return protodesc.NewFile(protodesc.ToFileDescriptorProto(files[0]), protoregistry.GlobalFiles)
+})
+
+// kubernetesOptions returns the options declaring the variables of the given
+// Kubernetes profile, along with the libraries the API server enables.
+func (c *config) kubernetesOptions(profile, dir string, read func(string) ([]byte, error)) ([]cel.EnvOption, []configError) {
+	k := c.Kubernetes
+	if k == nil {
+		k = &kubernetesConfig{}
+	}
+	base, err := kubernetesTypes()
+	if err != nil {
+		return nil, []configError{{path: filepath.Join(dir, configFileName), line: -1, msg: err.Error()}}
+	}
+
+	opts := kubernetesLibraryOptions(base)
+
+	var errs []configError
+	objectType := schemaType{}
+	if k.Schema != "" {
+		s, err := c.loadSchema(dir, k.Schema, read)
+		if err == nil && profile == profileKubernetesCRD && k.Self != "" {
+			s, err = s.at(k.Self)
+		}
+		if err == nil {
+			// Objects at the root of a resource always have these fields,
+			// though schemas don't usually declare them.
+			withMeta := profile == profileKubernetesAdmission || k.Self == ""
+			var fd protoreflect.FileDescriptor
+			fd, objectType, err = schemaTypes("Object", s, withMeta, base)
+			if err == nil {
+				opts = append(opts, cel.TypeDescs(fd))
+			}
+		}
+		if err != nil {
+			errs = append(errs, referenceError(dir, k.Schema, fmt.Sprintf("schema %q: %v", k.Schema, err), read))
+		}
+	}
+	paramsType := schemaType{}
+	if k.ParamsSchema != "" && profile == profileKubernetesAdmission {
+		s, err := c.loadSchema(dir, k.ParamsSchema, read)
+		if err == nil {
+			var fd protoreflect.FileDescriptor
+			fd, paramsType, err = schemaTypes("Params", s, true, base)
+			if err == nil {
+				opts = append(opts, cel.TypeDescs(fd))
+			}
+		}
+		if err != nil {
+			errs = append(errs, referenceError(dir, k.ParamsSchema, fmt.Sprintf("params schema %q: %v", k.ParamsSchema, err), read))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return append(opts, kubernetesVariables(profile, objectType, paramsType)...), nil
+}
+
+// kubernetesLibraryOptions returns the options the API server enables for
+// every expression, including the types of its variables, which are declared
+// by base.
+func kubernetesLibraryOptions(base protoreflect.FileDescriptor) []cel.EnvOption {
+	opts := []cel.EnvOption{
+		cel.TypeDescs(base),
+		cel.HomogeneousAggregateLiterals(),
+		cel.DefaultUTCTimeZone(true),
+		cel.CrossTypeNumericComparisons(true),
+		cel.OptionalTypes(),
+		ext.Strings(ext.StringsVersion(2)),
+		ext.Sets(),
+		ext.Lists(),
+		ext.TwoVarComprehensions(),
+	}
+	return append(opts, kubernetesLibraries()...)
+}
+
+// kubernetesVariables returns the options declaring the variables of the
+// given Kubernetes profile, with objects of objectType and params of
+// paramsType.
+func kubernetesVariables(profile string, objectType, paramsType schemaType) []cel.EnvOption {
+	switch profile {
+	case profileKubernetesAdmission:
+		return []cel.EnvOption{
+			objectType.variable("object", "The object from the incoming request. It is null for DELETE requests."),
+			objectType.variable("oldObject", "The existing object. It is null for CREATE requests."),
+			cel.VariableWithDoc("request", cel.ObjectType("kubernetes.AdmissionRequest"), "The attributes of the admission request."),
+			paramsType.variable("params", "The parameter resource referred to by the policy binding being evaluated. It is null if the policy has no paramKind."),
+			cel.VariableWithDoc("namespaceObject", cel.ObjectType("kubernetes.Namespace"), "The namespace the object belongs to. It is null for cluster-scoped resources."),
+			cel.VariableWithDoc("authorizer", authorizerType, "An authorizer for checking the permissions of the principal making the request."),
+			cel.VariableWithDoc("authorizer.requestResource", resourceCheckType, "A check for access to the resource of the request, as configured by the policy binding."),
+			cel.Lib(authorizerLibrary{}),
+			cel.VariableWithDoc("variables", cel.MapType(cel.StringType, cel.DynType), "The policy's composited variables, by name."),
+		}
+	case profileKubernetesCRD:
+		return []cel.EnvOption{
+			objectType.variable("self", "The value at the location of the rule in the schema."),
+			objectType.variable("oldSelf", "The existing value, in transition rules. Rules referencing oldSelf are only evaluated on updates."),
+		}
+	}
+	return nil
+}
+
+// loadSchema reads the OpenAPI schema at name, relative to dir.
+func (c *config) loadSchema(dir, name string, read func(string) ([]byte, error)) (*openAPISchema, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSchema(data)
+}
+
+// openAPISchema is the subset of an OpenAPI v3 schema that determines CEL
+// types, including Kubernetes' extensions.
+type openAPISchema struct {
+	Type                 string                    `yaml:"type"`
+	Format               string                    `yaml:"format"`
+	Description          string                    `yaml:"description"`
+	Properties           map[string]*openAPISchema `yaml:"properties"`
+	Items                *openAPISchema            `yaml:"items"`
+	AdditionalProperties *openAPISchema            `yaml:"additionalProperties"`
+	Ref                  string                    `yaml:"$ref"`
+	PreserveUnknown      bool                      `yaml:"x-kubernetes-preserve-unknown-fields"`
+	IntOrString          bool                      `yaml:"x-kubernetes-int-or-string"`
+}
+
+// UnmarshalYAML accepts the boolean form of additionalProperties, which is
+// treated as an unconstrained schema.
+func (s *openAPISchema) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return nil
+	}
+	type plain openAPISchema
+	return node.Decode((*plain)(s))
+}
+
+// parseSchema decodes an OpenAPI v3 schema, or takes the schema of the first
+// version of a CustomResourceDefinition manifest.
+func parseSchema(data []byte) (*openAPISchema, error) {
+	var crd struct {
+		Kind string `yaml:"kind"`
+		Spec struct {
+			Versions []struct {
+				Schema struct {
+					OpenAPIV3Schema *openAPISchema `yaml:"openAPIV3Schema"`
+				} `yaml:"schema"`
+			} `yaml:"versions"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		return nil, err
+	}
+	if crd.Kind == "CustomResourceDefinition" {
+		for _, v := range crd.Spec.Versions {
+			if v.Schema.OpenAPIV3Schema != nil {
+				return v.Schema.OpenAPIV3Schema, nil
+			}
+		}
+		return nil, errors.New("CustomResourceDefinition has no openAPIV3Schema")
+	}
+
+	var s openAPISchema
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// at returns the schema of the property at the dotted path.
+func (s *openAPISchema) at(path string) (*openAPISchema, error) {
+	for name := range strings.SplitSeq(path, ".") {
+		prop, ok := s.Properties[name]
+		if !ok {
+			return nil, fmt.Errorf("no property %q at %q", name, path)
+		}
+		s = prop
+	}
+	return s, nil
+}
+
+// isCollection reports whether s is a list or a map, which can't be the
+// element type of a repeated field or the value type of a map field.
+func (s *openAPISchema) isCollection() bool {
+	return s.Type == "array" || (s.Type == "object" && len(s.Properties) == 0)
+}
+
+// schemaType identifies the CEL type derived from a schema: either a message
+// or, for schemas that aren't objects, the type of a field of a message.
+type schemaType struct {
+	message string
+	field   string
+}
+
+// variable returns an option declaring a variable of type t. The type of a
+// field is only known once the message is registered in the environment. An
+// empty schemaType is dyn.
+func (t schemaType) variable(name, doc string) cel.EnvOption {
+	return func(e *cel.Env) (*cel.Env, error) {
+		switch {
+		case t.message == "":
+			return cel.VariableWithDoc(name, cel.DynType, doc)(e)
+		case t.field == "":
+			return cel.VariableWithDoc(name, cel.ObjectType(t.message), doc)(e)
+		}
+		ft, ok := e.CELTypeProvider().FindStructFieldType(t.message, t.field)
+		if !ok {
+			return nil, fmt.Errorf("variable %q: no field %s.%s", name, t.message, t.field)
+		}
+		return cel.VariableWithDoc(name, ft.Type, doc)(e)
+	}
+}
+
+// schemaPackage is the protobuf package of the types derived from schemas.
+const schemaPackage = "kubernetes.schema"
+
+// schemaTypes converts a schema into protobuf message types, as CEL's type
+// system has no other way to declare object types with named fields. The
+// descriptions in the schema become comments, for hover. If withMeta is set,
+// the root object gets apiVersion, kind, and metadata fields unless the
+// schema declares them.
+func schemaTypes(root string, s *openAPISchema, withMeta bool, base protoreflect.FileDescriptor) (protoreflect.FileDescriptor, schemaType, error) {
+	c := &schemaConverter{
+		file: &descriptorpb.FileDescriptorProto{
+			Name:    proto.String("kubernetes/schema/" + strings.ToLower(root) + ".proto"),
+			Package: proto.String(schemaPackage),
+			Syntax:  proto.String("proto2"),
+			Dependency: []string{
+				base.Path(),
+				"google/protobuf/duration.proto",
+				"google/protobuf/struct.proto",
+				"google/protobuf/timestamp.proto",
+			},
+			SourceCodeInfo: &descriptorpb.SourceCodeInfo{},
+		},
+	}
+
+	var t schemaType
+	rootName := schemaPackage + "." + root
+	if s.Type == "object" && len(s.Properties) > 0 {
+		msg := c.message(rootName, root, s, []int32{4, 0})
+		if withMeta {
+			for _, f := range []struct{ name, typeName string }{
+				{"apiVersion", ""},
+				{"kind", ""},
+				{"metadata", ".kubernetes.ObjectMeta"},
+			} {
+				if _, ok := s.Properties[f.name]; ok {
+					continue
+				}
+				field := c.addField(msg, nil, f.name, &openAPISchema{Type: "string"})
+				if f.typeName != "" {
+					field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+					field.TypeName = proto.String(f.typeName)
+				}
+			}
+		}
+		c.file.MessageType = []*descriptorpb.DescriptorProto{msg}
+		t = schemaType{message: rootName}
+	} else {
+		// Wrap other schemas in a message, and use the type of its field.
+		msg := &descriptorpb.DescriptorProto{Name: proto.String(root)}
+		c.file.MessageType = []*descriptorpb.DescriptorProto{msg}
+		c.addFieldOf(msg, rootName, []int32{4, 0}, "value", "value", s)
+		t = schemaType{message: rootName, field: "value"}
+	}
+
+	fd, err := protodesc.NewFile(c.file, chainResolver{baseFiles(base), protoregistry.GlobalFiles})
+	if err != nil {
+		return nil, schemaType{}, err
+	}
+	return fd, t, nil
+}
+
+// baseFiles returns a registry containing only base.
+func baseFiles(base protoreflect.FileDescriptor) *protoregistry.Files {
+	files := new(protoregistry.Files)
+	_ = files.RegisterFile(base)
+	return files
+}
+
+// chainResolver resolves descriptors from the first resolver that has them.
+type chainResolver []protodesc.Resolver
+
+func (r chainResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	for _, res := range r {
+		if fd, err := res.FindFileByPath(path); err == nil {
+			return fd, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (r chainResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, res := range r {
+		if d, err := res.FindDescriptorByName(name); err == nil {
+			return d, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// schemaConverter builds a file of message types from a schema.
+type schemaConverter struct {
+	file *descriptorpb.FileDescriptorProto
+}
+
+// message converts an object schema into a message. fullName is the
+// message's fully-qualified name, and path its location in the file, which
+// its comments are attached to.
+func (c *schemaConverter) message(fullName, name string, s *openAPISchema, path []int32) *descriptorpb.DescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	c.comment(path, s.Description)
+	for _, prop := range slices.Sorted(maps.Keys(s.Properties)) {
+		fieldName, ok := escapeKubernetesName(prop)
+		if !ok {
+			continue
+		}
+		c.addFieldOf(msg, fullName, path, fieldName, prop, s.Properties[prop])
+	}
+	return msg
+}
+
+// addField adds a field of scalar type to msg.
+func (c *schemaConverter) addField(msg *descriptorpb.DescriptorProto, path []int32, name string, s *openAPISchema) *descriptorpb.FieldDescriptorProto {
+	index := int32(len(msg.Field))
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(index + 1),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	msg.Field = append(msg.Field, field)
+	if path != nil {
+		c.comment(append(slices.Clone(path), 2, index), s.Description)
+	}
+	c.setScalarType(field, s)
+	return field
+}
+
+// addFieldOf adds a field of the type of s to msg, whose fully-qualified
+// name is msgName and location is path. prop is the field's property name,
+// from which the names of nested messages are derived.
+func (c *schemaConverter) addFieldOf(msg *descriptorpb.DescriptorProto, msgName string, path []int32, name, prop string, s *openAPISchema) {
+	field := c.addField(msg, path, name, s)
+	c.setType(msg, msgName, path, field, prop, s)
+}
+
+// setType sets the type of field to that of s, adding any messages it needs
+// as nested messages of msg.
+func (c *schemaConverter) setType(msg *descriptorpb.DescriptorProto, msgName string, path []int32, field *descriptorpb.FieldDescriptorProto, prop string, s *openAPISchema) {
+	c.setScalarType(field, s)
+	switch {
+	case s.Type == "array":
+		if s.Items == nil || s.Items.isCollection() {
+			c.setMessageType(field, ".google.protobuf.ListValue")
+			return
+		}
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		c.setType(msg, msgName, path, field, prop, s.Items)
+
+	case s.Type == "object" && len(s.Properties) > 0:
+		name := nestedName(msg, prop)
+		nestedPath := append(slices.Clone(path), 3, int32(len(msg.NestedType)))
+		msg.NestedType = append(msg.NestedType, c.message(msgName+"."+name, name, s, nestedPath))
+		c.setMessageType(field, "."+msgName+"."+name)
+
+	case s.Type == "object" && s.AdditionalProperties != nil && !s.AdditionalProperties.isCollection() && !s.PreserveUnknown:
+		name := mapEntryName(field.GetName())
+		entry := &descriptorpb.DescriptorProto{
+			Name:    proto.String(name),
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("key"),
+				JsonName: proto.String("key"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		}
+		msg.NestedType = append(msg.NestedType, entry)
+		value := c.addField(entry, nil, "value", s.AdditionalProperties)
+		// Messages for the values are nested in msg, not the entry.
+		c.setType(msg, msgName, path, value, prop, s.AdditionalProperties)
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		c.setMessageType(field, "."+msgName+"."+name)
+
+	case s.Type == "object" && !s.PreserveUnknown:
+		c.setMessageType(field, ".google.protobuf.Struct")
+	}
+}
+
+// setScalarType sets the type of field for schemas of scalar types, and to
+// dyn otherwise, which setType refines.
+func (c *schemaConverter) setScalarType(field *descriptorpb.FieldDescriptorProto, s *openAPISchema) {
+	t := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	switch {
+	case s.IntOrString || s.Ref != "":
+		c.setMessageType(field, ".google.protobuf.Value")
+		return
+	case s.Type == "string" && s.Format == "date-time":
+		c.setMessageType(field, ".google.protobuf.Timestamp")
+		return
+	case s.Type == "string" && s.Format == "duration":
+		c.setMessageType(field, ".google.protobuf.Duration")
+		return
+	case s.Type == "string" && s.Format == "byte":
+		t = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	case s.Type == "string":
+	case s.Type == "integer":
+		t = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	case s.Type == "number":
+		t = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	case s.Type == "boolean":
+		t = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	default:
+		c.setMessageType(field, ".google.protobuf.Value")
+		return
+	}
+	field.Type = t.Enum()
+	field.TypeName = nil
+}
+
+func (c *schemaConverter) setMessageType(field *descriptorpb.FieldDescriptorProto, typeName string) {
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	field.TypeName = proto.String(typeName)
+}
+
+// comment attaches a description to the element at path.
+func (c *schemaConverter) comment(path []int32, description string) {
+	if description == "" {
+		return
+	}
+	c.file.SourceCodeInfo.Location = append(c.file.SourceCodeInfo.Location, &descriptorpb.SourceCodeInfo_Location{
+		Path:            slices.Clone(path),
+		Span:            []int32{0, 0, 0},
+		LeadingComments: proto.String(" " + description),
+	})
+}
+
+// nestedName returns a name for a message nested in msg, derived from the
+// property name prop, that doesn't collide with its other nested messages.
+func nestedName(msg *descriptorpb.DescriptorProto, prop string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range prop {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	base := b.String()
+	if base == "" || !unicode.IsLetter(rune(base[0])) {
+		base = "T" + base
+	}
+	name := base
+	for i := 2; slices.ContainsFunc(msg.NestedType, func(d *descriptorpb.DescriptorProto) bool {
+		return d.GetName() == name
+	}); i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	return name
+}
+
+// mapEntryName returns the name protobuf requires for the entry message of
+// the map field with the given name.
+func mapEntryName(fieldName string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range fieldName {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String() + "Entry"
+}
+
+// kubernetesNameRe matches the property names that Kubernetes makes
+// accessible from CEL.
+var kubernetesNameRe = regexp.MustCompile(`^[a-zA-Z_.\-/][a-zA-Z0-9_.\-/]*$`)
+
+// celReservedWords are escaped when used as property names.
+var celReservedWords = []string{
+	"true", "false", "null", "in", "as", "break", "const", "continue", "else",
+	"for", "function", "if", "import", "let", "loop", "package", "namespace",
+	"return", "var", "void", "while",
+}
+
+// escapeKubernetesName escapes a property name as Kubernetes does, so that
+// it's a valid CEL identifier, reporting false if the property isn't
+// accessible from CEL at all.
+func escapeKubernetesName(name string) (string, bool) {
+	if slices.Contains(celReservedWords, name) {
+		return "__" + name + "__", true
+	}
+	if !kubernetesNameRe.MatchString(name) {
+		return "", false
+	}
+	name = strings.ReplaceAll(name, "__", "__underscores__")
+	name = strings.ReplaceAll(name, ".", "__dot__")
+	name = strings.ReplaceAll(name, "-", "__dash__")
+	name = strings.ReplaceAll(name, "/", "__slash__")
+	return name, true
+}
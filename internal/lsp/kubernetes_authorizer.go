@@ -0,0 +1,223 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// The types of the authorization library, which builds checks of whether the
+// principal making a request may perform other actions.
+var (
+	// authorizerType is the type of the authorizer variable.
+	authorizerType    = types.NewOpaqueType("kubernetes.authorization.Authorizer")
+	pathCheckType     = types.NewOpaqueType("kubernetes.authorization.PathCheck")
+	groupCheckType    = types.NewOpaqueType("kubernetes.authorization.GroupCheck")
+	resourceCheckType = types.NewOpaqueType("kubernetes.authorization.ResourceCheck")
+	decisionType      = types.NewOpaqueType("kubernetes.authorization.Decision")
+)
+
+// localDecisionReason is the reason given by every decision, as there's no
+// API server to consult when evaluating locally.
+const localDecisionReason = "no authorizer is available when evaluating locally"
+
+// authorizer checks the permissions of the principal making the request, or
+// of a service account.
+type authorizer struct {
+	opaque
+	// The namespace and name of the service account checks are for, if any.
+	namespace, name string
+}
+
+func (a authorizer) Equal(other ref.Val) ref.Val {
+	o, ok := other.(authorizer)
+	return types.Bool(ok && a == o)
+}
+
+func (a authorizer) Value() any {
+	return a
+}
+
+func (a authorizer) String() string {
+	if a.name != "" {
+		return fmt.Sprintf("authorizer.serviceAccount(%q, %q)", a.namespace, a.name)
+	}
+	return "authorizer"
+}
+
+// authorizationCheck is a path or resource check, built up by the library's
+// functions.
+type authorizationCheck struct {
+	opaque
+	authorizer  authorizer
+	path        string
+	group       string
+	resource    string
+	subresource string
+	namespace   string
+	name        string
+}
+
+func (c authorizationCheck) Equal(other ref.Val) ref.Val {
+	o, ok := other.(authorizationCheck)
+	return types.Bool(ok && c == o)
+}
+
+func (c authorizationCheck) Value() any {
+	return c
+}
+
+func (c authorizationCheck) String() string {
+	var b strings.Builder
+	b.WriteString(c.authorizer.String())
+	switch c.typ {
+	case pathCheckType:
+		fmt.Fprintf(&b, ".path(%q)", c.path)
+		return b.String()
+	case groupCheckType, resourceCheckType:
+		fmt.Fprintf(&b, ".group(%q)", c.group)
+	}
+	for _, part := range [][2]string{
+		{"resource", c.resource},
+		{"subresource", c.subresource},
+		{"namespace", c.namespace},
+		{"name", c.name},
+	} {
+		if part[1] != "" {
+			fmt.Fprintf(&b, ".%s(%q)", part[0], part[1])
+		}
+	}
+	return b.String()
+}
+
+// decision is the result of an authorization check.
+type decision struct {
+	opaque
+	check string
+	verb  string
+}
+
+func (d decision) Equal(other ref.Val) ref.Val {
+	o, ok := other.(decision)
+	return types.Bool(ok && d == o)
+}
+
+func (d decision) Value() any {
+	return d
+}
+
+func (d decision) String() string {
+	return fmt.Sprintf("%s.check(%q)", d.check, d.verb)
+}
+
+// authorizerLibrary declares the authorization library, and binds the
+// authorizer variable for evaluation. Checks are built as they would be by
+// the API server, but as there's no authorizer to consult, their decisions
+// have no opinion: they're neither allowed nor errored.
+type authorizerLibrary struct{}
+
+func (authorizerLibrary) CompileOptions() []cel.EnvOption {
+	// set returns a binding that copies the receiving check and sets one of
+	// its fields to the string operand.
+	set := func(typ *types.Type, field func(*authorizationCheck) *string) cel.OverloadOpt {
+		return cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+			c := lhs.(authorizationCheck)
+			c.typ = typ
+			*field(&c) = string(rhs.(types.String))
+			return c
+		})
+	}
+	check := cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+		return decision{opaque{decisionType}, lhs.(authorizationCheck).String(), string(rhs.(types.String))}
+	})
+
+	return []cel.EnvOption{
+		cel.Function("path",
+			cel.FunctionDocs("Returns a check for access to a non-resource request path, such as /healthz."),
+			cel.MemberOverload("authorizer_path", []*cel.Type{authorizerType, cel.StringType}, pathCheckType,
+				cel.OverloadExamples(`authorizer.path('/healthz').check('get').allowed()`),
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return authorizationCheck{opaque: opaque{pathCheckType}, authorizer: lhs.(authorizer), path: string(rhs.(types.String))}
+				}),
+			),
+		),
+		cel.Function("group",
+			cel.FunctionDocs("Returns a check for access to the resources of an API group. The empty string is the core group."),
+			cel.MemberOverload("authorizer_group", []*cel.Type{authorizerType, cel.StringType}, groupCheckType,
+				cel.OverloadExamples(`authorizer.group('apps').resource('deployments')`),
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return authorizationCheck{opaque: opaque{groupCheckType}, authorizer: lhs.(authorizer), group: string(rhs.(types.String))}
+				}),
+			),
+		),
+		cel.Function("serviceAccount",
+			cel.FunctionDocs("Returns an authorizer for checking the permissions of the service account with the given namespace and name."),
+			cel.MemberOverload("authorizer_serviceaccount", []*cel.Type{authorizerType, cel.StringType, cel.StringType}, authorizerType,
+				cel.OverloadExamples(`authorizer.serviceAccount('default', 'builder')`),
+				cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+					return authorizer{opaque{authorizerType}, string(args[1].(types.String)), string(args[2].(types.String))}
+				}),
+			),
+		),
+		cel.Function("resource",
+			cel.FunctionDocs("Returns a check for access to a resource of the API group."),
+			cel.MemberOverload("groupcheck_resource", []*cel.Type{groupCheckType, cel.StringType}, resourceCheckType,
+				set(resourceCheckType, func(c *authorizationCheck) *string { return &c.resource })),
+		),
+		cel.Function("subresource",
+			cel.FunctionDocs("Returns the check for access to a subresource of the resource, such as status."),
+			cel.MemberOverload("resourcecheck_subresource", []*cel.Type{resourceCheckType, cel.StringType}, resourceCheckType,
+				set(resourceCheckType, func(c *authorizationCheck) *string { return &c.subresource })),
+		),
+		cel.Function("namespace",
+			cel.FunctionDocs("Returns the check for access to the resource in the given namespace."),
+			cel.MemberOverload("resourcecheck_namespace", []*cel.Type{resourceCheckType, cel.StringType}, resourceCheckType,
+				set(resourceCheckType, func(c *authorizationCheck) *string { return &c.namespace })),
+		),
+		cel.Function("name",
+			cel.FunctionDocs("Returns the check for access to the resource with the given name."),
+			cel.MemberOverload("resourcecheck_name", []*cel.Type{resourceCheckType, cel.StringType}, resourceCheckType,
+				set(resourceCheckType, func(c *authorizationCheck) *string { return &c.name })),
+		),
+		cel.Function("check",
+			cel.FunctionDocs("Checks whether the principal may perform the verb, such as get or create, returning a decision."),
+			cel.MemberOverload("pathcheck_check", []*cel.Type{pathCheckType, cel.StringType}, decisionType,
+				cel.OverloadExamples(`authorizer.path('/metrics').check('get')`),
+				check),
+			cel.MemberOverload("resourcecheck_check", []*cel.Type{resourceCheckType, cel.StringType}, decisionType,
+				cel.OverloadExamples(`authorizer.group('').resource('pods').namespace('default').check('create')`),
+				check),
+		),
+		cel.Function("allowed",
+			cel.FunctionDocs("Returns true if the authorizer allowed the check."),
+			cel.MemberOverload("decision_allowed", []*cel.Type{decisionType}, cel.BoolType,
+				cel.UnaryBinding(func(ref.Val) ref.Val { return types.False })),
+		),
+		cel.Function("reason",
+			cel.FunctionDocs("Returns the authorizer's reason for the decision, which may be empty."),
+			cel.MemberOverload("decision_reason", []*cel.Type{decisionType}, cel.StringType,
+				cel.UnaryBinding(func(ref.Val) ref.Val { return types.String(localDecisionReason) })),
+		),
+		cel.Function("errored",
+			cel.FunctionDocs("Returns true if the authorization check failed with an error."),
+			cel.MemberOverload("decision_errored", []*cel.Type{decisionType}, cel.BoolType,
+				cel.UnaryBinding(func(ref.Val) ref.Val { return types.False })),
+		),
+		cel.Function("error",
+			cel.FunctionDocs("Returns the error of a failed authorization check, or an empty string."),
+			cel.MemberOverload("decision_error", []*cel.Type{decisionType}, cel.StringType,
+				cel.UnaryBinding(func(ref.Val) ref.Val { return types.String("") })),
+		),
+	}
+}
+
+func (authorizerLibrary) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Globals(map[string]any{
+			"authorizer": authorizer{opaque: opaque{authorizerType}},
+		}),
+	}
+}
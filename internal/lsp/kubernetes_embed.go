@@ -0,0 +1,130 @@
+package lsp
+
+import (
+	"path/filepath"
+
+	"github.com/google/cel-go/cel"
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// admissionExpressionPaths are the fields of admission policies' specs that
+// hold CEL expressions: a list field and the expression fields of each of
+// its elements.
+var admissionExpressionPaths = []struct {
+	list   string
+	fields []string
+}{
+	{"matchConditions", []string{"expression"}},
+	{"variables", []string{"expression"}},
+	{"validations", []string{"expression", "messageExpression"}},
+	{"auditAnnotations", []string{"valueExpression"}},
+}
+
+// kubernetesExpressions returns the CEL expressions in doc, a document in
+// the YAML file at path, if it's a Kubernetes manifest that embeds them:
+// those of ValidatingAdmissionPolicies and MutatingAdmissionPolicies, which
+// are analyzed with the kubernetes-admission profile, and the
+// x-kubernetes-validations rules of CustomResourceDefinitions, whose self is
+// typed by the schema they're on.
+func (s *server) kubernetesExpressions(path, content string, doc *yaml.Node, read func(string) ([]byte, error)) []*embeddedExpr {
+	kind := yamlField(doc, "kind")
+	if kind == nil {
+		return nil
+	}
+	switch kind.Value {
+	case "ValidatingAdmissionPolicy", "MutatingAdmissionPolicy":
+		env := s.admissionEnv(path, read)
+		var exprs []*embeddedExpr
+		spec := yamlField(doc, "spec")
+		for _, p := range admissionExpressionPaths {
+			for _, item := range yamlItems(yamlField(spec, p.list)) {
+				for _, field := range p.fields {
+					if n := yamlField(item, field); isYAMLString(n) {
+						exprs = append(exprs, yamlExpr(content, n, env))
+					}
+				}
+			}
+		}
+		return exprs
+	case "CustomResourceDefinition":
+		base, err := kubernetesTypes()
+		if err != nil {
+			return nil
+		}
+		var exprs []*embeddedExpr
+		for _, version := range yamlItems(yamlPath(doc, "spec", "versions")) {
+			schema := yamlPath(version, "schema", "openAPIV3Schema")
+			exprs = append(exprs, s.crdExpressions(content, schema, true, base)...)
+		}
+		return exprs
+	}
+	return nil
+}
+
+// yamlExpr returns the expression held by the string scalar n.
+func yamlExpr(content string, n *yaml.Node, env *cel.Env) *embeddedExpr {
+	expr, offsets := yamlScalar(content, n)
+	return &embeddedExpr{file: &file{content: expr}, offsets: offsets, env: env}
+}
+
+// admissionEnv returns the environment for the expressions of admission
+// policies in the file at path: the kubernetes-admission profile, with the
+// schemas of the config file that applies to it, if any.
+func (s *server) admissionEnv(path string, read func(string) ([]byte, error)) *cel.Env {
+	key := envKey{configPath: s.findConfig(filepath.Dir(path)), profile: profileKubernetesAdmission}
+	entry := s.hostEnv(key, read)
+	if len(entry.errs) > 0 {
+		entry = s.loadEnv(envKey{profile: profileKubernetesAdmission})
+	}
+	return entry.env
+}
+
+// crdExpressions returns the x-kubernetes-validations rules, and their
+// message expressions, of the schema n and of the schemas within it. root
+// is set for the schema of a whole resource.
+func (s *server) crdExpressions(content string, n *yaml.Node, root bool, base protoreflect.FileDescriptor) []*embeddedExpr {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var exprs []*embeddedExpr
+	if rules := yamlItems(yamlField(n, "x-kubernetes-validations")); len(rules) > 0 {
+		env := s.crdRuleEnv(n, root, base)
+		for _, rule := range rules {
+			for _, field := range []string{"rule", "messageExpression"} {
+				if v := yamlField(rule, field); isYAMLString(v) && env != nil {
+					exprs = append(exprs, yamlExpr(content, v, env))
+				}
+			}
+		}
+	}
+	if props := yamlField(n, "properties"); props != nil && props.Kind == yaml.MappingNode {
+		for i := 1; i < len(props.Content); i += 2 {
+			exprs = append(exprs, s.crdExpressions(content, props.Content[i], false, base)...)
+		}
+	}
+	for _, field := range []string{"items", "additionalProperties"} {
+		exprs = append(exprs, s.crdExpressions(content, yamlField(n, field), false, base)...)
+	}
+	return exprs
+}
+
+// crdRuleEnv returns the environment for the rules on the schema n, with
+// self and oldSelf typed by it, or nil if it can't be created.
+func (s *server) crdRuleEnv(n *yaml.Node, root bool, base protoreflect.FileDescriptor) *cel.Env {
+	opts := kubernetesLibraryOptions(base)
+	var schema openAPISchema
+	if err := n.Decode(&schema); err == nil {
+		if fd, t, err := schemaTypes("Object", &schema, root, base); err == nil {
+			typed := append(opts, cel.TypeDescs(fd))
+			if env, err := s.newEnv(append(typed, kubernetesVariables(profileKubernetesCRD, t, schemaType{})...)...); err == nil {
+				return env
+			}
+		}
+	}
+	env, err := s.newEnv(append(opts, kubernetesVariables(profileKubernetesCRD, schemaType{}, schemaType{})...)...)
+	if err != nil {
+		return nil
+	}
+	return env
+}
@@ -0,0 +1,1056 @@
+package lsp
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// This file declares the CEL libraries that the Kubernetes API server adds to
+// its environments, with implementations that follow its semantics closely
+// enough to evaluate expressions locally, for inlay hints.
+
+// The types of the Kubernetes libraries' values, named as they are by the API
+// server.
+var (
+	quantityType = types.NewOpaqueType("kubernetes.Quantity")
+	ipType       = types.NewOpaqueType("net.IP")
+	cidrType     = types.NewOpaqueType("net.CIDR")
+	urlType      = types.NewOpaqueType("kubernetes.URL")
+	semverType   = types.NewOpaqueType("kubernetes.Semver")
+)
+
+// kubernetesLibraries returns the options declaring the functions of the
+// Kubernetes libraries that are available to all Kubernetes expressions.
+func kubernetesLibraries() []cel.EnvOption {
+	var opts []cel.EnvOption
+	opts = append(opts, quantityLibrary()...)
+	opts = append(opts, ipLibrary()...)
+	opts = append(opts, cidrLibrary()...)
+	opts = append(opts, urlLibrary()...)
+	opts = append(opts, semverLibrary()...)
+	opts = append(opts, listsLibrary()...)
+	opts = append(opts, regexLibrary()...)
+	return opts
+}
+
+// opaque implements the parts of ref.Val shared by the values of the
+// libraries' opaque types.
+type opaque struct {
+	typ *types.Type
+}
+
+func (o opaque) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	return nil, fmt.Errorf("type conversion error from '%s' to '%v'", o.typ, typeDesc)
+}
+
+func (o opaque) ConvertToType(typeVal ref.Type) ref.Val {
+	if typeVal == types.TypeType {
+		return o.typ
+	}
+	return types.NewErr("type conversion error from '%s' to '%s'", o.typ, typeVal)
+}
+
+func (o opaque) Type() ref.Type {
+	return o.typ
+}
+
+// Shared descriptions of the comparison functions of quantities and semantic
+// versions.
+const (
+	isLessThanDoc    = "Returns true if the receiver is less than the operand."
+	isGreaterThanDoc = "Returns true if the receiver is greater than the operand."
+	compareToDoc     = "Compares the receiver to the operand, returning 0 if they're equal, 1 if the receiver is greater, and -1 if it's less."
+)
+
+// quantity is a Kubernetes resource quantity, such as "500m" or "1.5Gi".
+type quantity struct {
+	opaque
+	value *big.Rat
+	// str is the quantity as written, if it was parsed.
+	str string
+}
+
+func newQuantity(value *big.Rat, str string) quantity {
+	return quantity{opaque{quantityType}, value, str}
+}
+
+func (q quantity) Equal(other ref.Val) ref.Val {
+	o, ok := other.(quantity)
+	return types.Bool(ok && q.value.Cmp(o.value) == 0)
+}
+
+func (q quantity) Value() any {
+	return q.value
+}
+
+func (q quantity) String() string {
+	return fmt.Sprintf("quantity(%q)", q.format())
+}
+
+// format returns the quantity as written, or in a canonical form: an integer,
+// or a decimal in the largest of milli, micro, and nano units that's exact.
+func (q quantity) format() string {
+	if q.str != "" {
+		return q.str
+	}
+	v := new(big.Rat).Set(q.value)
+	for _, suffix := range []string{"", "m", "u", "n"} {
+		if v.IsInt() {
+			return v.Num().String() + suffix
+		}
+		v.Mul(v, big.NewRat(1000, 1))
+	}
+	return q.value.FloatString(9)
+}
+
+// quantitySuffixes maps the suffixes of quantities to their multipliers.
+var quantitySuffixes = map[string]*big.Rat{
+	"n":  big.NewRat(1, 1_000_000_000),
+	"u":  big.NewRat(1, 1_000_000),
+	"m":  big.NewRat(1, 1_000),
+	"":   big.NewRat(1, 1),
+	"k":  big.NewRat(1_000, 1),
+	"M":  big.NewRat(1_000_000, 1),
+	"G":  big.NewRat(1_000_000_000, 1),
+	"T":  big.NewRat(1_000_000_000_000, 1),
+	"P":  big.NewRat(1_000_000_000_000_000, 1),
+	"E":  big.NewRat(1_000_000_000_000_000_000, 1),
+	"Ki": big.NewRat(1<<10, 1),
+	"Mi": big.NewRat(1<<20, 1),
+	"Gi": big.NewRat(1<<30, 1),
+	"Ti": big.NewRat(1<<40, 1),
+	"Pi": big.NewRat(1<<50, 1),
+	"Ei": big.NewRat(1<<60, 1),
+}
+
+var quantityPattern = regexp.MustCompile(`^([+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+))(.*)$`)
+
+// Quantities with more digits, or larger exponents, than these are rejected,
+// so that parsing one can't exhaust the server's time or memory. Kubernetes
+// itself stores quantities with an int64 mantissa or, failing that, an
+// inf.Dec, neither of which are meant for numbers anywhere near this large.
+const (
+	maxQuantityDigits   = 1000
+	maxQuantityExponent = 1000
+)
+
+// parseQuantity parses a quantity in the format accepted by Kubernetes: a
+// signed decimal number followed by a binary or decimal SI suffix, or by a
+// decimal exponent like "e3".
+func parseQuantity(s string) (*big.Rat, error) {
+	m := quantityPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("unable to parse quantity %q", s)
+	}
+	if len(m[1]) > maxQuantityDigits {
+		return nil, fmt.Errorf("quantity %q has too many digits", s)
+	}
+	value, ok := new(big.Rat).SetString(strings.TrimSuffix(m[1], "."))
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", m[1])
+	}
+	suffix := m[2]
+	if multiplier, ok := quantitySuffixes[suffix]; ok {
+		return value.Mul(value, multiplier), nil
+	}
+	if suffix[0] == 'e' || suffix[0] == 'E' {
+		exp, err := strconv.ParseInt(suffix[1:], 10, 32)
+		if err == nil && max(exp, -exp) > maxQuantityExponent {
+			return nil, fmt.Errorf("quantity %q has too large an exponent", s)
+		}
+		if err == nil {
+			pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(max(exp, -exp)), nil)
+			if exp < 0 {
+				return value.Quo(value, new(big.Rat).SetInt(pow)), nil
+			}
+			return value.Mul(value, new(big.Rat).SetInt(pow)), nil
+		}
+	}
+	return nil, fmt.Errorf("unable to parse quantity's suffix %q", suffix)
+}
+
+func quantityLibrary() []cel.EnvOption {
+	binaryQuantity := func(op func(x, y *big.Rat) ref.Val) func(lhs, rhs ref.Val) ref.Val {
+		return func(lhs, rhs ref.Val) ref.Val {
+			var y *big.Rat
+			switch rhs := rhs.(type) {
+			case quantity:
+				y = rhs.value
+			case types.Int:
+				y = new(big.Rat).SetInt64(int64(rhs))
+			default:
+				return types.MaybeNoSuchOverloadErr(rhs)
+			}
+			return op(lhs.(quantity).value, y)
+		}
+	}
+	add := binaryQuantity(func(x, y *big.Rat) ref.Val {
+		return newQuantity(new(big.Rat).Add(x, y), "")
+	})
+	sub := binaryQuantity(func(x, y *big.Rat) ref.Val {
+		return newQuantity(new(big.Rat).Sub(x, y), "")
+	})
+	compare := binaryQuantity(func(x, y *big.Rat) ref.Val {
+		return types.Int(x.Cmp(y))
+	})
+
+	return []cel.EnvOption{
+		cel.Function("quantity",
+			cel.FunctionDocs("Parses a string as a Kubernetes resource quantity, such as a CPU or memory request."),
+			cel.Overload("string_to_quantity", []*cel.Type{cel.StringType}, quantityType,
+				cel.OverloadExamples(`quantity("500m")`, `quantity("1.5Gi")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					s := string(arg.(types.String))
+					value, err := parseQuantity(s)
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return newQuantity(value, s)
+				}),
+			),
+		),
+		cel.Function("isQuantity",
+			cel.FunctionDocs("Returns true if the string can be parsed as a quantity."),
+			cel.Overload("is_quantity_string", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					_, err := parseQuantity(string(arg.(types.String)))
+					return types.Bool(err == nil)
+				}),
+			),
+		),
+		cel.Function("sign",
+			cel.FunctionDocs("Returns 1 if the quantity is positive, -1 if it's negative, and 0 if it's zero."),
+			cel.MemberOverload("quantity_sign", []*cel.Type{quantityType}, cel.IntType,
+				cel.UnaryBinding(func(q ref.Val) ref.Val {
+					return types.Int(q.(quantity).value.Sign())
+				}),
+			),
+		),
+		cel.Function("isInteger",
+			cel.FunctionDocs("Returns true if the quantity is a whole number that asInteger can represent."),
+			cel.MemberOverload("quantity_is_integer", []*cel.Type{quantityType}, cel.BoolType,
+				cel.UnaryBinding(func(q ref.Val) ref.Val {
+					v := q.(quantity).value
+					return types.Bool(v.IsInt() && v.Num().IsInt64())
+				}),
+			),
+		),
+		cel.Function("asInteger",
+			cel.FunctionDocs("Returns the quantity as an int. It's an error if the quantity isn't an integer or is too large."),
+			cel.MemberOverload("quantity_as_integer", []*cel.Type{quantityType}, cel.IntType,
+				cel.UnaryBinding(func(q ref.Val) ref.Val {
+					v := q.(quantity).value
+					if !v.IsInt() || !v.Num().IsInt64() {
+						return types.NewErr("cannot convert quantity %s to an integer", q.(quantity).format())
+					}
+					return types.Int(v.Num().Int64())
+				}),
+			),
+		),
+		cel.Function("asApproximateFloat",
+			cel.FunctionDocs("Returns the quantity as a double, which may lose precision."),
+			cel.MemberOverload("quantity_as_float", []*cel.Type{quantityType}, cel.DoubleType,
+				cel.UnaryBinding(func(q ref.Val) ref.Val {
+					f, _ := q.(quantity).value.Float64()
+					return types.Double(f)
+				}),
+			),
+		),
+		cel.Function("add",
+			cel.FunctionDocs("Returns the sum of the quantity and the operand."),
+			cel.MemberOverload("quantity_add", []*cel.Type{quantityType, quantityType}, quantityType, cel.BinaryBinding(add)),
+			cel.MemberOverload("quantity_add_int", []*cel.Type{quantityType, cel.IntType}, quantityType, cel.BinaryBinding(add)),
+		),
+		cel.Function("sub",
+			cel.FunctionDocs("Returns the difference of the quantity and the operand."),
+			cel.MemberOverload("quantity_sub", []*cel.Type{quantityType, quantityType}, quantityType, cel.BinaryBinding(sub)),
+			cel.MemberOverload("quantity_sub_int", []*cel.Type{quantityType, cel.IntType}, quantityType, cel.BinaryBinding(sub)),
+		),
+		cel.Function("isLessThan",
+			cel.FunctionDocs(isLessThanDoc),
+			cel.MemberOverload("quantity_less_than", []*cel.Type{quantityType, quantityType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(compare(lhs, rhs) == types.IntNegOne)
+				}),
+			),
+		),
+		cel.Function("isGreaterThan",
+			cel.FunctionDocs(isGreaterThanDoc),
+			cel.MemberOverload("quantity_greater_than", []*cel.Type{quantityType, quantityType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(compare(lhs, rhs) == types.IntOne)
+				}),
+			),
+		),
+		cel.Function("compareTo",
+			cel.FunctionDocs(compareToDoc),
+			cel.MemberOverload("quantity_compare_to", []*cel.Type{quantityType, quantityType}, cel.IntType, cel.BinaryBinding(compare)),
+		),
+	}
+}
+
+// ip is an IP address, without a zone.
+type ip struct {
+	opaque
+	addr netip.Addr
+}
+
+func newIP(addr netip.Addr) ip {
+	return ip{opaque{ipType}, addr}
+}
+
+func (i ip) Equal(other ref.Val) ref.Val {
+	o, ok := other.(ip)
+	return types.Bool(ok && i.addr == o.addr)
+}
+
+func (i ip) Value() any {
+	return i.addr
+}
+
+func (i ip) String() string {
+	return fmt.Sprintf("ip(%q)", i.addr)
+}
+
+// parseIP parses an IP address as Kubernetes does, rejecting zones and
+// IPv4-mapped IPv6 addresses.
+func parseIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("IP address %q: %w", s, err)
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("IP address %q with zone value is not allowed", s)
+	}
+	if addr.Is4In6() {
+		return netip.Addr{}, fmt.Errorf("IPv4-mapped IPv6 address %q is not allowed", s)
+	}
+	return addr, nil
+}
+
+func ipLibrary() []cel.EnvOption {
+	addrPredicate := func(pred func(netip.Addr) bool) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			return types.Bool(pred(arg.(ip).addr))
+		})
+	}
+	return []cel.EnvOption{
+		cel.Function("ip",
+			cel.FunctionDocs("Parses a string as an IPv4 or IPv6 address, or returns the address of a CIDR."),
+			cel.Overload("string_to_ip", []*cel.Type{cel.StringType}, ipType,
+				cel.OverloadExamples(`ip("192.168.0.1")`, `ip("::1")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					addr, err := parseIP(string(arg.(types.String)))
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return newIP(addr)
+				}),
+			),
+			cel.MemberOverload("cidr_ip", []*cel.Type{cidrType}, ipType,
+				cel.OverloadExamples(`cidr("192.168.0.0/24").ip() == ip("192.168.0.0")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return newIP(arg.(cidr).prefix.Addr())
+				}),
+			),
+		),
+		cel.Function("isIP",
+			cel.FunctionDocs("Returns true if the string is a valid IP address."),
+			cel.Overload("is_ip", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					_, err := parseIP(string(arg.(types.String)))
+					return types.Bool(err == nil)
+				}),
+			),
+		),
+		cel.Function("ip.isCanonical",
+			cel.FunctionDocs("Returns true if the string is an IP address in its canonical form. It's an error if it isn't an IP address."),
+			cel.Overload("ip_is_canonical", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`ip.isCanonical("2001:db8::abcd")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					s := string(arg.(types.String))
+					addr, err := parseIP(s)
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return types.Bool(addr.String() == s)
+				}),
+			),
+		),
+		cel.Function("family",
+			cel.FunctionDocs("Returns the IP address family: 4 for IPv4 and 6 for IPv6."),
+			cel.MemberOverload("ip_family", []*cel.Type{ipType}, cel.IntType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					if arg.(ip).addr.Is4() {
+						return types.Int(4)
+					}
+					return types.Int(6)
+				}),
+			),
+		),
+		cel.Function("isUnspecified",
+			cel.FunctionDocs("Returns true if the IP address is the unspecified address, 0.0.0.0 or ::."),
+			cel.MemberOverload("ip_is_unspecified", []*cel.Type{ipType}, cel.BoolType,
+				addrPredicate(netip.Addr.IsUnspecified)),
+		),
+		cel.Function("isLoopback",
+			cel.FunctionDocs("Returns true if the IP address is a loopback address."),
+			cel.MemberOverload("ip_is_loopback", []*cel.Type{ipType}, cel.BoolType,
+				addrPredicate(netip.Addr.IsLoopback)),
+		),
+		cel.Function("isLinkLocalMulticast",
+			cel.FunctionDocs("Returns true if the IP address is a link-local multicast address."),
+			cel.MemberOverload("ip_is_link_local_multicast", []*cel.Type{ipType}, cel.BoolType,
+				addrPredicate(netip.Addr.IsLinkLocalMulticast)),
+		),
+		cel.Function("isLinkLocalUnicast",
+			cel.FunctionDocs("Returns true if the IP address is a link-local unicast address."),
+			cel.MemberOverload("ip_is_link_local_unicast", []*cel.Type{ipType}, cel.BoolType,
+				addrPredicate(netip.Addr.IsLinkLocalUnicast)),
+		),
+		cel.Function("isGlobalUnicast",
+			cel.FunctionDocs("Returns true if the IP address is a global unicast address."),
+			cel.MemberOverload("ip_is_global_unicast", []*cel.Type{ipType}, cel.BoolType,
+				addrPredicate(netip.Addr.IsGlobalUnicast)),
+		),
+		cel.Function("string",
+			cel.Overload("ip_to_string", []*cel.Type{ipType}, cel.StringType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.String(arg.(ip).addr.String())
+				}),
+			),
+		),
+	}
+}
+
+// cidr is an IP address prefix, such as 10.0.0.0/8.
+type cidr struct {
+	opaque
+	prefix netip.Prefix
+}
+
+func newCIDR(prefix netip.Prefix) cidr {
+	return cidr{opaque{cidrType}, prefix}
+}
+
+func (c cidr) Equal(other ref.Val) ref.Val {
+	o, ok := other.(cidr)
+	return types.Bool(ok && c.prefix == o.prefix)
+}
+
+func (c cidr) Value() any {
+	return c.prefix
+}
+
+func (c cidr) String() string {
+	return fmt.Sprintf("cidr(%q)", c.prefix)
+}
+
+// parseCIDR parses a CIDR as Kubernetes does, rejecting zones and
+// IPv4-mapped IPv6 addresses.
+func parseCIDR(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("network address %q: %w", s, err)
+	}
+	if prefix.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("IPv4-mapped IPv6 address %q is not allowed", s)
+	}
+	return prefix, nil
+}
+
+func cidrLibrary() []cel.EnvOption {
+	containsIP := func(lhs, rhs ref.Val) ref.Val {
+		var addr netip.Addr
+		switch rhs := rhs.(type) {
+		case ip:
+			addr = rhs.addr
+		case types.String:
+			var err error
+			if addr, err = parseIP(string(rhs)); err != nil {
+				return types.WrapErr(err)
+			}
+		default:
+			return types.MaybeNoSuchOverloadErr(rhs)
+		}
+		return types.Bool(lhs.(cidr).prefix.Contains(addr))
+	}
+	containsCIDR := func(lhs, rhs ref.Val) ref.Val {
+		var other netip.Prefix
+		switch rhs := rhs.(type) {
+		case cidr:
+			other = rhs.prefix
+		case types.String:
+			var err error
+			if other, err = parseCIDR(string(rhs)); err != nil {
+				return types.WrapErr(err)
+			}
+		default:
+			return types.MaybeNoSuchOverloadErr(rhs)
+		}
+		prefix := lhs.(cidr).prefix
+		return types.Bool(prefix.Bits() <= other.Bits() && prefix.Contains(other.Addr()))
+	}
+
+	return []cel.EnvOption{
+		cel.Function("cidr",
+			cel.FunctionDocs("Parses a string as a CIDR, an IPv4 or IPv6 address prefix."),
+			cel.Overload("string_to_cidr", []*cel.Type{cel.StringType}, cidrType,
+				cel.OverloadExamples(`cidr("192.168.0.0/16")`, `cidr("::1/128")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					prefix, err := parseCIDR(string(arg.(types.String)))
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return newCIDR(prefix)
+				}),
+			),
+		),
+		cel.Function("isCIDR",
+			cel.FunctionDocs("Returns true if the string is a valid CIDR."),
+			cel.Overload("is_cidr", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					_, err := parseCIDR(string(arg.(types.String)))
+					return types.Bool(err == nil)
+				}),
+			),
+		),
+		cel.Function("containsIP",
+			cel.FunctionDocs("Returns true if the CIDR contains the IP address."),
+			cel.MemberOverload("cidr_contains_ip_string", []*cel.Type{cidrType, cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`cidr("192.168.0.0/16").containsIP("192.168.0.1")`),
+				cel.BinaryBinding(containsIP)),
+			cel.MemberOverload("cidr_contains_ip_ip", []*cel.Type{cidrType, ipType}, cel.BoolType,
+				cel.BinaryBinding(containsIP)),
+		),
+		cel.Function("containsCIDR",
+			cel.FunctionDocs("Returns true if the CIDR contains all the addresses of the other CIDR."),
+			cel.MemberOverload("cidr_contains_cidr_string", []*cel.Type{cidrType, cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`cidr("10.0.0.0/8").containsCIDR("10.1.0.0/16")`),
+				cel.BinaryBinding(containsCIDR)),
+			cel.MemberOverload("cidr_contains_cidr", []*cel.Type{cidrType, cidrType}, cel.BoolType,
+				cel.BinaryBinding(containsCIDR)),
+		),
+		cel.Function("masked",
+			cel.FunctionDocs("Returns the CIDR with the bits of its address beyond the prefix length set to zero."),
+			cel.MemberOverload("cidr_masked", []*cel.Type{cidrType}, cidrType,
+				cel.OverloadExamples(`cidr("192.168.1.5/24").masked() == cidr("192.168.1.0/24")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return newCIDR(arg.(cidr).prefix.Masked())
+				}),
+			),
+		),
+		cel.Function("prefixLength",
+			cel.FunctionDocs("Returns the length, in bits, of the CIDR's prefix."),
+			cel.MemberOverload("cidr_prefix_length", []*cel.Type{cidrType}, cel.IntType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.Int(arg.(cidr).prefix.Bits())
+				}),
+			),
+		),
+		cel.Function("string",
+			cel.Overload("cidr_to_string", []*cel.Type{cidrType}, cel.StringType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.String(arg.(cidr).prefix.String())
+				}),
+			),
+		),
+	}
+}
+
+// urlVal is a URL: either an absolute URL or an absolute path.
+type urlVal struct {
+	opaque
+	url *url.URL
+}
+
+func (u urlVal) Equal(other ref.Val) ref.Val {
+	o, ok := other.(urlVal)
+	return types.Bool(ok && u.url.String() == o.url.String())
+}
+
+func (u urlVal) Value() any {
+	return u.url
+}
+
+func (u urlVal) String() string {
+	return fmt.Sprintf("url(%q)", u.url)
+}
+
+func urlLibrary() []cel.EnvOption {
+	urlString := func(get func(*url.URL) string) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			return types.String(get(arg.(urlVal).url))
+		})
+	}
+	return []cel.EnvOption{
+		cel.Function("url",
+			cel.FunctionDocs("Parses a string as a URL, which must be an absolute URL or an absolute path."),
+			cel.Overload("string_to_url", []*cel.Type{cel.StringType}, urlType,
+				cel.OverloadExamples(`url("https://example.com/path?q=1")`, `url("/absolute/path")`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					u, err := url.ParseRequestURI(string(arg.(types.String)))
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return urlVal{opaque{urlType}, u}
+				}),
+			),
+		),
+		cel.Function("isURL",
+			cel.FunctionDocs("Returns true if the string is a valid URL."),
+			cel.Overload("is_url_string", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					_, err := url.ParseRequestURI(string(arg.(types.String)))
+					return types.Bool(err == nil)
+				}),
+			),
+		),
+		cel.Function("getScheme",
+			cel.FunctionDocs("Returns the URL's scheme, which is empty for absolute paths."),
+			cel.MemberOverload("url_get_scheme", []*cel.Type{urlType}, cel.StringType,
+				urlString(func(u *url.URL) string { return u.Scheme })),
+		),
+		cel.Function("getHost",
+			cel.FunctionDocs("Returns the URL's host, including its port. IPv6 addresses are in square brackets."),
+			cel.MemberOverload("url_get_host", []*cel.Type{urlType}, cel.StringType,
+				urlString(func(u *url.URL) string { return u.Host })),
+		),
+		cel.Function("getHostname",
+			cel.FunctionDocs("Returns the URL's host without its port. IPv6 addresses are without square brackets."),
+			cel.MemberOverload("url_get_hostname", []*cel.Type{urlType}, cel.StringType,
+				urlString((*url.URL).Hostname)),
+		),
+		cel.Function("getPort",
+			cel.FunctionDocs("Returns the URL's port, or an empty string if it has none."),
+			cel.MemberOverload("url_get_port", []*cel.Type{urlType}, cel.StringType,
+				urlString((*url.URL).Port)),
+		),
+		cel.Function("getEscapedPath",
+			cel.FunctionDocs("Returns the URL's path, escaped."),
+			cel.MemberOverload("url_get_escaped_path", []*cel.Type{urlType}, cel.StringType,
+				urlString((*url.URL).EscapedPath)),
+		),
+		cel.Function("getQuery",
+			cel.FunctionDocs("Returns the URL's query parameters, as a map from each name to its values."),
+			cel.MemberOverload("url_get_query", []*cel.Type{urlType}, cel.MapType(cel.StringType, cel.ListType(cel.StringType)),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.DefaultTypeAdapter.NativeToValue(map[string][]string(arg.(urlVal).url.Query()))
+				}),
+			),
+		),
+	}
+}
+
+// semver is a semantic version, as specified by https://semver.org.
+type semver struct {
+	opaque
+	major, minor, patch uint64
+	pre                 []string
+	build               string
+}
+
+func (v semver) Equal(other ref.Val) ref.Val {
+	o, ok := other.(semver)
+	return types.Bool(ok && v.compare(o) == 0)
+}
+
+func (v semver) Value() any {
+	return v.format()
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("semver(%q)", v.format())
+}
+
+func (v semver) format() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if len(v.pre) > 0 {
+		s += "-" + strings.Join(v.pre, ".")
+	}
+	if v.build != "" {
+		s += "+" + v.build
+	}
+	return s
+}
+
+// compare compares versions by their precedence, which ignores build
+// metadata.
+func (v semver) compare(o semver) int {
+	for _, c := range [][2]uint64{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	// A version without a pre-release has higher precedence.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		if c := comparePrerelease(v.pre[i], o.pre[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.pre) < len(o.pre):
+		return -1
+	case len(v.pre) > len(o.pre):
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease compares pre-release identifiers: numerically if both
+// are numeric, with numeric ones lower than others, and otherwise lexically.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an < bn {
+			return -1
+		} else if an > bn {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+var (
+	semverIdentifier = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+	semverNumber     = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+)
+
+// parseSemver parses a semantic version. If normalize is set, it also accepts
+// a "v" prefix, a missing minor or patch version, and leading zeros.
+func parseSemver(s string, normalize bool) (semver, error) {
+	v := semver{opaque: opaque{semverType}}
+	rest := s
+	if normalize {
+		rest = strings.TrimPrefix(rest, "v")
+	}
+	rest, build, hasBuild := strings.Cut(rest, "+")
+	core, pre, hasPre := strings.Cut(rest, "-")
+
+	parts := strings.Split(core, ".")
+	if normalize {
+		for len(parts) < 3 {
+			parts = append(parts, "0")
+		}
+		for i, p := range parts {
+			if p != "" {
+				parts[i] = cmp.Or(strings.TrimLeft(p, "0"), "0")
+			}
+		}
+	}
+	if len(parts) != 3 {
+		return semver{}, fmt.Errorf("invalid semver %q: expected major.minor.patch", s)
+	}
+	var nums [3]uint64
+	for i, p := range parts {
+		if !semverNumber.MatchString(p) {
+			return semver{}, fmt.Errorf("invalid semver %q: invalid version number %q", s, p)
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return semver{}, fmt.Errorf("invalid semver %q: %w", s, err)
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			// Numeric identifiers can't have leading zeros.
+			numeric := !strings.ContainsFunc(id, func(r rune) bool { return r < '0' || r > '9' })
+			if !semverIdentifier.MatchString(id) || (numeric && !semverNumber.MatchString(id)) {
+				return semver{}, fmt.Errorf("invalid semver %q: invalid pre-release identifier %q", s, id)
+			}
+		}
+	}
+	if hasBuild {
+		for id := range strings.SplitSeq(build, ".") {
+			if !semverIdentifier.MatchString(id) {
+				return semver{}, fmt.Errorf("invalid semver %q: invalid build identifier %q", s, id)
+			}
+		}
+		v.build = build
+	}
+	return v, nil
+}
+
+func semverLibrary() []cel.EnvOption {
+	parse := func(args ...ref.Val) ref.Val {
+		normalize := len(args) > 1 && args[1] == types.True
+		v, err := parseSemver(string(args[0].(types.String)), normalize)
+		if err != nil {
+			return types.WrapErr(err)
+		}
+		return v
+	}
+	isSemver := func(args ...ref.Val) ref.Val {
+		return types.Bool(!types.IsError(parse(args...)))
+	}
+	compare := func(lhs, rhs ref.Val) ref.Val {
+		o, ok := rhs.(semver)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(rhs)
+		}
+		return types.Int(lhs.(semver).compare(o))
+	}
+	component := func(get func(semver) uint64) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			return types.Int(get(arg.(semver)))
+		})
+	}
+
+	return []cel.EnvOption{
+		cel.Function("semver",
+			cel.FunctionDocs("Parses a string as a semantic version. If normalize is true, a \"v\" prefix, missing minor and patch versions, and leading zeros are accepted."),
+			cel.Overload("string_to_semver", []*cel.Type{cel.StringType}, semverType,
+				cel.OverloadExamples(`semver("1.2.3")`),
+				cel.FunctionBinding(parse)),
+			cel.Overload("string_bool_to_semver", []*cel.Type{cel.StringType, cel.BoolType}, semverType,
+				cel.OverloadExamples(`semver("v1.2", true) == semver("1.2.0")`),
+				cel.FunctionBinding(parse)),
+		),
+		cel.Function("isSemver",
+			cel.FunctionDocs("Returns true if the string is a valid semantic version, optionally normalizing it as semver does."),
+			cel.Overload("is_semver_string", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.FunctionBinding(isSemver)),
+			cel.Overload("is_semver_string_bool", []*cel.Type{cel.StringType, cel.BoolType}, cel.BoolType,
+				cel.FunctionBinding(isSemver)),
+		),
+		cel.Function("major",
+			cel.FunctionDocs("Returns the major version."),
+			cel.MemberOverload("semver_major", []*cel.Type{semverType}, cel.IntType,
+				component(func(v semver) uint64 { return v.major })),
+		),
+		cel.Function("minor",
+			cel.FunctionDocs("Returns the minor version."),
+			cel.MemberOverload("semver_minor", []*cel.Type{semverType}, cel.IntType,
+				component(func(v semver) uint64 { return v.minor })),
+		),
+		cel.Function("patch",
+			cel.FunctionDocs("Returns the patch version."),
+			cel.MemberOverload("semver_patch", []*cel.Type{semverType}, cel.IntType,
+				component(func(v semver) uint64 { return v.patch })),
+		),
+		cel.Function("isLessThan",
+			cel.FunctionDocs(isLessThanDoc),
+			cel.MemberOverload("semver_less_than", []*cel.Type{semverType, semverType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(compare(lhs, rhs) == types.IntNegOne)
+				}),
+			),
+		),
+		cel.Function("isGreaterThan",
+			cel.FunctionDocs(isGreaterThanDoc),
+			cel.MemberOverload("semver_greater_than", []*cel.Type{semverType, semverType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(compare(lhs, rhs) == types.IntOne)
+				}),
+			),
+		),
+		cel.Function("compareTo",
+			cel.FunctionDocs(compareToDoc),
+			cel.MemberOverload("semver_compare_to", []*cel.Type{semverType, semverType}, cel.IntType, cel.BinaryBinding(compare)),
+		),
+	}
+}
+
+// listsLibrary declares Kubernetes' list functions, besides those in cel-go's
+// lists extension.
+func listsLibrary() []cel.EnvOption {
+	t := cel.TypeParamType("T")
+	listT := cel.ListType(t)
+
+	// extremum returns the element for which keep(compare(element, current))
+	// holds against every other.
+	extremum := func(name string, keep func(types.Int) bool) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			var result ref.Val
+			for it := arg.(traits.Lister).Iterator(); it.HasNext() == types.True; {
+				elem := it.Next()
+				if result == nil {
+					result = elem
+					continue
+				}
+				cmp, ok := elem.(traits.Comparer)
+				if !ok {
+					return types.MaybeNoSuchOverloadErr(elem)
+				}
+				c := cmp.Compare(result)
+				if types.IsError(c) {
+					return c
+				}
+				if keep(c.(types.Int)) {
+					result = elem
+				}
+			}
+			if result == nil {
+				return types.NewErr("%s called on empty list", name)
+			}
+			return result
+		})
+	}
+	sum := func(zero ref.Val) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			total := zero
+			for it := arg.(traits.Lister).Iterator(); it.HasNext() == types.True; {
+				total = total.(traits.Adder).Add(it.Next())
+				if types.IsError(total) {
+					return total
+				}
+			}
+			return total
+		})
+	}
+	indexOf := func(last bool) cel.OverloadOpt {
+		return cel.BinaryBinding(func(list, elem ref.Val) ref.Val {
+			l := list.(traits.Lister)
+			size := int64(l.Size().(types.Int))
+			for i := range size {
+				if last {
+					i = size - 1 - i
+				}
+				if l.Get(types.Int(i)).Equal(elem) == types.True {
+					return types.Int(i)
+				}
+			}
+			return types.IntNegOne
+		})
+	}
+
+	return []cel.EnvOption{
+		cel.Function("isSorted",
+			cel.FunctionDocs("Returns true if the elements of the list are in ascending order."),
+			cel.MemberOverload("list_is_sorted", []*cel.Type{listT}, cel.BoolType,
+				cel.OverloadExamples(`[1, 2, 3].isSorted() == true`, `["b", "a"].isSorted() == false`),
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					var prev ref.Val
+					for it := arg.(traits.Lister).Iterator(); it.HasNext() == types.True; {
+						elem := it.Next()
+						if prev != nil {
+							cmp, ok := prev.(traits.Comparer)
+							if !ok {
+								return types.MaybeNoSuchOverloadErr(prev)
+							}
+							c := cmp.Compare(elem)
+							if types.IsError(c) {
+								return c
+							}
+							if c == types.IntOne {
+								return types.False
+							}
+						}
+						prev = elem
+					}
+					return types.True
+				}),
+			),
+		),
+		cel.Function("sum",
+			cel.FunctionDocs("Returns the sum of the elements of the list, or zero if it's empty."),
+			cel.MemberOverload("list_int_sum", []*cel.Type{cel.ListType(cel.IntType)}, cel.IntType,
+				cel.OverloadExamples(`[1, 2, 3].sum() == 6`),
+				sum(types.IntZero)),
+			cel.MemberOverload("list_uint_sum", []*cel.Type{cel.ListType(cel.UintType)}, cel.UintType,
+				sum(types.Uint(0))),
+			cel.MemberOverload("list_double_sum", []*cel.Type{cel.ListType(cel.DoubleType)}, cel.DoubleType,
+				sum(types.Double(0))),
+			cel.MemberOverload("list_duration_sum", []*cel.Type{cel.ListType(cel.DurationType)}, cel.DurationType,
+				sum(types.Duration{})),
+		),
+		cel.Function("min",
+			cel.FunctionDocs("Returns the least element of the list. It's an error if the list is empty."),
+			cel.MemberOverload("list_min", []*cel.Type{listT}, t,
+				cel.OverloadExamples(`[3, 1, 2].min() == 1`),
+				extremum("min", func(c types.Int) bool { return c < 0 })),
+		),
+		cel.Function("max",
+			cel.FunctionDocs("Returns the greatest element of the list. It's an error if the list is empty."),
+			cel.MemberOverload("list_max", []*cel.Type{listT}, t,
+				cel.OverloadExamples(`[3, 1, 2].max() == 3`),
+				extremum("max", func(c types.Int) bool { return c > 0 })),
+		),
+		cel.Function("indexOf",
+			cel.FunctionDocs("Returns the index of the first element of the list equal to the operand, or -1 if there's none."),
+			cel.MemberOverload("list_index_of", []*cel.Type{listT, t}, cel.IntType,
+				cel.OverloadExamples(`[1, 2, 2].indexOf(2) == 1`),
+				indexOf(false)),
+		),
+		cel.Function("lastIndexOf",
+			cel.FunctionDocs("Returns the index of the last element of the list equal to the operand, or -1 if there's none."),
+			cel.MemberOverload("list_last_index_of", []*cel.Type{listT, t}, cel.IntType,
+				cel.OverloadExamples(`[1, 2, 2].lastIndexOf(2) == 2`),
+				indexOf(true)),
+		),
+	}
+}
+
+// regexLibrary declares Kubernetes' functions for finding matches of regular
+// expressions, which use RE2 syntax like matches.
+func regexLibrary() []cel.EnvOption {
+	findAll := func(args ...ref.Val) ref.Val {
+		re, err := regexp.Compile(string(args[1].(types.String)))
+		if err != nil {
+			return types.WrapErr(err)
+		}
+		limit := -1
+		if len(args) > 2 {
+			limit = int(max(min(int64(args[2].(types.Int)), math.MaxInt32), -1))
+		}
+		return types.NewStringList(types.DefaultTypeAdapter, re.FindAllString(string(args[0].(types.String)), limit))
+	}
+	return []cel.EnvOption{
+		cel.Function("find",
+			cel.FunctionDocs("Returns the first match of the regular expression in the string, or an empty string if there's none."),
+			cel.MemberOverload("string_find_string", []*cel.Type{cel.StringType, cel.StringType}, cel.StringType,
+				cel.OverloadExamples(`"abc 123".find('[0-9]+') == "123"`),
+				cel.BinaryBinding(func(str, pattern ref.Val) ref.Val {
+					re, err := regexp.Compile(string(pattern.(types.String)))
+					if err != nil {
+						return types.WrapErr(err)
+					}
+					return types.String(re.FindString(string(str.(types.String))))
+				}),
+			),
+		),
+		cel.Function("findAll",
+			cel.FunctionDocs("Returns the matches of the regular expression in the string, up to an optional limit."),
+			cel.MemberOverload("string_find_all_string", []*cel.Type{cel.StringType, cel.StringType}, cel.ListType(cel.StringType),
+				cel.OverloadExamples(`"123 abc 456".findAll('[0-9]+') == ["123", "456"]`),
+				cel.FunctionBinding(findAll)),
+			cel.MemberOverload("string_find_all_string_int", []*cel.Type{cel.StringType, cel.StringType, cel.IntType}, cel.ListType(cel.StringType),
+				cel.OverloadExamples(`"123 abc 456".findAll('[0-9]+', 1) == ["123"]`),
+				cel.FunctionBinding(findAll)),
+		),
+	}
+}
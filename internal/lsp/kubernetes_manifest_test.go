@@ -0,0 +1,103 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestKubernetesManifestDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		file  string
+		diags []protocol.Range
+	}{
+		{"admission policy", "testdata/kubernetes_manifest/policy.yaml", nil},
+		{
+			"crd", "testdata/kubernetes_manifest/crd.yaml",
+			[]protocol.Range{{Start: protocol.Position{Line: 35, Character: 34}, End: protocol.Position{Line: 35, Character: 45}}},
+		},
+		{
+			// The positions account for the folded line and for the
+			// escaped quotes before the error.
+			"invalid", "testdata/kubernetes_manifest/invalid.yaml",
+			[]protocol.Range{
+				{Start: protocol.Position{Line: 8, Character: 15}, End: protocol.Position{Line: 8, Character: 26}},
+				{Start: protocol.Position{Line: 9, Character: 35}, End: protocol.Position{Line: 9, Character: 40}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, len(diags), len(tt.diags))
+			for i, d := range diags {
+				be.Equal(t, d.Message, "undefined field 'nmae'")
+				be.Equal(t, d.Range, tt.diags[i])
+			}
+		})
+	}
+}
+
+func TestKubernetesManifestHover(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		file     string
+		line     uint32
+		char     uint32
+		contains string
+	}{
+		{"quoted", "testdata/kubernetes_manifest/policy.yaml", 13, 21, "kubernetes.AdmissionRequest"},
+		{"literal block", "testdata/kubernetes_manifest/policy.yaml", 22, 20, "**Field**: `kubernetes.AdmissionRequest.operation`"},
+		{"single quoted", "testdata/kubernetes_manifest/policy.yaml", 26, 33, "The policy's composited variables"},
+		{"crd self field", "testdata/kubernetes_manifest/crd.yaml", 21, 31, "The lower limit for the number of replicas."},
+		{"crd items self field", "testdata/kubernetes_manifest/crd.yaml", 34, 36, "The port to expose."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			requireHoverContains(t, tt.file, tt.line, tt.char, tt.contains, tt.name)
+		})
+	}
+}
+
+func TestKubernetesManifestSemanticTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens := getSemanticTokens(t, "testdata/kubernetes_manifest/policy.yaml")
+	assertTokens(t, tokens, []expectedToken{
+		{22, 18, 9, stProperty, "operation in a literal block"},
+		{22, 31, 8, stString, "string in a literal block"},
+		{26, 53, 13, stString, "string with escaped quotes"},
+	})
+	// Nothing outside the expressions is highlighted.
+	be.True(t, !findTokenOnLine(tokens, 15, stString))
+}
+
+func TestKubernetesManifestRename(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/kubernetes_manifest/policy.yaml"))
+	edit := requestRename(t, conn, uri, protocol.Position{Line: 18, Character: 38}, "obj")
+	be.True(t, edit != nil)
+	var starts []protocol.Position
+	for _, e := range edit.Changes[uri] {
+		be.Equal(t, e.NewText, "obj")
+		starts = append(starts, e.Range.Start)
+	}
+	be.Equal(t, starts, []protocol.Position{{Line: 17, Character: 12}, {Line: 18, Character: 12}, {Line: 18, Character: 36}})
+
+	result := requestPrepareRename(t, conn, uri, protocol.Position{Line: 17, Character: 12})
+	be.True(t, result != nil)
+
+	// Outside of an expression, there's nothing to rename.
+	be.True(t, requestRename(t, conn, uri, protocol.Position{Line: 15, Character: 14}, "obj") == nil)
+}
@@ -0,0 +1,125 @@
+package lsp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestKubernetesDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"admission policy", "testdata/kubernetes/policy.cel"},
+		{"crd rule", "testdata/kubernetes_crd/rule.cel"},
+		{"crd transition rule", "testdata/kubernetes_crd/transition.cel"},
+		{"profile directive", "testdata/kubernetes_directive/crd_rule.cel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, diagMessages(diags), []string{})
+		})
+	}
+}
+
+func TestKubernetesUndefinedField(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/kubernetes/bad_field.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "undefined field 'replcas'"))
+}
+
+func TestKubernetesUnknownProfile(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/kubernetes_directive/unknown_profile.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, len(diags) > 0)
+	be.Equal(t, diags[0].Range.Start.Line, uint32(0))
+	be.True(t, strings.Contains(diags[0].Message, `unknown profile "kubernetes"`))
+}
+
+func TestKubernetesHover(t *testing.T) {
+	t.Parallel()
+
+	file := "testdata/kubernetes/policy.cel"
+	requireHoverContains(t, file, 0, 0, "The object from the incoming request.", "object variable")
+	requireHoverContains(t, file, 0, 14, "Number of desired pods.", "field description from the schema")
+	requireHoverContains(t, file, 0, 32, "The maximum number of replicas allowed.", "params field")
+	requireHoverContains(t, file, 1, 2, "kubernetes.AdmissionRequest", "request variable")
+	requireHoverContains(t, "testdata/kubernetes_crd/rule.cel", 0, 7, "The lower limit for the number of replicas.", "self field")
+}
+
+func TestKubernetesCompletion(t *testing.T) {
+	t.Parallel()
+
+	result := requestInvokedAtEnd(t, "testdata/kubernetes/spec_dot.cel")
+	item := findCompletionItem(result.Items, "replicas")
+	be.True(t, item != nil)
+	be.Equal(t, item.Detail, "int")
+	be.True(t, containsLabel(result.Items, "template"))
+	be.True(t, containsLabel(result.Items, "min__dash__ready__dash__seconds"))
+}
+
+func TestKubernetesLibraryInlayHints(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file           string
+		resultContains string
+	}{
+		{"quantity.cel", "true"},
+		{"quantity_add.cel", `quantity("1500m")`},
+		{"quantity_exponent.cel", "true"},
+		{"ip.cel", "true"},
+		{"url.cel", `"x": ["1", "2"]`},
+		{"semver.cel", "true"},
+		{"lists.cel", "true"},
+		{"find.cel", `["123", "456"]`},
+		{"authorizer.cel", "no authorizer is available when evaluating locally"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			t.Parallel()
+
+			hints := getInlayHints(t, "testdata/kubernetes_library/"+tt.file)
+			be.Equal(t, len(hints), 1)
+			be.True(t, strings.Contains(hints[0].Label[0].Value, tt.resultContains))
+		})
+	}
+}
+
+func TestKubernetesLibraryDocs(t *testing.T) {
+	t.Parallel()
+
+	requireHoverContains(t, "testdata/kubernetes_library/quantity.cel", 0, 2, "Kubernetes resource quantity", "quantity function")
+	requireHoverContains(t, "testdata/kubernetes_library/quantity.cel", 0, 20, "kubernetes.Quantity.isGreaterThan(kubernetes.Quantity) -> bool", "member overload")
+	requireHoverContains(t, "testdata/kubernetes_library/ip.cel", 0, 80, "canonical form", "namespaced function")
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/kubernetes_library/semver_call.cel"))
+	help := requestSignatureHelp(t, conn, uri, protocol.Position{Line: 0, Character: 15})
+	be.True(t, help != nil)
+	be.Equal(t, help.ActiveParameter, uint32(1))
+	be.True(t, containsSignature(help, "semver(string, bool) -> kubernetes.Semver"))
+}
+
+func containsSignature(help *protocol.SignatureHelp, label string) bool {
+	for _, sig := range help.Signatures {
+		if sig.Label == label {
+			return true
+		}
+	}
+	return false
+}
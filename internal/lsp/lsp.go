@@ -1,7 +1,7 @@
 // Package lsp implements a language server for CEL (Common Expression Language).
 //
-// The main entry-point is the Serve() function, which creates a new LSP server
-// communicating over stdin/stdout.
+// The main entry-point is the ServeStream() function, which runs an LSP server
+// over the given stream, such as Stdio().
 package lsp
 
 import (
@@ -10,8 +10,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/stefanvanburen/cells/internal/jsonrpc2"
@@ -28,10 +30,9 @@ func getVersion() string {
 	return info.Main.Version
 }
 
-// Serve starts the LSP server, communicating over stdin/stdout.
-// It blocks until the connection is closed.
-func Serve() error {
-	return ServeStream(context.Background(), stdinout{})
+// Stdio returns a stream reading from stdin and writing to stdout.
+func Stdio() io.ReadWriteCloser {
+	return stdinout{}
 }
 
 // stdinout wraps stdin/stdout into a ReadWriteCloser.
@@ -41,35 +42,81 @@ func (stdinout) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
 func (stdinout) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
 func (stdinout) Close() error                { return os.Stdout.Close() }
 
+// Option configures the server.
+type Option func(*server)
+
+// WithEnvOptions adds options to every CEL environment the server creates,
+// after its defaults and before any options from config files.
+func WithEnvOptions(opts ...cel.EnvOption) Option {
+	return func(s *server) {
+		s.envOptions = append(s.envOptions, opts...)
+	}
+}
+
 // ServeStream starts the LSP server over the given stream.
-// Exposed for testing.
-func ServeStream(ctx context.Context, rwc io.ReadWriteCloser) error {
-	s, err := newServer()
+// It blocks until the connection is closed, or ctx is cancelled, in which
+// case it closes the connection and returns ctx.Err().
+func ServeStream(ctx context.Context, rwc io.ReadWriteCloser, opts ...Option) error {
+	s, err := newServer(opts...)
 	if err != nil {
 		return err
 	}
 
 	conn := jsonrpc2.NewConn(ctx, rwc, jsonrpc2.HandlerFunc(s.handle))
-	<-conn.DisconnectNotify()
-	return nil
+	select {
+	case <-conn.DisconnectNotify():
+		return nil
+	case <-ctx.Done():
+		_ = conn.Close()
+		return ctx.Err()
+	}
 }
 
 // server holds all of the LSP server's mutable state.
 type server struct {
-	mu     sync.Mutex
-	files  map[protocol.DocumentURI]*file
+	mu    sync.Mutex
+	files map[protocol.DocumentURI]*file
+	// envOptions are applied to every environment the server creates.
+	envOptions []cel.EnvOption
+	// celEnv is the default environment, used for documents without a
+	// config file.
 	celEnv *cel.Env
+	// envs caches the environment built from each config file and
+	// profile.
+	envs map[envKey]*envEntry
+	// embeds caches the expressions extracted from each open host
+	// document, such as a .proto file.
+	embeds map[protocol.DocumentURI]*embedEntry
+	// notebooks are the open notebook documents, whose cells are in files.
+	notebooks map[protocol.URI]*notebook
+	// roots are the workspace folder paths; config discovery doesn't look
+	// above them.
+	roots []string
+	// configs caches the config file that applies to each directory, or ""
+	// if there is none.
+	configs map[string]string
+	// inlayHintOptions are the client's settings for inlay hints.
+	inlayHintOptions inlayHintOptions
 }
 
-func newServer() (*server, error) {
-	celEnv, err := cel.NewEnv(cel.EnableMacroCallTracking())
+func newServer(opts ...Option) (*server, error) {
+	s := &server{
+		files:      make(map[protocol.DocumentURI]*file),
+		envOptions: []cel.EnvOption{cel.EnableMacroCallTracking()},
+		envs:       make(map[envKey]*envEntry),
+		embeds:     make(map[protocol.DocumentURI]*embedEntry),
+		notebooks:  make(map[protocol.URI]*notebook),
+		configs:    make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	celEnv, err := s.newEnv()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
 	}
-	return &server{
-		files:  make(map[protocol.DocumentURI]*file),
-		celEnv: celEnv,
-	}, nil
+	s.celEnv = celEnv
+	return s, nil
 }
 
 func (s *server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
@@ -88,6 +135,16 @@ func (s *server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.
 		return nil, s.didChange(ctx, conn, req)
 	case "textDocument/didClose":
 		return nil, s.didClose(req)
+	case "notebookDocument/didOpen":
+		return nil, s.didOpenNotebook(conn, req)
+	case "notebookDocument/didChange":
+		return nil, s.didChangeNotebook(conn, req)
+	case "notebookDocument/didClose":
+		return nil, s.didCloseNotebook(req)
+	case "workspace/didChangeWatchedFiles":
+		// Any file may be a config file that was created or deleted.
+		s.forgetConfigs()
+		return nil, nil
 	case "textDocument/hover":
 		return s.hover(req)
 	case "textDocument/completion":
@@ -100,6 +157,10 @@ func (s *server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.
 		return s.formatting(req)
 	case "textDocument/signatureHelp":
 		return s.signatureHelp(req)
+	case "textDocument/documentSymbol":
+		return s.documentSymbol(req)
+	case "textDocument/definition":
+		return s.definition(req)
 	case "textDocument/rename":
 		return s.rename(req)
 	case "textDocument/prepareRename":
@@ -109,7 +170,9 @@ func (s *server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.
 	case "textDocument/documentHighlight":
 		return s.documentHighlight(req)
 	case "textDocument/inlayHint":
-		return s.inlayHints(req)
+		return s.inlayHints(ctx, req)
+	case "inlayHint/resolve":
+		return s.resolveInlayHint(req)
 	default:
 		return nil, &jsonrpc2.Error{
 			Code:    jsonrpc2.CodeMethodNotFound,
@@ -119,12 +182,54 @@ func (s *server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.
 }
 
 func (s *server) initialize(req *jsonrpc2.Request) (any, error) {
+	var params protocol.InitializeParams
+	if req.Params != nil {
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+
+	var roots []string
+	for _, folder := range params.WorkspaceFolders {
+		if path, err := protocol.DocumentURI(folder.URI).Path(); err == nil && path != "" {
+			roots = append(roots, path)
+		}
+	}
+	if len(roots) == 0 {
+		if path, err := params.RootURI.Path(); err == nil && path != "" {
+			roots = append(roots, path)
+		}
+	}
+	s.mu.Lock()
+	s.roots = roots
+	s.mu.Unlock()
+
+	if params.InitializationOptions != nil {
+		if err := s.applyInitializationOptions(params.InitializationOptions); err != nil {
+			return nil, &jsonrpc2.Error{
+				Code:    jsonrpc2.CodeInvalidParams,
+				Message: fmt.Sprintf("invalid initializationOptions: %v", err),
+			}
+		}
+	}
+
 	return protocol.InitializeResult{
 		Capabilities: protocol.ServerCapabilities{
 			TextDocumentSync: protocol.TextDocumentSyncOptions{
 				OpenClose: true,
 				Change:    protocol.Full,
 			},
+			// Code cells of any kind of notebook are CEL expressions.
+			NotebookDocumentSync: &protocol.Or_ServerCapabilities_notebookDocumentSync{
+				Value: protocol.NotebookDocumentSyncOptions{
+					NotebookSelector: []protocol.Or_NotebookDocumentSyncOptions_notebookSelector_Elem{{
+						Value: protocol.NotebookDocumentFilterWithCells{
+							Notebook: &protocol.Or_NotebookDocumentFilterWithCells_notebook{Value: "*"},
+							Cells:    []protocol.NotebookCellLanguage{{Language: "cel"}},
+						},
+					}},
+				},
+			},
 			HoverProvider:              &protocol.Or_ServerCapabilities_hoverProvider{Value: true},
 			DocumentFormattingProvider: &protocol.Or_ServerCapabilities_documentFormattingProvider{Value: true},
 			CompletionProvider: &protocol.CompletionOptions{
@@ -140,10 +245,12 @@ func (s *server) initialize(req *jsonrpc2.Request) (any, error) {
 			SignatureHelpProvider: &protocol.SignatureHelpOptions{
 				TriggerCharacters: []string{"(", ","},
 			},
+			DefinitionProvider:        &protocol.Or_ServerCapabilities_definitionProvider{Value: true},
+			DocumentSymbolProvider:    &protocol.Or_ServerCapabilities_documentSymbolProvider{Value: true},
 			RenameProvider:            &protocol.Or_ServerCapabilities_renameProvider{Value: true},
 			ReferencesProvider:        &protocol.Or_ServerCapabilities_referencesProvider{Value: true},
 			DocumentHighlightProvider: &protocol.Or_ServerCapabilities_documentHighlightProvider{Value: true},
-			InlayHintProvider:         &protocol.Or_ServerCapabilities_inlayHintProvider{Value: true},
+			InlayHintProvider:         &protocol.Or_ServerCapabilities_inlayHintProvider{Value: protocol.InlayHintOptions{ResolveProvider: true}},
 		},
 		ServerInfo: &protocol.ServerInfo{
 			Name:    serverName,
@@ -152,6 +259,106 @@ func (s *server) initialize(req *jsonrpc2.Request) (any, error) {
 	}, nil
 }
 
+// initializationOptions are the settings a client may send in the
+// initialize request.
+type initializationOptions struct {
+	// Extensions are enabled in every environment, including those built
+	// from config files.
+	Extensions []extension `json:"extensions"`
+	// InlayHints configures inlay hints.
+	InlayHints inlayHintOptions `json:"inlayHints"`
+}
+
+// inlayHintOptions are the client's settings for inlay hints.
+type inlayHintOptions struct {
+	// Subexpressions adds hints for the values of subexpressions, such as
+	// the operands of && and ||, and the results of calls.
+	Subexpressions bool `json:"subexpressions"`
+	// CostLimit bounds the work evaluating an expression may do, including
+	// building lists and strings, in cel-go's units of cost. If it's 0,
+	// defaultCostLimit applies. There's no separate memory budget: each
+	// step that builds a list, map or element of one costs at least a
+	// unit, and concatenating strings a unit per ten bytes, so the cost
+	// limit bounds what evaluating may allocate too.
+	CostLimit uint64 `json:"costLimit"`
+	// Timeout bounds the time evaluating an expression may take, as a
+	// duration such as "200ms". If it's empty, defaultEvalTimeout applies.
+	Timeout string `json:"timeout"`
+}
+
+const (
+	// defaultCostLimit is the cost limit for evaluating inlay hints if the
+	// client doesn't set one.
+	defaultCostLimit = 1_000_000
+	// defaultEvalTimeout is the time budget for evaluating inlay hints if
+	// the client doesn't set one.
+	defaultEvalTimeout = 100 * time.Millisecond
+	// interruptCheckFrequency is the number of iterations of a
+	// comprehension between checks of the time budget. Each comprehension
+	// of a nested one only stops at a check, so every iteration checks.
+	interruptCheckFrequency = 10
+)
+
+// validate reports whether the options are well-formed.
+func (o inlayHintOptions) validate() error {
+	if o.Timeout == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(o.Timeout); err != nil || d <= 0 {
+		return fmt.Errorf("inlayHints: invalid timeout %q, expected a positive duration such as 200ms", o.Timeout)
+	}
+	return nil
+}
+
+// costLimit returns the cost limit for evaluating an expression.
+func (o inlayHintOptions) costLimit() uint64 {
+	if o.CostLimit == 0 {
+		return defaultCostLimit
+	}
+	return o.CostLimit
+}
+
+// timeout returns the time budget for evaluating an expression.
+func (o inlayHintOptions) timeout() time.Duration {
+	if d, err := time.ParseDuration(o.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultEvalTimeout
+}
+
+// applyInitializationOptions applies the client's settings, rebuilding the
+// default environment.
+func (s *server) applyInitializationOptions(raw any) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	var opts initializationOptions
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return err
+	}
+	for _, e := range opts.Extensions {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	if err := opts.InlayHints.validate(); err != nil {
+		return err
+	}
+
+	s.envOptions = append(s.envOptions, extensionOptions(opts.Extensions)...)
+	celEnv, err := s.newEnv()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.celEnv = celEnv
+	s.inlayHintOptions = opts.InlayHints
+	clear(s.envs)
+	s.mu.Unlock()
+	return nil
+}
+
 func (s *server) didOpen(_ context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) error {
 	var params protocol.DidOpenTextDocumentParams
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
@@ -167,8 +374,11 @@ func (s *server) didOpen(_ context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.R
 	s.files[params.TextDocument.URI] = f
 	uri, version, content := f.uri, f.version, f.content
 	s.mu.Unlock()
+	if isConfigURI(uri) {
+		s.forgetConfigs()
+	}
 
-	publishDiagnostics(conn, uri, version, content, s.celEnv)
+	s.publishAllDiagnostics(conn, uri, version, content)
 	return nil
 }
 
@@ -188,25 +398,58 @@ func (s *server) didChange(_ context.Context, conn *jsonrpc2.Conn, req *jsonrpc2
 
 	// We use full sync mode, so extract full text from content changes.
 	if len(params.ContentChanges) > 0 {
-		change := params.ContentChanges[0]
-		switch v := change.Value.(type) {
-		case protocol.TextDocumentContentChangeWholeDocument:
-			f.content = v.Text
-		case *protocol.TextDocumentContentChangeWholeDocument:
-			f.content = v.Text
-		case protocol.TextDocumentContentChangePartial:
-			f.content = v.Text
-		case *protocol.TextDocumentContentChangePartial:
-			f.content = v.Text
+		if text, ok := changeText(params.ContentChanges[0]); ok {
+			f.content = text
 		}
 	}
 	uri, version, content := f.uri, f.version, f.content
 	s.mu.Unlock()
 
-	publishDiagnostics(conn, uri, version, content, s.celEnv)
+	s.publishAllDiagnostics(conn, uri, version, content)
 	return nil
 }
 
+// changeText returns the text of a content change, which is the whole
+// document, as we use full sync mode.
+func changeText(change protocol.TextDocumentContentChangeEvent) (string, bool) {
+	switch v := change.Value.(type) {
+	case protocol.TextDocumentContentChangeWholeDocument:
+		return v.Text, true
+	case *protocol.TextDocumentContentChangeWholeDocument:
+		return v.Text, true
+	case protocol.TextDocumentContentChangePartial:
+		return v.Text, true
+	case *protocol.TextDocumentContentChangePartial:
+		return v.Text, true
+	}
+	return "", false
+}
+
+// publishAllDiagnostics pushes diagnostics for the document at uri. Config
+// files get diagnostics for the config itself; for other documents, any
+// problems with the config that applies to them are published too.
+func (s *server) publishAllDiagnostics(conn *jsonrpc2.Conn, uri protocol.DocumentURI, version int32, content string) {
+	path, err := uri.Path()
+	if err != nil || path == "" {
+		publishDiagnostics(conn, uri, version, content, s.celEnv)
+		return
+	}
+	if configPath, ok := s.configDocument(path); ok {
+		s.publishConfigDiagnostics(conn, configPath)
+		return
+	}
+	if exprs, ok := s.embeddedIn(path, content); ok {
+		_ = conn.Notify(context.Background(), "textDocument/publishDiagnostics", protocol.PublishDiagnosticsParams{
+			URI:         uri,
+			Version:     version,
+			Diagnostics: append(embeddedDiagnostics(exprs, content), s.inputDiagnostics(path, content)...),
+		})
+	} else {
+		publishDiagnostics(conn, uri, version, content, s.envFor(uri))
+	}
+	s.publishConfigDiagnostics(conn, s.findConfig(filepath.Dir(path)))
+}
+
 func (s *server) didClose(req *jsonrpc2.Request) error {
 	var params protocol.DidCloseTextDocumentParams
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
@@ -214,9 +457,12 @@ func (s *server) didClose(req *jsonrpc2.Request) error {
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	delete(s.files, params.TextDocument.URI)
+	delete(s.embeds, params.TextDocument.URI)
+	s.mu.Unlock()
+	if isConfigURI(params.TextDocument.URI) {
+		s.forgetConfigs()
+	}
 	return nil
 }
 
@@ -233,5 +479,8 @@ func (s *server) semanticTokensFull(req *jsonrpc2.Request) (any, error) {
 	if f == nil {
 		return nil, nil
 	}
-	return computeSemanticTokens(f, s.celEnv)
+	if exprs, ok := s.embedded(f); ok {
+		return embeddedSemanticTokens(exprs, f.content), nil
+	}
+	return computeSemanticTokens(f, s.envFor(f.uri))
 }
@@ -14,8 +14,16 @@ import (
 )
 
 // setupLSPServer creates and initializes an LSP server for testing.
-// Returns the client JSON-RPC connection and the test file URI.
+// Returns the client JSON-RPC connection and the test file URI. With no
+// test file, no document is opened.
 func setupLSPServer(t *testing.T, testFilePath string) (*jsonrpc2.Conn, protocol.DocumentURI) {
+	t.Helper()
+	return setupLSPServerWithOptions(t, testFilePath, nil)
+}
+
+// setupLSPServerWithOptions is like setupLSPServer, but sends the given
+// initializationOptions in the initialize request.
+func setupLSPServerWithOptions(t *testing.T, testFilePath string, initOptions any) (*jsonrpc2.Conn, protocol.DocumentURI) {
 	t.Helper()
 	ctx := t.Context()
 
@@ -43,12 +51,17 @@ func setupLSPServer(t *testing.T, testFilePath string) (*jsonrpc2.Conn, protocol
 	testURI := protocol.URIFromPath(testFilePath)
 
 	var initResult protocol.InitializeResult
-	err := clientRPC.Call(ctx, "initialize", protocol.InitializeParams{}, &initResult)
+	var params protocol.InitializeParams
+	params.InitializationOptions = initOptions
+	err := clientRPC.Call(ctx, "initialize", params, &initResult)
 	be.Err(t, err, nil)
 
 	err = clientRPC.Notify(ctx, "initialized", protocol.InitializedParams{})
 	be.Err(t, err, nil)
 
+	if testFilePath == "" {
+		return clientRPC, ""
+	}
 	content, err := os.ReadFile(testFilePath)
 	be.Err(t, err, nil)
 
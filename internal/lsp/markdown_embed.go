@@ -0,0 +1,153 @@
+package lsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// markdownExpressions returns the CEL expressions in the Markdown document
+// at path: the content of each fenced code block whose info string starts
+// with "cel". An env attribute in the info string, as in "```cel
+// env=kubernetes-crd", selects the block's profile, as a cells:profile
+// directive does. Otherwise blocks use the environment of the config file
+// that applies to the document, if any.
+func (s *server) markdownExpressions(path, content string, read func(string) ([]byte, error)) []*embeddedExpr {
+	configPath := s.findConfig(filepath.Dir(path))
+	var exprs []*embeddedExpr
+	for _, block := range markdownFences(content) {
+		fields := strings.Fields(block.info)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "cel") {
+			continue
+		}
+		e := &embeddedExpr{file: &file{content: block.content}, offsets: block.offsets}
+
+		profile := fileProfile(block.content)
+		for _, attr := range fields[1:] {
+			name, value, ok := strings.Cut(attr, "=")
+			if !ok || name != "env" {
+				continue
+			}
+			if err := validateProfile(value); err != nil {
+				start := block.infoStart + strings.Index(block.info, attr)
+				e.diagnostics = append(e.diagnostics, hostDiagnostic(content, start, start+len(attr), fmt.Sprintf("env: %v", err)))
+				continue
+			}
+			profile = value
+		}
+
+		e.env = s.celEnv
+		if key := (envKey{configPath: configPath, profile: profile}); key != (envKey{}) {
+			if entry := s.hostEnv(key, read); len(entry.errs) == 0 {
+				e.env = entry.env
+			}
+		}
+		e.env = withDirectives(e.env, block.content)
+		exprs = append(exprs, e)
+	}
+	return exprs
+}
+
+// hostDiagnostic returns an error diagnostic for the bytes of host between
+// start and end.
+func hostDiagnostic(host string, start, end int, msg string) protocol.Diagnostic {
+	return protocol.Diagnostic{
+		Range:    offsetRange(host, start, end),
+		Severity: protocol.SeverityError,
+		Source:   serverName,
+		Message:  msg,
+	}
+}
+
+// markdownFence is a fenced code block in a Markdown document.
+type markdownFence struct {
+	info string
+	// infoStart is the offset of the info string in the document.
+	infoStart int
+	// content is the block's content, without the indentation of the
+	// fence or its last line break, and offsets the offset of each of its
+	// bytes, and its end, in the document.
+	content string
+	offsets []int
+}
+
+// markdownFences returns the fenced code blocks of a Markdown document. A
+// block that isn't closed extends to the end of the document.
+func markdownFences(content string) []markdownFence {
+	var (
+		fences []markdownFence
+		open   *markdownFence
+		// marker is the open fence's run of backticks or tildes, and
+		// indent its indentation.
+		marker string
+		indent int
+		value  []byte
+	)
+	for start := 0; start < len(content); {
+		end := strings.IndexByte(content[start:], '\n')
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += start
+		}
+		line := strings.TrimSuffix(content[start:end], "\r")
+		spaces := len(line) - len(strings.TrimLeft(line, " "))
+		rest := line[spaces:]
+
+		switch {
+		case open == nil:
+			if run := fenceRun(rest); spaces <= 3 && run != "" && !(run[0] == '`' && strings.Contains(rest[len(run):], "`")) {
+				info := strings.TrimSpace(rest[len(run):])
+				open = &markdownFence{info: info, infoStart: start + strings.Index(line, info)}
+				marker, indent, value = run, spaces, nil
+			}
+		case spaces <= 3 && strings.HasPrefix(rest, marker) && strings.Trim(rest, marker[:1]+" \t") == "":
+			fences = append(fences, open.close(value, start))
+			open = nil
+		default:
+			// Up to the fence's indentation is removed from each line.
+			from := start + min(spaces, indent)
+			for i := from; i < start+len(line); i++ {
+				value = append(value, content[i])
+				open.offsets = append(open.offsets, i)
+			}
+			value = append(value, '\n')
+			open.offsets = append(open.offsets, end)
+		}
+		start = end + 1
+	}
+	if open != nil {
+		fences = append(fences, open.close(value, len(content)))
+	}
+	return fences
+}
+
+// close finishes a block whose content is value. end is the offset of the
+// end of an empty block.
+func (f *markdownFence) close(value []byte, end int) markdownFence {
+	if len(value) > 0 {
+		// The last line break belongs to the closing fence, and the
+		// content ends where it is.
+		value = value[:len(value)-1]
+		end = f.offsets[len(f.offsets)-1]
+		f.offsets = f.offsets[:len(f.offsets)-1]
+	}
+	f.content = string(value)
+	f.offsets = append(f.offsets, end)
+	return *f
+}
+
+// fenceRun returns the run of three or more backticks or tildes that line
+// starts with, if any.
+func fenceRun(line string) string {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+	n := len(line) - len(strings.TrimLeft(line, line[:1]))
+	if n < 3 {
+		return ""
+	}
+	return line[:n]
+}
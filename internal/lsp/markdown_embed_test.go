@@ -0,0 +1,60 @@
+package lsp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+const markdownFile = "testdata/markdown/runbook.md"
+
+func TestMarkdownDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, markdownFile))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, len(diags), 2)
+
+	// The indentation of the fence is removed from the block's lines.
+	be.Equal(t, diags[0].Message, "found no matching overload for '+' applied to '(int, string)'")
+	be.Equal(t, diags[0].Range.Start, protocol.Position{Line: 18, Character: 17})
+
+	// An unknown environment is reported on the info string.
+	be.True(t, strings.Contains(diags[1].Message, `unknown profile "k8s"`))
+	be.Equal(t, diags[1].Range, protocol.Range{
+		Start: protocol.Position{Line: 21, Character: 7},
+		End:   protocol.Position{Line: 21, Character: 14},
+	})
+}
+
+func TestMarkdownHover(t *testing.T) {
+	t.Parallel()
+
+	requireHoverContains(t, markdownFile, 11, 2, "kubernetes.AdmissionRequest", "env attribute")
+	requireHoverContains(t, markdownFile, 12, 4, "The object from the incoming request.", "second line")
+	requireNoHover(t, markdownFile, 2, 3, "prose")
+	requireNoHover(t, markdownFile, 25, 2, "other language")
+}
+
+func TestMarkdownSemanticTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens := getSemanticTokens(t, markdownFile)
+	assertTokens(t, tokens, []expectedToken{
+		{5, 1, 1, stNumber, "number in first block"},
+		{18, 8, 7, stString, "string in indented block"},
+	})
+	be.True(t, !findTokenOnLine(tokens, 25, stString))
+}
+
+func TestMarkdownInlayHints(t *testing.T) {
+	t.Parallel()
+
+	hints := getInlayHints(t, markdownFile)
+	be.Equal(t, len(hints), 2)
+	be.Equal(t, hints[0].Position, protocol.Position{Line: 5, Character: 23})
+	be.Equal(t, hints[0].Label[0].Value, "→ [2, 4, 6] (list(int))")
+	be.Equal(t, hints[1].Position, protocol.Position{Line: 22, Character: 5})
+}
@@ -0,0 +1,190 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// cellBindingPrefix starts the first line of a notebook cell that binds its
+// result to a name, as in
+//
+//	# name: limits
+//
+// Later cells reference the result as a variable of that name.
+const cellBindingPrefix = "# name:"
+
+// notebook tracks an open notebook document, whose code cells are each a
+// CEL expression.
+type notebook struct {
+	uri     protocol.URI
+	version int32
+	// cells are the notebook's cells, in order.
+	cells []protocol.NotebookCell
+}
+
+func (s *server) didOpenNotebook(conn *jsonrpc2.Conn, req *jsonrpc2.Request) error {
+	var params protocol.DidOpenNotebookDocumentParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return err
+	}
+
+	nb := &notebook{
+		uri:     params.NotebookDocument.URI,
+		version: params.NotebookDocument.Version,
+		cells:   params.NotebookDocument.Cells,
+	}
+	s.mu.Lock()
+	s.notebooks[nb.uri] = nb
+	for _, doc := range params.CellTextDocuments {
+		s.files[doc.URI] = &file{uri: doc.URI, version: doc.Version, content: doc.Text, notebook: nb}
+	}
+	s.mu.Unlock()
+
+	s.publishNotebookDiagnostics(conn, nb)
+	return nil
+}
+
+func (s *server) didChangeNotebook(conn *jsonrpc2.Conn, req *jsonrpc2.Request) error {
+	var params protocol.DidChangeNotebookDocumentParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	nb := s.notebooks[params.NotebookDocument.URI]
+	if nb == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("received update for notebook that was not open: %q", params.NotebookDocument.URI)
+	}
+	nb.version = params.NotebookDocument.Version
+	if changes := params.Change.Cells; changes != nil {
+		if structure := changes.Structure; structure != nil {
+			start := min(int(structure.Array.Start), len(nb.cells))
+			end := min(start+int(structure.Array.DeleteCount), len(nb.cells))
+			nb.cells = slices.Replace(nb.cells, start, end, structure.Array.Cells...)
+			for _, doc := range structure.DidOpen {
+				s.files[doc.URI] = &file{uri: doc.URI, version: doc.Version, content: doc.Text, notebook: nb}
+			}
+			for _, doc := range structure.DidClose {
+				delete(s.files, doc.URI)
+			}
+		}
+		// Changes to a cell's data, such as its kind, replace the cell.
+		for _, cell := range changes.Data {
+			for i := range nb.cells {
+				if nb.cells[i].Document == cell.Document {
+					nb.cells[i] = cell
+				}
+			}
+		}
+		for _, change := range changes.TextContent {
+			f := s.files[change.Document.URI]
+			if f == nil {
+				continue
+			}
+			f.version = change.Document.Version
+			if len(change.Changes) > 0 {
+				if text, ok := changeText(change.Changes[0]); ok {
+					f.content = text
+				}
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// A change to one cell may affect the cells after it.
+	s.publishNotebookDiagnostics(conn, nb)
+	return nil
+}
+
+func (s *server) didCloseNotebook(req *jsonrpc2.Request) error {
+	var params protocol.DidCloseNotebookDocumentParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.notebooks, params.NotebookDocument.URI)
+	for _, doc := range params.CellTextDocuments {
+		delete(s.files, doc.URI)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// publishNotebookDiagnostics pushes diagnostics for each code cell of nb.
+func (s *server) publishNotebookDiagnostics(conn *jsonrpc2.Conn, nb *notebook) {
+	for _, f := range s.codeCells(nb) {
+		_ = conn.Notify(context.Background(), "textDocument/publishDiagnostics", protocol.PublishDiagnosticsParams{
+			URI:         f.uri,
+			Version:     f.version,
+			Diagnostics: embeddedDiagnostics(s.cellExpressions(f), f.content),
+		})
+	}
+}
+
+// codeCells returns the open documents of the code cells of nb, in order.
+func (s *server) codeCells(nb *notebook) []*file {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cells []*file
+	for _, cell := range nb.cells {
+		if f := s.files[cell.Document]; cell.Kind == protocol.Code && f != nil {
+			cells = append(cells, f)
+		}
+	}
+	return cells
+}
+
+// cellExpressions returns the expression of f, a notebook cell. It's
+// analyzed with the environment of the config file that applies to the
+// notebook, if any, and a variable for each name the cells before it bind,
+// whose value is used to evaluate inlay hints.
+func (s *server) cellExpressions(f *file) []*embeddedExpr {
+	env := s.envFor(protocol.DocumentURI(f.notebook.uri))
+	vars := make(map[string]any)
+	for _, cell := range s.codeCells(f.notebook) {
+		e, name := notebookCell(cell.content)
+		e.file.uri, e.env, e.vars = cell.uri, env, maps.Clone(vars)
+		if cell == f {
+			return []*embeddedExpr{e}
+		}
+		if name != "" {
+			env = bindResult(e, name, vars, s.inlayHintOptions)
+		}
+	}
+	return nil
+}
+
+// notebookCell returns the expression in a cell with the given content, and
+// the name its first line binds the result to, if any.
+func notebookCell(content string) (*embeddedExpr, string) {
+	e := &embeddedExpr{}
+	var (
+		name string
+		// body is the offset of the expression in the cell.
+		body int
+	)
+	first, _, _ := strings.Cut(content, "\n")
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(first), cellBindingPrefix); ok {
+		body = min(len(first)+1, len(content))
+		name = strings.TrimSpace(rest)
+		if !isValidIdentifier(name) {
+			e.diagnostics = append(e.diagnostics, hostDiagnostic(content, 0, len(strings.TrimRight(first, "\r")), fmt.Sprintf("invalid name %q", name)))
+			name = ""
+		}
+	}
+	e.file = &file{content: content[body:]}
+	e.offsets = make([]int, len(content)-body+1)
+	for i := range e.offsets {
+		e.offsets[i] = body + i
+	}
+	return e, name
+}
@@ -0,0 +1,163 @@
+package lsp_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// notebookURI is the URI of the notebook the tests open.
+const notebookURI = "file:///tmp/limits.celnb"
+
+// cellURI returns the URI of the i-th cell the tests open.
+func cellURI(i int) protocol.DocumentURI {
+	return protocol.DocumentURI(fmt.Sprintf("vscode-notebook-cell:/tmp/limits.celnb#cell%d", i))
+}
+
+// openNotebook opens a notebook with a code cell for each of the given
+// contents, returning the cells' URIs.
+func openNotebook(t *testing.T, cells ...string) (*jsonrpc2.Conn, []protocol.DocumentURI) {
+	t.Helper()
+	conn, _ := setupLSPServer(t, "")
+	params := protocol.DidOpenNotebookDocumentParams{
+		NotebookDocument: protocol.NotebookDocument{URI: notebookURI, NotebookType: "cel-notebook", Version: 1},
+	}
+	var uris []protocol.DocumentURI
+	for i, content := range cells {
+		uri := cellURI(i)
+		uris = append(uris, uri)
+		params.NotebookDocument.Cells = append(params.NotebookDocument.Cells, protocol.NotebookCell{Kind: protocol.Code, Document: uri})
+		params.CellTextDocuments = append(params.CellTextDocuments, protocol.TextDocumentItem{URI: uri, LanguageID: "cel", Version: 1, Text: content})
+	}
+	be.Err(t, conn.Notify(t.Context(), "notebookDocument/didOpen", params), nil)
+	return conn, uris
+}
+
+// cellHints returns the labels of the inlay hints of the cell at uri.
+func cellHints(t *testing.T, conn *jsonrpc2.Conn, uri protocol.DocumentURI) []string {
+	t.Helper()
+	var result []protocol.InlayHint
+	err := conn.Call(t.Context(), "textDocument/inlayHint", protocol.InlayHintParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range: protocol.Range{
+			End: protocol.Position{Line: 1000, Character: 1000},
+		},
+	}, &result)
+	be.Err(t, err, nil)
+	labels := []string{}
+	for _, hint := range result {
+		labels = append(labels, hint.Label[0].Value)
+	}
+	return labels
+}
+
+func TestNotebookInlayHints(t *testing.T) {
+	t.Parallel()
+
+	conn, uris := openNotebook(t,
+		"# name: limits\n{'max': 10}",
+		"# name: doubled\nlimits.max * 2",
+		"doubled + limits.max",
+	)
+	be.Equal(t, cellHints(t, conn, uris[0]), []string{`→ {"max": 10} (map(string, int))`})
+	be.Equal(t, cellHints(t, conn, uris[1]), []string{"→ 20 (int)"})
+	be.Equal(t, cellHints(t, conn, uris[2]), []string{"→ 30 (int)"})
+}
+
+func TestNotebookDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uris := openNotebook(t,
+		"later + 1",
+		"# name: later\n1",
+		"# name: not-a-name\n2",
+		"later + 1",
+	)
+	be.True(t, containsSubstring(diagMessages(pullDiagnostics(t, conn, uris[0])), "undeclared reference to 'later'"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uris[1])), []string{})
+
+	diags := pullDiagnostics(t, conn, uris[2])
+	be.Equal(t, diagMessages(diags), []string{`invalid name "not-a-name"`})
+	be.Equal(t, diags[0].Range, protocol.Range{End: protocol.Position{Character: 18}})
+
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uris[3])), []string{})
+}
+
+func TestNotebookHover(t *testing.T) {
+	t.Parallel()
+
+	conn, uris := openNotebook(t,
+		"# name: limits\n{'max': 10}",
+		"limits.max",
+	)
+	var result *protocol.Hover
+	err := conn.Call(t.Context(), "textDocument/hover", protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uris[1]},
+			Position:     protocol.Position{Line: 0, Character: 2},
+		},
+	}, &result)
+	be.Err(t, err, nil)
+	be.True(t, result != nil)
+	be.True(t, containsSubstring([]string{result.Contents.Value}, "map(string, int)"))
+}
+
+func TestNotebookChange(t *testing.T) {
+	t.Parallel()
+
+	conn, uris := openNotebook(t,
+		"# name: limits\n{'max': 10}",
+		"limits.max * 2",
+	)
+	be.Equal(t, cellHints(t, conn, uris[1]), []string{"→ 20 (int)"})
+
+	// Editing a cell changes the cells after it.
+	err := conn.Notify(t.Context(), "notebookDocument/didChange", protocol.DidChangeNotebookDocumentParams{
+		NotebookDocument: protocol.VersionedNotebookDocumentIdentifier{URI: notebookURI, Version: 2},
+		Change: protocol.NotebookDocumentChangeEvent{
+			Cells: &protocol.NotebookDocumentCellChanges{
+				TextContent: []protocol.NotebookDocumentCellContentChanges{{
+					Document: protocol.VersionedTextDocumentIdentifier{
+						TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uris[0]},
+						Version:                2,
+					},
+					Changes: []protocol.TextDocumentContentChangeEvent{{
+						Value: protocol.TextDocumentContentChangeWholeDocument{Text: "# name: limits\n{'max': 50}"},
+					}},
+				}},
+			},
+		},
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, cellHints(t, conn, uris[1]), []string{"→ 100 (int)"})
+
+	// A cell inserted between them rebinds the name.
+	inserted := cellURI(2)
+	err = conn.Notify(t.Context(), "notebookDocument/didChange", protocol.DidChangeNotebookDocumentParams{
+		NotebookDocument: protocol.VersionedNotebookDocumentIdentifier{URI: notebookURI, Version: 3},
+		Change: protocol.NotebookDocumentChangeEvent{
+			Cells: &protocol.NotebookDocumentCellChanges{
+				Structure: &protocol.NotebookDocumentCellChangeStructure{
+					Array: protocol.NotebookCellArrayChange{
+						Start: 1,
+						Cells: []protocol.NotebookCell{{Kind: protocol.Code, Document: inserted}},
+					},
+					DidOpen: []protocol.TextDocumentItem{{URI: inserted, LanguageID: "cel", Version: 1, Text: "# name: limits\n{'max': 1}"}},
+				},
+			},
+		},
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, cellHints(t, conn, uris[1]), []string{"→ 2 (int)"})
+
+	// Once closed, the cells are forgotten.
+	err = conn.Notify(t.Context(), "notebookDocument/didClose", protocol.DidCloseNotebookDocumentParams{
+		NotebookDocument:  protocol.NotebookDocumentIdentifier{URI: notebookURI},
+		CellTextDocuments: []protocol.TextDocumentIdentifier{{URI: uris[0]}, {URI: uris[1]}, {URI: inserted}},
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, cellHints(t, conn, uris[1]), []string{})
+}
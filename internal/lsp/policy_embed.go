@@ -0,0 +1,162 @@
+package lsp
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path/filepath"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	"go.yaml.in/yaml/v3"
+)
+
+// isPolicy reports whether doc is a cel-go policy, which has a rule rather
+// than a Kubernetes kind.
+func isPolicy(doc *yaml.Node) bool {
+	rule := yamlField(doc, "rule")
+	return rule != nil && rule.Kind == yaml.MappingNode && yamlField(doc, "kind") == nil
+}
+
+// policyExpressions returns the expressions of doc, a cel-go policy in the
+// YAML file at path. Each is analyzed with the variables declared before it
+// in its rule and the rules that contain it, as variables.<name>, and the
+// policy is checked as a whole the way cel-go composes it: conditions must
+// be bools, explanations strings, and every output of a rule must have the
+// same type.
+func (s *server) policyExpressions(path, content string, doc *yaml.Node, read func(string) ([]byte, error)) []*embeddedExpr {
+	env := s.celEnv
+	if configPath := s.findConfig(filepath.Dir(path)); configPath != "" {
+		if entry := s.hostEnv(envKey{configPath: configPath}, read); len(entry.errs) == 0 {
+			env = entry.env
+		}
+	}
+	var abbrevs []string
+	for _, imp := range yamlItems(yamlField(doc, "imports")) {
+		if name := yamlField(imp, "name"); isYAMLString(name) {
+			abbrevs = append(abbrevs, name.Value)
+		}
+	}
+	if len(abbrevs) > 0 {
+		if extended, err := env.Extend(cel.Abbrevs(abbrevs...)); err == nil {
+			env = extended
+		}
+	}
+
+	c := &policyCompiler{content: content}
+	c.rule(yamlField(doc, "rule"), env, map[string]protocol.Range{})
+	return c.exprs
+}
+
+// policyCompiler collects the expressions of a policy while checking how
+// they compose.
+type policyCompiler struct {
+	content string
+	exprs   []*embeddedExpr
+}
+
+// expr adds the expression held by n, returning it and its type, which is
+// nil if it doesn't compile.
+func (c *policyCompiler) expr(n *yaml.Node, env *cel.Env, defs map[string]protocol.Range) (*embeddedExpr, *cel.Type) {
+	e := yamlExpr(c.content, n, env)
+	e.definitions = defs
+	c.exprs = append(c.exprs, e)
+	checked, iss := env.Compile(e.file.content)
+	if iss.Err() != nil {
+		return e, nil
+	}
+	return e, checked.OutputType()
+}
+
+// report adds a diagnostic about how the expression e composes.
+func (c *policyCompiler) report(e *embeddedExpr, format string, args ...any) {
+	d := hostDiagnostic(c.content, e.offsets[0], e.offsets[len(e.offsets)-1], fmt.Sprintf(format, args...))
+	e.diagnostics = append(e.diagnostics, d)
+}
+
+// rule collects the expressions of a rule, in the environment env of the
+// rules that contain it, with defs locating the variables declared there.
+// It returns the type of the rule's outputs, or nil if it's unknown.
+func (c *policyCompiler) rule(n *yaml.Node, env *cel.Env, defs map[string]protocol.Range) *cel.Type {
+	defs = maps.Clone(defs)
+	for _, v := range yamlItems(yamlField(n, "variables")) {
+		name, expr := yamlField(v, "name"), yamlField(v, "expression")
+		if !isYAMLString(name) || !isYAMLString(expr) {
+			continue
+		}
+		_, t := c.expr(expr, env, defs)
+		if t == nil {
+			t = cel.DynType
+		}
+		qualified := "variables." + name.Value
+		if extended, err := env.Extend(cel.Variable(qualified, t)); err == nil {
+			env = extended
+		}
+		_, offsets := yamlScalar(c.content, name)
+		defs[qualified] = offsetRange(c.content, offsets[0], offsets[len(offsets)-1])
+	}
+
+	var (
+		outputType *cel.Type
+		// unconditional is set after a match without a condition, which
+		// makes any later match unreachable.
+		unconditional bool
+	)
+	for _, m := range yamlItems(yamlField(n, "match")) {
+		var start *embeddedExpr
+		if cond := yamlField(m, "condition"); isYAMLString(cond) {
+			e, t := c.expr(cond, env, defs)
+			start = e
+			if t != nil && !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+				c.report(e, "condition must be a bool, not %s", t)
+			}
+		}
+
+		output, nested := yamlField(m, "output"), yamlField(m, "rule")
+		var (
+			e *embeddedExpr
+			t *cel.Type
+		)
+		switch {
+		case isYAMLString(output):
+			e, t = c.expr(output, env, defs)
+			if explanation := yamlField(m, "explanation"); isYAMLString(explanation) {
+				ee, et := c.expr(explanation, env, defs)
+				if et != nil && !et.IsExactType(cel.StringType) && !et.IsExactType(cel.DynType) {
+					c.report(ee, "explanation must be a string, not %s", et)
+				}
+			}
+		case nested != nil && nested.Kind == yaml.MappingNode:
+			t = c.rule(nested, env, defs)
+		}
+		if start == nil {
+			start = e
+		}
+
+		if unconditional && start != nil {
+			c.report(start, "match is unreachable after a match without a condition")
+		}
+		if yamlField(m, "condition") == nil && output != nil {
+			unconditional = true
+		}
+		if output != nil && nested != nil && start != nil {
+			c.report(start, "a match may have an output or a rule, but not both")
+		}
+
+		if t == nil || t.IsExactType(cel.DynType) {
+			continue
+		}
+		if outputType == nil {
+			outputType = t
+			continue
+		}
+		if !outputType.IsAssignableType(t) {
+			// A nested rule's outputs are reported at its match's
+			// condition.
+			if target := cmp.Or(e, start); target != nil {
+				c.report(target, "output has type %s, but earlier outputs have type %s", t, outputType)
+			}
+		}
+	}
+	return outputType
+}
@@ -0,0 +1,90 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// requestDefinition sends a textDocument/definition request at the given
+// position.
+func requestDefinition(t *testing.T, conn *jsonrpc2.Conn, uri protocol.DocumentURI, pos protocol.Position) []protocol.Location {
+	t.Helper()
+	var result []protocol.Location
+	err := conn.Call(t.Context(), "textDocument/definition", protocol.DefinitionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     pos,
+		},
+	}, &result)
+	be.Err(t, err, nil)
+	return result
+}
+
+func TestPolicyDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/policy/limits.yaml"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/policy/invalid.yaml"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{
+		"condition must be a bool, not int",
+		"output has type int, but earlier outputs have type string",
+		"explanation must be a string, not int",
+		"match is unreachable after a match without a condition",
+	})
+	var lines []uint32
+	for _, d := range diags {
+		lines = append(lines, d.Range.Start.Line)
+	}
+	be.Equal(t, lines, []uint32{6, 8, 9, 10})
+}
+
+func TestPolicyHover(t *testing.T) {
+	t.Parallel()
+
+	const file = "testdata/policy/limits.yaml"
+	requireHoverContains(t, file, 6, 31, "**Variable**: `variables.replicas`", "earlier variable")
+	requireHoverContains(t, file, 17, 60, "**Variable**: `variables.min_replicas`", "nested rule variable")
+	requireHoverContains(t, file, 4, 23, "**Variable**: `resource`", "config variable")
+}
+
+func TestPolicyDefinition(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/policy/limits.yaml"))
+	tests := []struct {
+		name string
+		pos  protocol.Position
+		want protocol.Range
+	}{
+		{
+			"variable in a later variable",
+			protocol.Position{Line: 6, Character: 31},
+			protocol.Range{Start: protocol.Position{Line: 3, Character: 12}, End: protocol.Position{Line: 3, Character: 20}},
+		},
+		{
+			"outer variable in a nested rule",
+			protocol.Position{Line: 17, Character: 35},
+			protocol.Range{Start: protocol.Position{Line: 3, Character: 12}, End: protocol.Position{Line: 3, Character: 20}},
+		},
+		{
+			"nested rule variable",
+			protocol.Position{Line: 17, Character: 58},
+			protocol.Range{Start: protocol.Position{Line: 14, Character: 18}, End: protocol.Position{Line: 14, Character: 30}},
+		},
+	}
+	for _, tt := range tests {
+		locs := requestDefinition(t, conn, uri, tt.pos)
+		be.Equal(t, len(locs), 1)
+		be.Equal(t, locs[0].URI, uri)
+		be.Equal(t, locs[0].Range, tt.want)
+	}
+
+	// The variables prefix itself isn't declared anywhere.
+	be.Equal(t, len(requestDefinition(t, conn, uri, protocol.Position{Line: 6, Character: 20})), 0)
+}
@@ -0,0 +1,37 @@
+package lsp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// profiles are the names of the built-in environments for the places CEL is
+// embedded, which declare the variables and libraries available there.
+var profiles = []string{
+	profileKubernetesAdmission,
+	profileKubernetesCRD,
+	profileProtovalidate,
+}
+
+// validateProfile reports whether name is a known profile.
+func validateProfile(name string) error {
+	if !slices.Contains(profiles, name) {
+		return fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(profiles, ", "))
+	}
+	return nil
+}
+
+// profileOptions returns the options declaring the given profile, which may
+// be configured by c.
+func (c *config) profileOptions(profile, dir string, read func(string) ([]byte, error)) ([]cel.EnvOption, []configError) {
+	switch profile {
+	case profileKubernetesAdmission, profileKubernetesCRD:
+		return c.kubernetesOptions(profile, dir, read)
+	case profileProtovalidate:
+		return c.protovalidateOptions(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,193 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/bufbuild/protocompile/reporter"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// loadDescriptorSet decodes a FileDescriptorSet, in either the binary or the
+// JSON encoding, and resolves it into file descriptors.
+func loadDescriptorSet(data []byte) ([]protoreflect.FileDescriptor, error) {
+	var fds descriptorpb.FileDescriptorSet
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := protojson.Unmarshal(data, &fds); err != nil {
+			return nil, err
+		}
+	} else if err := proto.Unmarshal(data, &fds); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, err
+	}
+	var descs []protoreflect.FileDescriptor
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		descs = append(descs, fd)
+		return true
+	})
+	return descs, nil
+}
+
+// compileProtos parses and links every .proto file beneath the given import
+// paths, which are listed with list. Files may import each other relative to
+// any of the import paths, as well as the well-known types. Compilation
+// errors are returned as configErrors against the offending .proto file.
+func compileProtos(importPaths []string, read func(string) ([]byte, error), list func(string) ([]fs.DirEntry, error)) ([]protoreflect.FileDescriptor, []configError) {
+	var names []string
+	var walk func(root, dir string)
+	walk = func(root, dir string) {
+		entries, _ := list(dir)
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			switch {
+			case e.IsDir():
+				walk(root, path)
+			case filepath.Ext(path) == ".proto":
+				if rel, err := filepath.Rel(root, path); err == nil {
+					names = append(names, filepath.ToSlash(rel))
+				}
+			}
+		}
+	}
+	for _, dir := range importPaths {
+		walk(dir, dir)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// Files are linked leniently, so that imports that can't be found,
+	// such as buf/validate/validate.proto, don't prevent the rest of a file
+	// from being used. Errors, such as references to types from those
+	// imports, are attributed to the file they're in.
+	var errs []configError
+	l := &protoLinker{
+		importPaths: importPaths,
+		read:        read,
+		files:       make(map[string]linker.File),
+		paths:       make(map[string]string),
+	}
+	l.report = func(err reporter.ErrorWithPos) {
+		pos := err.GetPosition()
+		path, ok := l.paths[pos.Filename]
+		if !ok {
+			path = pos.Filename
+		}
+		errs = append(errs, configError{path: path, line: pos.Line - 1, msg: err.Unwrap().Error()})
+	}
+	var descs []protoreflect.FileDescriptor
+	for _, name := range names {
+		if f := l.file(name); f != nil {
+			descs = append(descs, f)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return descs, nil
+}
+
+// loadProtoTypes loads the message and enum types declared by the config's
+// descriptor sets and proto paths, resolving relative paths against dir.
+func (c *config) loadProtoTypes(dir string, read func(string) ([]byte, error), list func(string) ([]fs.DirEntry, error)) ([]protoreflect.FileDescriptor, []configError) {
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	var (
+		descs []protoreflect.FileDescriptor
+		errs  []configError
+	)
+	for _, name := range c.DescriptorSets {
+		data, err := read(resolve(name))
+		if err == nil {
+			var fileDescs []protoreflect.FileDescriptor
+			fileDescs, err = loadDescriptorSet(data)
+			descs = append(descs, fileDescs...)
+		}
+		if err != nil {
+			// Descriptor sets are usually binary, so report problems on the
+			// line of the config that references them.
+			errs = append(errs, referenceError(dir, name, fmt.Sprintf("descriptor set %q: %v", name, err), read))
+		}
+	}
+
+	if len(c.ProtoPaths) > 0 {
+		var importPaths []string
+		for _, name := range c.ProtoPaths {
+			path := resolve(name)
+			if _, err := list(path); err != nil {
+				errs = append(errs, referenceError(dir, name, fmt.Sprintf("proto path %q is not a directory", name), read))
+				continue
+			}
+			importPaths = append(importPaths, path)
+		}
+		fileDescs, protoErrs := compileProtos(importPaths, read, list)
+		descs = append(descs, fileDescs...)
+		errs = append(errs, protoErrs...)
+	}
+	return descs, errs
+}
+
+// protoTypeDescs returns an option registering the given file descriptors
+// with the environment's type provider.
+func protoTypeDescs(descs []protoreflect.FileDescriptor) cel.EnvOption {
+	args := make([]any, len(descs))
+	for i, d := range descs {
+		args[i] = d
+	}
+	return cel.TypeDescs(args...)
+}
+
+// messageDescriptor returns the descriptor for a message type registered in
+// the environment.
+func messageDescriptor(celEnv *cel.Env, typeName string) (protoreflect.MessageDescriptor, bool) {
+	provider := celEnv.CELTypeProvider()
+	if _, found := provider.FindStructType(typeName); !found {
+		return nil, false
+	}
+	val := provider.NewValue(typeName, map[string]ref.Val{})
+	if types.IsError(val) {
+		return nil, false
+	}
+	msg, ok := val.Value().(proto.Message)
+	if !ok {
+		return nil, false
+	}
+	return msg.ProtoReflect().Descriptor(), true
+}
+
+// fieldComment returns the leading comment of a message field, as recorded in
+// its file's source info.
+func fieldComment(celEnv *cel.Env, typeName, fieldName string) string {
+	md, ok := messageDescriptor(celEnv, typeName)
+	if !ok {
+		return ""
+	}
+	fd := md.Fields().ByName(protoreflect.Name(fieldName))
+	if fd == nil {
+		return ""
+	}
+	loc := fd.ParentFile().SourceLocations().ByDescriptor(fd)
+	return strings.TrimSpace(loc.LeadingComments)
+}
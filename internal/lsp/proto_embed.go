@@ -0,0 +1,591 @@
+package lsp
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bufbuild/protocompile/ast"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/bufbuild/protocompile/parser"
+	"github.com/bufbuild/protocompile/reporter"
+	"github.com/bufbuild/protocompile/sourceinfo"
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// protoRule is the CEL expression of a protovalidate rule in a .proto file.
+type protoRule struct {
+	expr   ast.StringValueNode
+	target protoTarget
+}
+
+// protoTarget identifies what a rule applies to, which determines the types
+// of its this and rule variables.
+type protoTarget struct {
+	// message is the fully qualified name of the message of a message or
+	// field rule, and field the name of the field of a field rule.
+	message, field string
+	// elem is "items", "keys" or "values" for the rules of the elements of
+	// a list or map field.
+	elem string
+	// rules is the name of the rules message a predefined rule extends,
+	// such as buf.validate.StringRules, and ruleType the type of the
+	// extension that the rule's value has.
+	rules        string
+	ruleType     string
+	ruleRepeated bool
+}
+
+// protoExpressions returns the CEL expressions of the protovalidate rules in
+// the .proto file at path: those of the (buf.validate.field),
+// (buf.validate.message), and (buf.validate.predefined) options. Each is
+// analyzed with this typed as the value the rule applies to, provided the
+// file's types can be linked; otherwise this is dynamically typed.
+func (s *server) protoExpressions(path, content string, read func(string) ([]byte, error)) []*embeddedExpr {
+	node, err := parser.Parse(path, strings.NewReader(content), quietHandler())
+	if node == nil {
+		return nil
+	}
+	var linked linker.File
+	if err == nil {
+		l := &protoLinker{
+			importPaths: s.protoImportPaths(path, protoPackage(node), read),
+			read:        read,
+			files:       make(map[string]linker.File),
+		}
+		linked = l.link(node)
+	}
+
+	envs := make(map[protoTarget]*cel.Env)
+	var exprs []*embeddedExpr
+	for _, rule := range protoRules(node) {
+		env, ok := envs[rule.target]
+		if !ok {
+			env = s.protoRuleEnv(linked, rule.target)
+			envs[rule.target] = env
+		}
+		if env == nil {
+			continue
+		}
+		expr, offsets := protoStringValue(node, rule.expr)
+		exprs = append(exprs, &embeddedExpr{file: &file{content: expr}, offsets: offsets, env: env})
+	}
+	return exprs
+}
+
+// protoRuleEnv returns the environment for rules that apply to target, or
+// nil if it can't be created.
+func (s *server) protoRuleEnv(linked linker.File, target protoTarget) *cel.Env {
+	var opts []cel.EnvOption
+	this := thisVariable("", "", "")
+	if linked != nil {
+		opts = append(opts, protoTypeDescs(withImports(linked)))
+		this = thisVariable(target.message, target.field, target.elem)
+	}
+	rule := cel.DynType
+	if target.rules != "" {
+		this = cel.VariableWithDoc("this", orDyn(predefinedThisTypes[target.rules]), thisDoc)
+		rule = orDyn(protoScalarTypes[target.ruleType])
+		if target.ruleRepeated {
+			rule = cel.ListType(rule)
+		}
+	}
+	env, err := s.newEnv(append(opts, protovalidateRuleOptions(this, rule)...)...)
+	if err != nil {
+		env, _ = s.newEnv(protovalidateRuleOptions(thisVariable("", "", ""), cel.DynType)...)
+	}
+	return env
+}
+
+// withImports returns fd and every file it imports, directly or indirectly,
+// as registering a file with an environment doesn't register its imports.
+func withImports(fd protoreflect.FileDescriptor) []protoreflect.FileDescriptor {
+	files := []protoreflect.FileDescriptor{fd}
+	seen := map[string]bool{fd.Path(): true}
+	for i := 0; i < len(files); i++ {
+		imports := files[i].Imports()
+		for j := range imports.Len() {
+			imp := imports.Get(j).FileDescriptor
+			if !seen[imp.Path()] {
+				seen[imp.Path()] = true
+				files = append(files, imp)
+			}
+		}
+	}
+	return files
+}
+
+// orDyn returns t, or dyn if t is nil.
+func orDyn(t *cel.Type) *cel.Type {
+	if t == nil {
+		return cel.DynType
+	}
+	return t
+}
+
+// protoScalarTypes are the CEL types of the scalar protobuf types.
+var protoScalarTypes = map[string]*cel.Type{
+	"double":   cel.DoubleType,
+	"float":    cel.DoubleType,
+	"int32":    cel.IntType,
+	"int64":    cel.IntType,
+	"sint32":   cel.IntType,
+	"sint64":   cel.IntType,
+	"sfixed32": cel.IntType,
+	"sfixed64": cel.IntType,
+	"uint32":   cel.UintType,
+	"uint64":   cel.UintType,
+	"fixed32":  cel.UintType,
+	"fixed64":  cel.UintType,
+	"bool":     cel.BoolType,
+	"string":   cel.StringType,
+	"bytes":    cel.BytesType,
+}
+
+// predefinedThisTypes are the types of this in predefined rules, keyed by
+// the rules message they extend.
+var predefinedThisTypes = map[string]*cel.Type{
+	"buf.validate.FloatRules":     cel.DoubleType,
+	"buf.validate.DoubleRules":    cel.DoubleType,
+	"buf.validate.Int32Rules":     cel.IntType,
+	"buf.validate.Int64Rules":     cel.IntType,
+	"buf.validate.SInt32Rules":    cel.IntType,
+	"buf.validate.SInt64Rules":    cel.IntType,
+	"buf.validate.SFixed32Rules":  cel.IntType,
+	"buf.validate.SFixed64Rules":  cel.IntType,
+	"buf.validate.UInt32Rules":    cel.UintType,
+	"buf.validate.UInt64Rules":    cel.UintType,
+	"buf.validate.Fixed32Rules":   cel.UintType,
+	"buf.validate.Fixed64Rules":   cel.UintType,
+	"buf.validate.BoolRules":      cel.BoolType,
+	"buf.validate.StringRules":    cel.StringType,
+	"buf.validate.BytesRules":     cel.BytesType,
+	"buf.validate.EnumRules":      cel.IntType,
+	"buf.validate.RepeatedRules":  cel.ListType(cel.DynType),
+	"buf.validate.MapRules":       cel.MapType(cel.DynType, cel.DynType),
+	"buf.validate.DurationRules":  cel.DurationType,
+	"buf.validate.TimestampRules": cel.TimestampType,
+}
+
+// protoRules returns the protovalidate rules declared by the options in a
+// .proto file.
+func protoRules(node *ast.FileNode) []protoRule {
+	var rules []protoRule
+	// addOption adds the rules of opt if it's the given protovalidate
+	// option, such as buf.validate.field.
+	addOption := func(opt *ast.OptionNode, root string, target protoTarget) {
+		parts := opt.Name.Parts
+		if len(parts) == 0 || !parts[0].IsExtension() || strings.TrimPrefix(string(parts[0].Name.AsIdentifier()), ".") != root {
+			return
+		}
+		var path []string
+		for _, part := range parts[1:] {
+			path = append(path, string(part.Name.AsIdentifier()))
+		}
+		var walk func(path []string, v ast.ValueNode)
+		walk = func(path []string, v ast.ValueNode) {
+			switch v := v.(type) {
+			case *ast.MessageLiteralNode:
+				for _, field := range v.Elements {
+					if !field.Name.IsExtension() {
+						walk(append(path[:len(path):len(path)], string(field.Name.Name.AsIdentifier())), field.Val)
+					}
+				}
+			case *ast.ArrayLiteralNode:
+				for _, elem := range v.Elements {
+					walk(path, elem)
+				}
+			case ast.StringValueNode:
+				if elem, ok := ruleElem(path); ok && (elem == "" || root == "buf.validate.field") {
+					t := target
+					t.elem = elem
+					rules = append(rules, protoRule{expr: v, target: t})
+				}
+			}
+		}
+		walk(path, opt.Val)
+	}
+	addField := func(name string, opts *ast.CompactOptionsNode, target protoTarget) {
+		if opts == nil {
+			return
+		}
+		for _, opt := range opts.Options {
+			addOption(opt, name, target)
+		}
+	}
+	addExtension := func(n *ast.ExtendNode) {
+		extendee := strings.TrimPrefix(string(n.Extendee.AsIdentifier()), ".")
+		for _, decl := range n.Decls {
+			if field, ok := decl.(*ast.FieldNode); ok {
+				addField("buf.validate.predefined", field.Options, protoTarget{
+					rules:        extendee,
+					ruleType:     string(field.FldType.AsIdentifier()),
+					ruleRepeated: field.Label.Repeated,
+				})
+			}
+		}
+	}
+
+	var addMessage func(name string, decls []ast.MessageElement)
+	addMessage = func(name string, decls []ast.MessageElement) {
+		addMessageField := func(decl ast.Node) {
+			switch n := decl.(type) {
+			case *ast.FieldNode:
+				addField("buf.validate.field", n.Options, protoTarget{message: name, field: string(n.Name.AsIdentifier())})
+			case *ast.MapFieldNode:
+				addField("buf.validate.field", n.Options, protoTarget{message: name, field: string(n.Name.AsIdentifier())})
+			}
+		}
+		for _, decl := range decls {
+			switch n := decl.(type) {
+			case *ast.OptionNode:
+				addOption(n, "buf.validate.message", protoTarget{message: name})
+			case *ast.OneofNode:
+				for _, decl := range n.Decls {
+					addMessageField(decl)
+				}
+			case *ast.MessageNode:
+				addMessage(name+"."+string(n.Name.AsIdentifier()), n.Decls)
+			case *ast.ExtendNode:
+				addExtension(n)
+			default:
+				addMessageField(decl)
+			}
+		}
+	}
+
+	prefix := protoPackage(node)
+	if prefix != "" {
+		prefix += "."
+	}
+	for _, decl := range node.Decls {
+		switch n := decl.(type) {
+		case *ast.MessageNode:
+			addMessage(prefix+string(n.Name.AsIdentifier()), n.Decls)
+		case *ast.ExtendNode:
+			addExtension(n)
+		}
+	}
+	return rules
+}
+
+// ruleElem returns the elements a rule applies to, given the path of fields
+// to its expression within the option, and whether the path is to a rule's
+// expression at all.
+func ruleElem(path []string) (string, bool) {
+	switch n := len(path); {
+	case n >= 2 && path[n-2] == "cel" && path[n-1] == "expression":
+		path = path[:n-2]
+	case n >= 1 && path[n-1] == "cel_expression":
+		path = path[:n-1]
+	default:
+		return "", false
+	}
+	switch strings.Join(path, ".") {
+	case "":
+		return "", true
+	case "repeated.items":
+		return "items", true
+	case "map.keys":
+		return "keys", true
+	case "map.values":
+		return "values", true
+	}
+	return "", false
+}
+
+// protoPackage returns the package declared by a .proto file, if any.
+func protoPackage(node *ast.FileNode) string {
+	for _, decl := range node.Decls {
+		if pkg, ok := decl.(*ast.PackageNode); ok {
+			return string(pkg.Name.AsIdentifier())
+		}
+	}
+	return ""
+}
+
+// protoStringValue returns the value of a string literal in a .proto file,
+// which may be several adjacent literals, and the byte offset in the file of
+// each of its bytes and its end.
+func protoStringValue(node *ast.FileNode, v ast.StringValueNode) (string, []int) {
+	literals := []ast.Node{v}
+	if compound, ok := v.(*ast.CompoundStringLiteralNode); ok {
+		literals = compound.Children()
+	}
+	var (
+		value   strings.Builder
+		offsets []int
+		end     int
+	)
+	for _, lit := range literals {
+		info := node.NodeInfo(lit)
+		raw := info.RawText()
+		if len(raw) < 2 {
+			continue
+		}
+		start := info.Start().Offset
+		s, litOffsets := unquoteProto(raw[1:len(raw)-1], start+1)
+		value.WriteString(s)
+		offsets = append(offsets, litOffsets...)
+		// The end of the value is at the closing quote.
+		end = start + len(raw) - 1
+	}
+	return value.String(), append(offsets, end)
+}
+
+// unquoteProto decodes the body of a quoted string in a .proto file, which
+// starts at offset base, returning its value and the offset of the source
+// of each of its bytes. Invalid escape sequences are left as they are.
+func unquoteProto(body string, base int) (string, []int) {
+	var (
+		value   []byte
+		offsets []int
+	)
+	emit := func(start int, b ...byte) {
+		value = append(value, b...)
+		for range b {
+			offsets = append(offsets, base+start)
+		}
+	}
+	// digits returns the length of the run of at most max digits of the
+	// given base at the start of s.
+	digits := func(s string, max int, base int) int {
+		n := 0
+		for n < len(s) && n < max {
+			if _, err := strconv.ParseUint(s[n:n+1], base, 8); err != nil {
+				break
+			}
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(body); {
+		if body[i] != '\\' || i+1 == len(body) {
+			emit(i, body[i])
+			i++
+			continue
+		}
+		start := i
+		c := body[i+1]
+		i += 2
+		switch c {
+		case 'a':
+			emit(start, '\a')
+		case 'b':
+			emit(start, '\b')
+		case 'f':
+			emit(start, '\f')
+		case 'n':
+			emit(start, '\n')
+		case 'r':
+			emit(start, '\r')
+		case 't':
+			emit(start, '\t')
+		case 'v':
+			emit(start, '\v')
+		case '\\', '\'', '"', '?':
+			emit(start, c)
+		case 'x', 'X':
+			n := digits(body[i:], 2, 16)
+			if n == 0 {
+				emit(start, '\\')
+				i = start + 1
+				continue
+			}
+			b, _ := strconv.ParseUint(body[i:i+n], 16, 8)
+			emit(start, byte(b))
+			i += n
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			n := digits(body[i:], size, 16)
+			r, err := strconv.ParseUint(body[i:i+n], 16, 32)
+			if n < size || err != nil || !utf8.ValidRune(rune(r)) {
+				emit(start, '\\')
+				i = start + 1
+				continue
+			}
+			emit(start, utf8.AppendRune(nil, rune(r))...)
+			i += n
+		default:
+			n := digits(body[i-1:], 3, 8)
+			if n == 0 {
+				emit(start, '\\')
+				i = start + 1
+				continue
+			}
+			b, _ := strconv.ParseUint(body[i-1:i-1+n], 8, 8)
+			emit(start, byte(b))
+			i += n - 1
+		}
+	}
+	return string(value), offsets
+}
+
+// quietHandler returns a handler that records errors without reporting
+// them, so that parsing continues past them.
+func quietHandler() *reporter.Handler {
+	return reporter.NewHandler(reporter.NewReporter(func(reporter.ErrorWithPos) error { return nil }, nil))
+}
+
+// protoImportPaths returns the directories that imports of the .proto file
+// at path, in the given package, are resolved against: the proto paths of
+// the config file that applies to it, the root of the directories
+// matching its package, and its own directory.
+func (s *server) protoImportPaths(path, pkg string, read func(string) ([]byte, error)) []string {
+	var importPaths []string
+	dir := filepath.Dir(path)
+	if configPath := s.findConfig(dir); configPath != "" {
+		if data, err := read(configPath); err == nil {
+			if cfg, err := parseConfig(data); err == nil {
+				for _, name := range cfg.ProtoPaths {
+					if !filepath.IsAbs(name) {
+						name = filepath.Join(filepath.Dir(configPath), name)
+					}
+					importPaths = append(importPaths, name)
+				}
+			}
+		}
+	}
+	if pkg != "" {
+		pkgDir := string(filepath.Separator) + filepath.Join(strings.Split(pkg, ".")...)
+		if root, ok := strings.CutSuffix(dir, pkgDir); ok {
+			importPaths = append(importPaths, root)
+		}
+	}
+	return append(importPaths, dir)
+}
+
+// protoLinker links .proto files leniently: imports that can't be found,
+// such as buf/validate/validate.proto when it isn't vendored, are dropped,
+// and options aren't interpreted, so files using them can still be linked
+// as long as none of their types come from the missing imports.
+type protoLinker struct {
+	importPaths []string
+	read        func(string) ([]byte, error)
+	// files holds each import that has been linked, or nil if it couldn't
+	// be, keyed by import path.
+	files map[string]linker.File
+	// paths records the path each import was read from, if paths is set.
+	paths map[string]string
+	// report, if set, is called with each error in the files being linked.
+	report func(reporter.ErrorWithPos)
+}
+
+// handler returns a handler that passes errors to l.report, if set, and
+// otherwise ignores them; either way, parsing continues past them.
+func (l *protoLinker) handler() *reporter.Handler {
+	if l.report == nil {
+		return quietHandler()
+	}
+	return reporter.NewHandler(reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+		l.report(err)
+		return nil
+	}, nil))
+}
+
+// link links the parsed file, returning nil if it can't be.
+func (l *protoLinker) link(node *ast.FileNode) linker.File {
+	res, err := parser.ResultFromAST(node, true, l.handler())
+	if err != nil {
+		return nil
+	}
+	fdp := res.FileDescriptorProto()
+	var (
+		deps    linker.Files
+		kept    []string
+		indexes = make(map[int32]int32)
+	)
+	for i, name := range fdp.Dependency {
+		if dep := l.file(name); dep != nil {
+			indexes[int32(i)] = int32(len(kept))
+			deps = append(deps, dep)
+			kept = append(kept, name)
+		}
+	}
+	// remap returns the indexes of the kept dependencies in the new list.
+	remap := func(old []int32) []int32 {
+		var out []int32
+		for _, i := range old {
+			if j, ok := indexes[i]; ok {
+				out = append(out, j)
+			}
+		}
+		return out
+	}
+	fdp.PublicDependency = remap(fdp.PublicDependency)
+	fdp.WeakDependency = remap(fdp.WeakDependency)
+	fdp.Dependency = kept
+	// Linking resolves the names of custom options and the messages that
+	// extensions extend, which may come from the dropped imports. Neither
+	// are needed for their types.
+	clearOptions(fdp.ProtoReflect())
+
+	linked, err := linker.Link(res, deps, nil, l.handler())
+	if err != nil {
+		return nil
+	}
+	// Source info, which holds the comments shown on hover, is generated
+	// the way the compiler does.
+	fdp.SourceCodeInfo = sourceinfo.GenerateSourceInfo(node, nil)
+	linked.PopulateSourceCodeInfo()
+	return linked
+}
+
+// clearOptions removes the uninterpreted options and extensions declared by
+// m, a descriptor, and by every descriptor within it.
+func clearOptions(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Name() == "uninterpreted_option", fd.Name() == "extension":
+			m.Clear(fd)
+		case fd.Message() == nil || fd.IsMap():
+		case fd.IsList():
+			for i := range v.List().Len() {
+				clearOptions(v.List().Get(i).Message())
+			}
+		default:
+			clearOptions(v.Message())
+		}
+		return true
+	})
+}
+
+// file returns the linked file for the import path name, or nil if it
+// can't be found or linked.
+func (l *protoLinker) file(name string) linker.File {
+	if f, ok := l.files[name]; ok {
+		return f
+	}
+	// Guard against import cycles while the file is linked.
+	l.files[name] = nil
+
+	var f linker.File
+	if fd, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+		f, _ = linker.NewFileRecursive(fd)
+	} else {
+		for _, dir := range l.importPaths {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			data, err := l.read(path)
+			if err != nil {
+				continue
+			}
+			if l.paths != nil {
+				l.paths[name] = path
+			}
+			if node, err := parser.Parse(name, bytes.NewReader(data), l.handler()); err == nil {
+				f = l.link(node)
+			}
+			break
+		}
+	}
+	l.files[name] = f
+	return f
+}
@@ -0,0 +1,74 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestProtoEmbedDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/proto_embed/acme/v1/order.proto"))
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+
+	// The position of the error accounts for the escaped quotes and
+	// backslash before it.
+	conn, uri = setupLSPServer(t, getAbsPath(t, "testdata/proto_embed/acme/v1/invalid.proto"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, diagMessages(diags), []string{"undefined field 'nmuber'"})
+	be.Equal(t, diags[0].Range.Start, protocol.Position{Line: 9, Character: 51})
+}
+
+func TestProtoEmbedHover(t *testing.T) {
+	t.Parallel()
+
+	const file = "testdata/proto_embed/acme/v1/order.proto"
+	tests := []struct {
+		name     string
+		line     uint32
+		char     uint32
+		contains string
+	}{
+		{"message rule this", 12, 18, "**Type**: `acme.v1.Order`"},
+		{"message field", 12, 24, "**Field**: `acme.v1.Order.shipped`"},
+		{"field rule function", 18, 25, "test whether a string starts with a substring prefix"},
+		{"repeated items this", 22, 23, "**Type**: `string`"},
+		{"imported message this", 24, 59, "**Type**: `acme.v1.Money`"},
+		{"imported message field", 24, 64, "**Field**: `acme.v1.Money.units`"},
+		{"predefined rule", 32, 19, "**Type**: `bool`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			requireHoverContains(t, file, tt.line, tt.char, tt.contains, tt.name)
+		})
+	}
+}
+
+func TestProtoEmbedSemanticTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens := getSemanticTokens(t, "testdata/proto_embed/acme/v1/order.proto")
+	assertTokens(t, tokens, []expectedToken{
+		{12, 17, 4, stProperty, "this"},
+		{12, 22, 7, stProperty, "shipped"},
+		{12, 59, 4, stString, "escaped empty string"},
+		{24, 58, 4, stProperty, "this in cel_expression"},
+	})
+	// Nothing outside the expressions is highlighted.
+	be.True(t, !findTokenOnLine(tokens, 11, stString))
+}
+
+func TestProtoEmbedCompletion(t *testing.T) {
+	t.Parallel()
+
+	conn, uri, _ := setupCompletionServer(t, "testdata/proto_embed/acme/v1/order.proto")
+	result := requestCompletion(t, conn, uri, protocol.Position{Line: 12, Character: 22}, protocol.Invoked, "")
+	be.Equal(t, completionLabels(result.Items), []string{"created", "id", "shipped", "tags", "total"})
+
+	// Outside of an expression, there's nothing to complete.
+	result = requestCompletion(t, conn, uri, protocol.Position{Line: 11, Character: 10}, protocol.Invoked, "")
+	be.Equal(t, len(result.Items), 0)
+}
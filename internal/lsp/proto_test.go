@@ -0,0 +1,113 @@
+package lsp_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+func TestProtoDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"proto paths", "testdata/proto/fields.cel"},
+		{"descriptor set", "testdata/proto_descriptor_set/order.cel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, len(diags), 0)
+		})
+	}
+}
+
+func TestProtoUndefinedField(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/proto/bad_field.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "undefined field 'nmae'"))
+}
+
+func TestProtoFieldCompletion(t *testing.T) {
+	t.Parallel()
+
+	result := requestInvokedAtEnd(t, "testdata/proto/user_dot.cel")
+	item := findCompletionItem(result.Items, "name")
+	be.True(t, item != nil)
+	be.Equal(t, item.Kind, protocol.FieldCompletion)
+	be.Equal(t, item.Detail, "string")
+	be.True(t, item.Documentation != nil)
+	be.Equal(t, item.Documentation.Value.(protocol.MarkupContent).Value, "The user's display name.")
+
+	item = findCompletionItem(result.Items, "created_at")
+	be.True(t, item != nil)
+	be.Equal(t, item.Detail, "google.protobuf.Timestamp")
+
+	// Functions on other receiver types aren't offered.
+	be.True(t, !containsLabel(result.Items, "startsWith"))
+}
+
+func TestProtoFieldHover(t *testing.T) {
+	t.Parallel()
+
+	file := "testdata/proto/fields.cel"
+	requireHoverContains(t, file, 0, 5, "**Field**: `example.v1.User.name`", "field header")
+	requireHoverContains(t, file, 0, 5, "**Type**: `string`", "field type")
+	requireHoverContains(t, file, 0, 5, "The user's display name.", "field comment")
+	requireHoverContains(t, file, 0, 34, "**Type**: `int`", "int64 field")
+	requireHoverContains(t, file, 0, 34, "The user's age, in years.", "second field comment")
+}
+
+func TestProtoErrors(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/proto_invalid/protos/broken.proto"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, len(diags), 1)
+	be.Equal(t, diags[0].Range.Start.Line, uint32(6))
+	be.True(t, strings.Contains(diags[0].Message, "syntax error"))
+}
+
+func TestProtoMissingImport(t *testing.T) {
+	t.Parallel()
+
+	// Imports that can't be found are dropped, so only the reference to a
+	// type from one is an error, reported where it's made.
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/proto_missing_import/protos/acme/v1/order.proto"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.Equal(t, len(diags), 1)
+	be.Equal(t, diags[0].Range.Start.Line, uint32(8))
+	be.True(t, strings.Contains(diags[0].Message, "acme.v1.Money"))
+}
+
+func TestProtoFileAdded(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	protoDir := filepath.Join(dir, "protos")
+	be.Err(t, os.Mkdir(protoDir, 0o755), nil)
+	config := "proto_paths:\n  - protos\nvariables:\n  - name: user\n    type: acme.User\n"
+	be.Err(t, os.WriteFile(filepath.Join(dir, ".cells.yaml"), []byte(config), 0o644), nil)
+	celPath := filepath.Join(dir, "user.cel")
+	be.Err(t, os.WriteFile(celPath, []byte("user.name"), 0o644), nil)
+
+	conn, uri := setupLSPServer(t, celPath)
+	be.True(t, len(pullDiagnostics(t, conn, uri)) > 0)
+
+	// Adding a file to a proto path rebuilds the environment.
+	proto := "syntax = \"proto3\";\npackage acme;\nmessage User { string name = 1; }\n"
+	be.Err(t, os.WriteFile(filepath.Join(protoDir, "user.proto"), []byte(proto), 0o644), nil)
+	be.Equal(t, diagMessages(pullDiagnostics(t, conn, uri)), []string{})
+}
@@ -57,8 +57,8 @@ type URI = string
 // where there is no pointer of type *K or *V on which to call
 // UnmarshalJSON. (See Go issue #28189 for more detail.)
 //
-// Non-empty DocumentUris are valid "file"-scheme URIs.
-// The empty DocumentUri is valid.
+// Non-empty DocumentUris are valid URIs, which are canonicalized if they're
+// "file"-scheme URIs. The empty DocumentUri is valid.
 func (uri *DocumentURI) UnmarshalText(data []byte) (err error) {
 	*uri, err = ParseDocumentURI(string(data))
 	return err
@@ -131,7 +131,12 @@ func ParseDocumentURI(s string) (DocumentURI, error) {
 	}
 
 	if !strings.HasPrefix(s, "file://") {
-		return "", fmt.Errorf("DocumentUri scheme is not 'file': %s", s)
+		// Documents that aren't files, such as notebook cells, are
+		// identified by their URIs as they are.
+		if _, err := url.ParseRequestURI(s); err != nil {
+			return "", fmt.Errorf("parsing URI %q: %w", s, err)
+		}
+		return DocumentURI(s), nil
 	}
 
 	// VS Code sends URLs with only two slashes,
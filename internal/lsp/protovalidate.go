@@ -0,0 +1,373 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter"
+)
+
+// profileProtovalidate is for the cel rules of protovalidate, in the
+// (buf.validate.message), (buf.validate.field), and predefined rule options
+// of .proto files.
+const profileProtovalidate = "protovalidate"
+
+// protovalidateConfig configures the type of this in the protovalidate
+// profile. Without a message, this is dynamically typed.
+type protovalidateConfig struct {
+	// Message is the fully qualified name of the message the rules apply to,
+	// from the config's proto_paths or descriptor_sets.
+	Message string `yaml:"message,omitempty"`
+	// Field is the name of a field of Message. If set, this is the field's
+	// value, as in field rules, rather than the message.
+	Field string `yaml:"field,omitempty"`
+}
+
+// protovalidateOptions returns the options declaring the variables and
+// functions protovalidate makes available to rules.
+func (c *config) protovalidateOptions() []cel.EnvOption {
+	p := c.Protovalidate
+	if p == nil {
+		p = &protovalidateConfig{}
+	}
+	return protovalidateRuleOptions(thisVariable(p.Message, p.Field, ""), cel.DynType)
+}
+
+// protovalidateRuleOptions returns the options for a rule whose this is
+// declared by the given option, and whose rule variable has the given type.
+func protovalidateRuleOptions(this cel.EnvOption, rule *cel.Type) []cel.EnvOption {
+	opts := []cel.EnvOption{
+		cel.CrossTypeNumericComparisons(true),
+		ext.Strings(ext.StringsValidateFormatCalls(true)),
+		this,
+		cel.VariableWithDoc("rules", cel.DynType, "The rules message of the field, such as a buf.validate.StringRules, in predefined rules."),
+		cel.VariableWithDoc("rule", rule, "The value of the predefined rule being evaluated."),
+		cel.Lib(nowLibrary{}),
+	}
+	return append(opts, protovalidateLibrary()...)
+}
+
+// nowLibrary declares the now variable, and binds it to the current time for
+// evaluation.
+type nowLibrary struct{}
+
+func (nowLibrary) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.VariableWithDoc("now", cel.TimestampType, "The current time, when the rule is evaluated."),
+	}
+}
+
+func (nowLibrary) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{cel.Globals(nowActivation{})}
+}
+
+// nowActivation resolves now to the time at which it's evaluated, rather than
+// when the program was created.
+type nowActivation struct{}
+
+func (nowActivation) ResolveName(name string) (any, bool) {
+	if name != "now" {
+		return nil, false
+	}
+	return types.Timestamp{Time: time.Now()}, true
+}
+
+func (nowActivation) Parent() interpreter.Activation { return nil }
+
+// thisDoc documents the this variable.
+const thisDoc = "The value the rule applies to: the message, for message rules, or the field's value, for field rules."
+
+// thisVariable returns an option declaring this as the given message, or the
+// value of its field. For list and map fields, elem selects the rules for
+// their "items", "keys" or "values" instead. Without a message, this is
+// dynamically typed. The message's type is only known once the
+// environment's protobuf types are registered.
+func thisVariable(message, field, elem string) cel.EnvOption {
+	return func(e *cel.Env) (*cel.Env, error) {
+		if message == "" {
+			return cel.VariableWithDoc("this", cel.DynType, thisDoc)(e)
+		}
+		if _, ok := e.CELTypeProvider().FindStructType(message); !ok {
+			return nil, fmt.Errorf("protovalidate: unknown message type %q", message)
+		}
+		if field == "" {
+			return cel.VariableWithDoc("this", cel.ObjectType(message), thisDoc)(e)
+		}
+		ft, ok := e.CELTypeProvider().FindStructFieldType(message, field)
+		if !ok {
+			return nil, fmt.Errorf("protovalidate: message %s has no field %q", message, field)
+		}
+		t := ft.Type
+		params := t.Parameters()
+		switch {
+		case elem == "":
+		case elem == "items" && t.Kind() == types.ListKind:
+			t = params[0]
+		case elem == "keys" && t.Kind() == types.MapKind:
+			t = params[0]
+		case elem == "values" && t.Kind() == types.MapKind:
+			t = params[1]
+		default:
+			t = cel.DynType
+		}
+		return cel.VariableWithDoc("this", t, thisDoc)(e)
+	}
+}
+
+// emailPattern is the definition of a valid email address from the HTML
+// standard, which protovalidate follows.
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// uriPattern matches the characters allowed in a URI by RFC 3986, with
+// percent-encodings.
+var uriPattern = regexp.MustCompile(`^(?:[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=]|%[0-9A-Fa-f]{2})*$`)
+
+// isHostname reports whether s is a valid hostname, optionally with a
+// trailing dot.
+func isHostname(s string) bool {
+	if len(s) > 253 {
+		return false
+	}
+	s = strings.TrimSuffix(s, ".")
+	labels := strings.Split(s, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	// The last label can't be all digits, or it'd be an IP address.
+	_, err := strconv.Atoi(labels[len(labels)-1])
+	return err != nil
+}
+
+// isIP reports whether s is an IP address of the given version, or of either
+// version if it's 0.
+func isIP(s string, version int64) bool {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return false
+	}
+	switch version {
+	case 0:
+		return true
+	case 4:
+		return addr.Is4()
+	case 6:
+		return addr.Is6()
+	}
+	return false
+}
+
+// isIPPrefix reports whether s is an IP prefix of the given version, or of
+// either version if it's 0. If strict is set, the bits of the address beyond
+// the prefix length must be zero.
+func isIPPrefix(s string, version int64, strict bool) bool {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil || (strict && prefix != prefix.Masked()) {
+		return false
+	}
+	return isIP(prefix.Addr().String(), version)
+}
+
+// isURI reports whether s is a URI, which is absolute unless ref is set.
+func isURI(s string, ref bool) bool {
+	if !uriPattern.MatchString(s) {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (ref || u.Scheme != "")
+}
+
+// isHostAndPort reports whether s is a hostname or IP address, with IPv6
+// addresses in square brackets, followed by a port if portRequired is set.
+func isHostAndPort(s string, portRequired bool) bool {
+	host, port := s, ""
+	if i := strings.LastIndexByte(s, ':'); i >= 0 && (s[0] != '[' || s[i-1] == ']') {
+		host, port = s[:i], s[i+1:]
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil || (len(port) > 1 && port[0] == '0') {
+			return false
+		}
+	} else if portRequired {
+		return false
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return isIP(host[1:len(host)-1], 6)
+	}
+	return isHostname(host) || isIP(host, 4)
+}
+
+func protovalidateLibrary() []cel.EnvOption {
+	stringPredicate := func(pred func(string) bool) cel.OverloadOpt {
+		return cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			return types.Bool(pred(string(arg.(types.String))))
+		})
+	}
+	isIPPrefixBinding := cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+		var version int64
+		var strict bool
+		for _, arg := range args[1:] {
+			switch arg := arg.(type) {
+			case types.Int:
+				version = int64(arg)
+			case types.Bool:
+				strict = bool(arg)
+			}
+		}
+		return types.Bool(isIPPrefix(string(args[0].(types.String)), version, strict))
+	})
+	bytesPredicate := func(pred func(b, sub []byte) bool) cel.OverloadOpt {
+		return cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+			return types.Bool(pred(lhs.(types.Bytes), rhs.(types.Bytes)))
+		})
+	}
+
+	var uniqueOverloads []cel.FunctionOpt
+	for _, t := range []*cel.Type{cel.BoolType, cel.IntType, cel.UintType, cel.DoubleType, cel.StringType, cel.BytesType} {
+		uniqueOverloads = append(uniqueOverloads,
+			cel.MemberOverload(fmt.Sprintf("list_%s_unique", t), []*cel.Type{cel.ListType(t)}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					seen := map[any]bool{}
+					for it := arg.(traits.Lister).Iterator(); it.HasNext() == types.True; {
+						key := it.Next().Value()
+						if b, ok := key.([]byte); ok {
+							key = string(b)
+						}
+						if seen[key] {
+							return types.False
+						}
+						seen[key] = true
+					}
+					return types.True
+				}),
+			),
+		)
+	}
+
+	return []cel.EnvOption{
+		cel.Function("isEmail",
+			cel.FunctionDocs("Returns true if the string is an email address, as defined by the HTML standard."),
+			cel.MemberOverload("string_is_email", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"foo@example.com".isEmail() == true`),
+				stringPredicate(emailPattern.MatchString)),
+		),
+		cel.Function("isHostname",
+			cel.FunctionDocs("Returns true if the string is a valid hostname, such as example.com."),
+			cel.MemberOverload("string_is_hostname", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"example.com".isHostname() == true`),
+				stringPredicate(isHostname)),
+		),
+		cel.Function("isIp",
+			cel.FunctionDocs("Returns true if the string is an IP address, optionally of the given version, 4 or 6."),
+			cel.MemberOverload("string_is_ip", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"127.0.0.1".isIp() == true`),
+				stringPredicate(func(s string) bool { return isIP(s, 0) })),
+			cel.MemberOverload("string_int_is_ip", []*cel.Type{cel.StringType, cel.IntType}, cel.BoolType,
+				cel.OverloadExamples(`"::1".isIp(4) == false`),
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(isIP(string(lhs.(types.String)), int64(rhs.(types.Int))))
+				}),
+			),
+		),
+		cel.Function("isIpPrefix",
+			cel.FunctionDocs("Returns true if the string is an IP prefix, such as 10.0.0.0/8, optionally of the given version, 4 or 6. If strict is true, the bits of the address beyond the prefix length must be zero."),
+			cel.MemberOverload("string_is_ip_prefix", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"10.0.0.0/8".isIpPrefix() == true`),
+				isIPPrefixBinding),
+			cel.MemberOverload("string_int_is_ip_prefix", []*cel.Type{cel.StringType, cel.IntType}, cel.BoolType,
+				isIPPrefixBinding),
+			cel.MemberOverload("string_bool_is_ip_prefix", []*cel.Type{cel.StringType, cel.BoolType}, cel.BoolType,
+				cel.OverloadExamples(`"10.1.0.0/8".isIpPrefix(true) == false`),
+				isIPPrefixBinding),
+			cel.MemberOverload("string_int_bool_is_ip_prefix", []*cel.Type{cel.StringType, cel.IntType, cel.BoolType}, cel.BoolType,
+				isIPPrefixBinding),
+		),
+		cel.Function("isUri",
+			cel.FunctionDocs("Returns true if the string is an absolute URI, as defined by RFC 3986."),
+			cel.MemberOverload("string_is_uri", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"https://example.com".isUri() == true`),
+				stringPredicate(func(s string) bool { return isURI(s, false) })),
+		),
+		cel.Function("isUriRef",
+			cel.FunctionDocs("Returns true if the string is a URI or a relative reference, as defined by RFC 3986."),
+			cel.MemberOverload("string_is_uri_ref", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.OverloadExamples(`"./foo/bar".isUriRef() == true`),
+				stringPredicate(func(s string) bool { return isURI(s, true) })),
+		),
+		cel.Function("isHostAndPort",
+			cel.FunctionDocs("Returns true if the string is a hostname or IP address, with IPv6 addresses in square brackets, followed by a port if portRequired is true."),
+			cel.MemberOverload("string_bool_is_host_and_port", []*cel.Type{cel.StringType, cel.BoolType}, cel.BoolType,
+				cel.OverloadExamples(`"example.com:443".isHostAndPort(true) == true`),
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return types.Bool(isHostAndPort(string(lhs.(types.String)), bool(rhs.(types.Bool))))
+				}),
+			),
+		),
+		cel.Function("unique",
+			append([]cel.FunctionOpt{cel.FunctionDocs("Returns true if all the elements of the list are distinct.")}, uniqueOverloads...)...,
+		),
+		cel.Function("isNan",
+			cel.FunctionDocs("Returns true if the double is NaN."),
+			cel.MemberOverload("double_is_nan", []*cel.Type{cel.DoubleType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.Bool(math.IsNaN(float64(arg.(types.Double))))
+				}),
+			),
+		),
+		cel.Function("isInf",
+			cel.FunctionDocs("Returns true if the double is infinite. A positive sign only matches positive infinity, and a negative sign only negative infinity."),
+			cel.MemberOverload("double_is_inf", []*cel.Type{cel.DoubleType}, cel.BoolType,
+				cel.UnaryBinding(func(arg ref.Val) ref.Val {
+					return types.Bool(math.IsInf(float64(arg.(types.Double)), 0))
+				}),
+			),
+			cel.MemberOverload("double_int_is_inf", []*cel.Type{cel.DoubleType, cel.IntType}, cel.BoolType,
+				cel.OverloadExamples(`(1.0 / 0.0).isInf(-1) == false`),
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					sign := int(max(min(int64(rhs.(types.Int)), 1), -1))
+					return types.Bool(math.IsInf(float64(lhs.(types.Double)), sign))
+				}),
+			),
+		),
+		cel.Function("startsWith",
+			cel.MemberOverload("bytes_starts_with_bytes", []*cel.Type{cel.BytesType, cel.BytesType}, cel.BoolType,
+				bytesPredicate(bytes.HasPrefix)),
+		),
+		cel.Function("endsWith",
+			cel.MemberOverload("bytes_ends_with_bytes", []*cel.Type{cel.BytesType, cel.BytesType}, cel.BoolType,
+				bytesPredicate(bytes.HasSuffix)),
+		),
+		cel.Function("contains",
+			cel.MemberOverload("bytes_contains_bytes", []*cel.Type{cel.BytesType, cel.BytesType}, cel.BoolType,
+				bytesPredicate(bytes.Contains)),
+		),
+		cel.Function("getField",
+			cel.FunctionDocs("Returns the value of the named field of a message, for fields whose names aren't valid CEL identifiers."),
+			cel.Overload("get_field_any_string", []*cel.Type{cel.DynType, cel.StringType}, cel.DynType,
+				cel.BinaryBinding(func(msg, name ref.Val) ref.Val {
+					indexer, ok := msg.(traits.Indexer)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(msg)
+					}
+					return indexer.Get(name)
+				}),
+			),
+		),
+	}
+}
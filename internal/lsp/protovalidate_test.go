@@ -0,0 +1,65 @@
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestProtovalidateDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"message rule", "testdata/protovalidate/rule.cel"},
+		{"field rule", "testdata/protovalidate_field/email.cel"},
+		{"functions", "testdata/protovalidate/functions.cel"},
+		{"proto importing buf/validate", "testdata/protovalidate_import/owner.cel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, uri := setupLSPServer(t, getAbsPath(t, tt.file))
+			diags := pullDiagnostics(t, conn, uri)
+			be.Equal(t, diagMessages(diags), []string{})
+		})
+	}
+}
+
+func TestProtovalidateUndefinedField(t *testing.T) {
+	t.Parallel()
+
+	conn, uri := setupLSPServer(t, getAbsPath(t, "testdata/protovalidate/bad_field.cel"))
+	diags := pullDiagnostics(t, conn, uri)
+	be.True(t, containsSubstring(diagMessages(diags), "undefined field 'emial'"))
+}
+
+func TestProtovalidateFunctions(t *testing.T) {
+	t.Parallel()
+
+	hints := getInlayHints(t, "testdata/protovalidate/functions.cel")
+	be.Equal(t, len(hints), 1)
+	be.Equal(t, hints[0].Label[0].Value, "→ true (bool)")
+
+	// now is bound to the current time.
+	hints = getInlayHints(t, "testdata/protovalidate/now.cel")
+	be.Equal(t, len(hints), 1)
+	be.Equal(t, hints[0].Label[0].Value, "→ true (bool)")
+
+	requireHoverContains(t, "testdata/protovalidate/functions.cel", 0, 20, "email address, as defined by the HTML standard", "isEmail docs")
+	requireHoverContains(t, "testdata/protovalidate/rule.cel", 0, 85, "The current time", "now variable")
+	requireHoverContains(t, "testdata/protovalidate_import/owner.cel", 0, 6, "The account owner's email address.", "field of a proto importing buf/validate")
+}
+
+func TestProtovalidateCompletion(t *testing.T) {
+	t.Parallel()
+
+	result := requestInvokedAtEnd(t, "testdata/protovalidate/this_dot.cel")
+	item := findCompletionItem(result.Items, "email")
+	be.True(t, item != nil)
+	be.True(t, containsLabel(result.Items, "created"))
+}
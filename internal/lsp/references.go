@@ -19,11 +19,11 @@ func (s *server) references(req *jsonrpc2.Request) (any, error) {
 	f := s.files[params.TextDocument.URI]
 	s.mu.Unlock()
 
-	if f == nil || f.content == "" {
+	if f == nil || f.content == "" || hostDocument(f.uri) {
 		return nil, nil
 	}
 
-	return computeReferences(f, s.celEnv, params)
+	return computeReferences(f, s.envFor(f.uri), params)
 }
 
 func computeReferences(f *file, celEnv *cel.Env, params protocol.ReferenceParams) ([]protocol.Location, error) {
@@ -53,7 +53,26 @@ func (s *server) rename(req *jsonrpc2.Request) (any, error) {
 		return nil, nil
 	}
 
-	return computeRename(f, s.celEnv, params)
+	if exprs, ok := s.embedded(f); ok {
+		if edit, ok, err := renameDefinition(f, exprs, params); ok {
+			return edit, err
+		}
+		e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+		if !ok {
+			return nil, nil
+		}
+		params.Position = pos
+		edit, err := computeRename(e.file, e.env, params)
+		if edit != nil {
+			for _, edits := range edit.Changes {
+				for i := range edits {
+					edits[i].Range = e.hostRange(f.content, edits[i].Range)
+				}
+			}
+		}
+		return edit, err
+	}
+	return computeRename(f, s.envFor(f.uri), params)
 }
 
 func (s *server) prepareRename(req *jsonrpc2.Request) (any, error) {
@@ -73,7 +92,25 @@ func (s *server) prepareRename(req *jsonrpc2.Request) (any, error) {
 		return nil, nil
 	}
 
-	return computePrepareRename(f, s.celEnv, params.Position)
+	if exprs, ok := s.embedded(f); ok {
+		if defs := parseDefinitions(f.content); len(defs) == len(exprs) {
+			if i, ok := definitionNameAt(defs, f.content, params.Position); ok {
+				r := defs[i].nameRange(f.content)
+				return &r, nil
+			}
+		}
+		e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+		if !ok {
+			return nil, nil
+		}
+		result, err := computePrepareRename(e.file, e.env, pos)
+		if r, ok := result.(*protocol.Range); ok && r != nil {
+			hostRange := e.hostRange(f.content, *r)
+			return &hostRange, err
+		}
+		return result, err
+	}
+	return computePrepareRename(f, s.envFor(f.uri), params.Position)
 }
 
 func computeRename(f *file, celEnv *cel.Env, params protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
@@ -0,0 +1,371 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/debug"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// inputName names the input of a Session in reports.
+const inputName = "<input>"
+
+// sessionCommands are the commands a Session runs, besides evaluating
+// expressions.
+var sessionCommands = []string{"%declare", "%let", "%load", "%parse", "%type"}
+
+// letRe matches the start of a %let command, up to its expression.
+var letRe = regexp.MustCompile(`^\s*%let\s+([_a-zA-Z][_a-zA-Z0-9]*)\s*=`)
+
+// A Session evaluates the input of an interactive session: expressions, and
+// commands that bind and declare variables, load config files, and show the
+// types and syntax trees of expressions.
+type Session struct {
+	s *server
+	// base is the environment of the config file, before the session's
+	// declarations.
+	base *cel.Env
+	env  *cel.Env
+	// decls are the variables the session declares, in the order they were
+	// first declared.
+	decls []*sessionVar
+	vars  map[string]any
+}
+
+// sessionVar is a variable declared by a Session.
+type sessionVar struct {
+	name string
+	typ  *cel.Type
+}
+
+// NewSession returns a session in the environment of the config file that
+// applies to the current directory, if any.
+func NewSession(opts ...Option) (*Session, error) {
+	s, err := newServer(opts...)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(inputName)
+	if err != nil {
+		return nil, err
+	}
+	env := s.documentEnv(path, "")
+	return &Session{s: s, base: env, env: env, vars: make(map[string]any)}, nil
+}
+
+// Exec runs input, which is an expression or a command, returning what it
+// outputs. Problems with expressions are returned as reports, at their
+// positions in input, and other problems as errors.
+//
+//	%let name = expr     binds name to the value of expr
+//	%declare name type   declares name, without a value
+//	%load path           loads the config file at path, or in the directory
+//	%type expr           shows the type of expr
+//	%parse expr          shows the syntax tree of expr
+func (ss *Session) Exec(ctx context.Context, input string) (string, []Report, error) {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return "", nil, nil
+	}
+	if !strings.HasPrefix(trimmed, "%") {
+		result, reports := ss.eval(ctx, input, 0)
+		if result == nil {
+			return "", reports, ctx.Err()
+		}
+		return result.String(), nil, nil
+	}
+
+	cmd, rest, _ := strings.Cut(trimmed, " ")
+	rest = strings.TrimSpace(rest)
+	switch cmd {
+	case "%let":
+		m := letRe.FindStringSubmatchIndex(input)
+		if m == nil {
+			return "", nil, errors.New("usage: %let name = expr")
+		}
+		name := input[m[2]:m[3]]
+		result, reports := ss.eval(ctx, input, m[1])
+		if result == nil {
+			return "", reports, ctx.Err()
+		}
+		if err := ss.declare(name, result.Type); err != nil {
+			return "", nil, err
+		}
+		ss.vars[name] = result.Value
+		return fmt.Sprintf("%s = %s", name, result), nil, nil
+
+	case "%declare":
+		name, typeName, ok := strings.Cut(rest, " ")
+		if !ok || !isValidIdentifier(name) {
+			return "", nil, errors.New("usage: %declare name type")
+		}
+		t, err := parseCELType(strings.TrimSpace(typeName), ss.env)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := ss.declare(name, t); err != nil {
+			return "", nil, err
+		}
+		delete(ss.vars, name)
+		return "", nil, nil
+
+	case "%load":
+		if rest == "" {
+			return "", nil, errors.New("usage: %load path")
+		}
+		return "", nil, ss.load(rest)
+
+	case "%type":
+		start := commandEnd(input, cmd)
+		masked := mask(input, start)
+		if diagnostics := computeDiagnostics(masked, ss.env); len(diagnostics) > 0 {
+			return "", []Report{{Path: inputName, Content: input, Diagnostics: diagnostics}}, nil
+		}
+		checked, iss := ss.env.Compile(masked)
+		if iss.Err() != nil {
+			return "", nil, iss.Err()
+		}
+		return checked.OutputType().String(), nil, nil
+
+	case "%parse":
+		start := commandEnd(input, cmd)
+		masked := mask(input, start)
+		parsed, iss := ss.env.Parse(masked)
+		if iss.Err() != nil {
+			return "", []Report{{Path: inputName, Content: input, Diagnostics: issuesToDiagnostics(masked, iss, protocol.SeverityError)}}, nil
+		}
+		return debug.ToDebugString(parsed.NativeRep().Expr()), nil, nil
+	}
+	return "", nil, fmt.Errorf("unknown command %s, expected one of %s", cmd, strings.Join(sessionCommands, ", "))
+}
+
+// eval evaluates the expression that starts at offset start of input, with
+// the values the session binds.
+func (ss *Session) eval(ctx context.Context, input string, start int) (*Result, []Report) {
+	masked := mask(input, start)
+	if diagnostics := computeDiagnostics(masked, ss.env); len(diagnostics) > 0 {
+		return nil, []Report{{Path: inputName, Content: input, Diagnostics: diagnostics}}
+	}
+	checked, iss := ss.env.Compile(masked)
+	if iss.Err() != nil {
+		return nil, []Report{{Path: inputName, Content: input, Diagnostics: issuesToDiagnostics(masked, iss, protocol.SeverityError)}}
+	}
+	prog, err := ss.env.Program(checked, cel.InterruptCheckFrequency(interruptCheckFrequency))
+	if err != nil {
+		return nil, []Report{{Path: inputName, Content: input, Diagnostics: []protocol.Diagnostic{evalDiagnostic(masked, checked, err)}}}
+	}
+	val, _, err := prog.ContextEval(ctx, ss.vars)
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []Report{{Path: inputName, Content: input, Diagnostics: []protocol.Diagnostic{evalDiagnostic(masked, checked, err)}}}
+	}
+	return &Result{Value: val, Type: checked.OutputType()}, nil
+}
+
+// declare declares the variable name with type t, replacing any declaration
+// the session made before.
+func (ss *Session) declare(name string, t *cel.Type) error {
+	decls := slices.Clone(ss.decls)
+	if i := slices.IndexFunc(decls, func(v *sessionVar) bool { return v.name == name }); i >= 0 {
+		decls[i] = &sessionVar{name: name, typ: t}
+	} else {
+		decls = append(decls, &sessionVar{name: name, typ: t})
+	}
+	env, err := extendSession(ss.base, decls)
+	if err != nil {
+		return err
+	}
+	ss.env, ss.decls = env, decls
+	return nil
+}
+
+// load replaces the session's environment with that of the config file at
+// path, or in the directory at path, keeping the session's declarations.
+func (ss *Session) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, configFileName)
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	entry := ss.s.loadEnv(envKey{configPath: path})
+	if len(entry.errs) > 0 {
+		var msgs []string
+		for _, e := range entry.errs {
+			if e.line >= 0 {
+				msgs = append(msgs, fmt.Sprintf("%s:%d: %s", e.path, e.line+1, e.msg))
+			} else {
+				msgs = append(msgs, fmt.Sprintf("%s: %s", e.path, e.msg))
+			}
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	env, err := extendSession(entry.env, ss.decls)
+	if err != nil {
+		return err
+	}
+	ss.base, ss.env = entry.env, env
+	return nil
+}
+
+// extendSession returns base extended with decls.
+func extendSession(base *cel.Env, decls []*sessionVar) (*cel.Env, error) {
+	opts := make([]cel.EnvOption, 0, len(decls))
+	for _, v := range decls {
+		opts = append(opts, cel.Variable(v.name, v.typ))
+	}
+	return base.Extend(opts...)
+}
+
+// Complete returns the completions of the word that ends at offset pos of
+// line, and the offset it starts at. Commands are completed at the start of
+// the line, and in expressions, the members of the value before a dot, and
+// otherwise variables, functions, macros and keywords, as the server
+// completes them.
+func (ss *Session) Complete(line string, pos int) (int, []string) {
+	start := pos
+	for start > 0 && isIdentifierChar(rune(line[start-1])) {
+		start--
+	}
+	prefix := line[start:pos]
+
+	if start > 0 && line[start-1] == '%' && strings.TrimSpace(line[:start-1]) == "" {
+		var candidates []string
+		for _, cmd := range sessionCommands {
+			if strings.HasPrefix(cmd, "%"+prefix) {
+				candidates = append(candidates, cmd)
+			}
+		}
+		return start - 1, candidates
+	}
+
+	exprStart := 0
+	if cmd, _, _ := strings.Cut(strings.TrimSpace(line), " "); strings.HasPrefix(cmd, "%") {
+		switch cmd {
+		case "%let":
+			m := letRe.FindStringIndex(line)
+			if m == nil || m[1] > start {
+				return start, nil
+			}
+			exprStart = m[1]
+		case "%type", "%parse":
+			exprStart = commandEnd(line, cmd)
+		default:
+			return start, nil
+		}
+	}
+	content := mask(line[:start], exprStart)
+
+	var items []protocol.CompletionItem
+	if start > 0 && line[start-1] == '.' {
+		_, col := byteOffsetToLineCol(content, len(content))
+		at := protocol.Position{Character: col}
+		items = namespaceCompletionItems(ss.env, namespaceAtDot(content, at))
+		if len(items) == 0 {
+			receiverType := receiverTypeAtDot(content, at, ss.env)
+			items = fieldCompletionItems(ss.env, receiverType)
+			items = append(items, memberCompletionItems(ss.env, receiverType)...)
+		}
+	} else {
+		items = variableCompletionItems(ss.env, nil)
+		items = append(items, globalCompletionItems(ss.env, nil)...)
+		items = append(items, macroCompletionItems(ss.env, nil)...)
+		items = append(items, keywordCompletionItems(ss.env, nil)...)
+	}
+
+	var candidates []string
+	for _, item := range items {
+		if strings.HasPrefix(item.Label, prefix) {
+			candidates = append(candidates, item.Label)
+		}
+	}
+	slices.Sort(candidates)
+	return start, slices.Compact(candidates)
+}
+
+// Incomplete reports whether input needs more lines: whether it has brackets
+// that aren't closed, or a triple-quoted string that isn't.
+func Incomplete(input string) bool {
+	depth := 0
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '/':
+			if strings.HasPrefix(input[i:], "//") {
+				end := strings.IndexByte(input[i:], '\n')
+				if end < 0 {
+					return depth > 0
+				}
+				i += end
+			}
+		case '"', '\'':
+			raw := i > 0 && (input[i-1] == 'r' || input[i-1] == 'R') && (i < 2 || !isIdentifierChar(rune(input[i-2])))
+			quote := string(c)
+			if strings.HasPrefix(input[i:], strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			end, closed := closingQuote(input[i+len(quote):], quote, raw)
+			if !closed && len(quote) == 3 {
+				return true
+			}
+			i += len(quote) + end + len(quote) - 1
+		}
+	}
+	return depth > 0
+}
+
+// closingQuote returns the offset in s of the quote that closes a string,
+// skipping escapes unless it's raw, and whether there is one. Strings that
+// aren't triple-quoted end at a newline, whose offset is returned if they
+// aren't closed before it.
+func closingQuote(s, quote string, raw bool) (int, bool) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && !raw:
+			i++
+		case s[i] == '\n' && len(quote) == 1:
+			return i - len(quote), false
+		case strings.HasPrefix(s[i:], quote):
+			return i, true
+		}
+	}
+	return len(s), false
+}
+
+// commandEnd returns the offset in input of the end of the command cmd that
+// starts it.
+func commandEnd(input, cmd string) int {
+	return strings.Index(input, cmd) + len(cmd)
+}
+
+// mask returns input with what comes before offset start, such as a
+// command, blanked out, so that the expression after it keeps its position.
+func mask(input string, start int) string {
+	var b strings.Builder
+	for _, c := range []byte(input[:start]) {
+		if c == '\n' {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(input[start:])
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package lsp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	ss, err := lsp.NewSession()
+	be.Err(t, err, nil)
+
+	for _, tc := range []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2", "3 (int)"},
+		{"%let x = [1, 2, 3]", "x = [1, 2, 3] (list(int))"},
+		// Bindings persist, and can be rebound in terms of themselves.
+		{"%let x = x.map(n, n * 2)", "x = [2, 4, 6] (list(int))"},
+		{"x.size()", "3 (int)"},
+		{"%declare limit uint", ""},
+		{"%type limit < 10u && x.size() > 0", "bool"},
+		{"%type {'a': x}", "map(string, list(int))"},
+		{"%parse a +\n  b", "_+_(\n  a,\n  b\n)"},
+		{"   ", ""},
+	} {
+		out, reports, err := ss.Exec(t.Context(), tc.input)
+		be.Err(t, err, nil)
+		be.Equal(t, len(reports), 0)
+		be.Equal(t, out, tc.want)
+	}
+}
+
+func TestSessionReports(t *testing.T) {
+	t.Parallel()
+
+	ss, err := lsp.NewSession()
+	be.Err(t, err, nil)
+
+	// Problems are reported at their positions in the input, after the
+	// command.
+	_, reports, err := ss.Exec(t.Context(), "%let y = missing + 1")
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+	be.Equal(t, reports[0].Path, "<input>")
+	be.Equal(t, reports[0].Content, "%let y = missing + 1")
+	be.Equal(t, reports[0].Diagnostics[0].Range.Start.Character, uint32(9))
+
+	_, reports, err = ss.Exec(t.Context(), "%declare n int")
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+	// Declared variables have no value.
+	_, reports, err = ss.Exec(t.Context(), "n + 1")
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+
+	_, reports, err = ss.Exec(t.Context(), "%parse 1 +")
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 1)
+	be.True(t, strings.Contains(reports[0].Diagnostics[0].Message, "Syntax error"))
+
+	for _, input := range []string{"%nope", "%let = 1", "%declare n", "%declare n nope", "%load testdata/missing"} {
+		_, _, err = ss.Exec(t.Context(), input)
+		be.True(t, err != nil)
+	}
+}
+
+func TestSessionLoad(t *testing.T) {
+	t.Parallel()
+
+	ss, err := lsp.NewSession()
+	be.Err(t, err, nil)
+	_, _, err = ss.Exec(t.Context(), "%let n = 2")
+	be.Err(t, err, nil)
+
+	// Loading a config file keeps the session's bindings.
+	_, _, err = ss.Exec(t.Context(), "%load testdata/config")
+	be.Err(t, err, nil)
+	out, reports, err := ss.Exec(t.Context(), "%type limit + n")
+	be.Err(t, err, nil)
+	be.Equal(t, len(reports), 0)
+	be.Equal(t, out, "int")
+	out, _, err = ss.Exec(t.Context(), "n")
+	be.Err(t, err, nil)
+	be.Equal(t, out, "2 (int)")
+
+	_, _, err = ss.Exec(t.Context(), "%load testdata/config_invalid/.cells.yaml")
+	be.True(t, err != nil)
+}
+
+func TestSessionComplete(t *testing.T) {
+	t.Parallel()
+
+	ss, err := lsp.NewSession()
+	be.Err(t, err, nil)
+	_, _, err = ss.Exec(t.Context(), "%let items = ['a', 'b']")
+	be.Err(t, err, nil)
+
+	for _, tc := range []struct {
+		line  string
+		start int
+		want  []string
+	}{
+		{"%l", 0, []string{"%let", "%load"}},
+		{"ite", 0, []string{"items"}},
+		{"1 + dur", 4, []string{"duration"}},
+		{"%type items[0].starts", 15, []string{"startsWith"}},
+		{"%let n = items.si", 15, []string{"size"}},
+		{"%let it", 5, nil},
+		{"%load dur", 6, nil},
+	} {
+		start, got := ss.Complete(tc.line, len(tc.line))
+		be.Equal(t, start, tc.start)
+		be.Equal(t, got, tc.want)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		input string
+		want  bool
+	}{
+		{"1 + 2", false},
+		{"[1,\n2", true},
+		{"{'a': [1, 2]}", false},
+		{"f(')'", true},
+		{"'(' + \"[\"", false},
+		{`'\')' + (`, true},
+		{`r'\' + (`, true},
+		{"'''\n(", true},
+		{"'''(''' + 1", false},
+		{"// (\n1", false},
+		{"1)", false},
+		{"'unclosed\n", false},
+	} {
+		be.Equal(t, lsp.Incomplete(tc.input), tc.want)
+	}
+}
@@ -80,6 +80,12 @@ func computeSemanticTokens(f *file, celEnv *cel.Env) (*protocol.SemanticTokens,
 	if f == nil || f.content == "" {
 		return nil, nil
 	}
+	return encodeTokens(collectSemanticTokens(f, celEnv)), nil
+}
+
+// collectSemanticTokens returns the semantic tokens of the CEL file f, in no
+// particular order.
+func collectSemanticTokens(f *file, celEnv *cel.Env) []tokenInfo {
 
 	var tokens []tokenInfo
 
@@ -129,7 +135,7 @@ func computeSemanticTokens(f *file, celEnv *cel.Env) (*protocol.SemanticTokens,
 	// Parse the CEL expression
 	parsed, issues := celEnv.Parse(f.content)
 	if issues.Err() != nil {
-		return nil, nil
+		return nil
 	}
 
 	nativeAST := parsed.NativeRep()
@@ -141,6 +147,12 @@ func computeSemanticTokens(f *file, celEnv *cel.Env) (*protocol.SemanticTokens,
 	// Process macro calls
 	collectMacroTokens(sourceInfo, f.content, collectToken)
 
+	return tokens
+}
+
+// encodeTokens sorts tokens by position and delta-encodes them, returning
+// nil if there are none.
+func encodeTokens(tokens []tokenInfo) *protocol.SemanticTokens {
 	// Sort tokens by position
 	slices.SortFunc(tokens, func(a, b tokenInfo) int {
 		if a.line != b.line {
@@ -164,9 +176,9 @@ func computeSemanticTokens(f *file, celEnv *cel.Env) (*protocol.SemanticTokens,
 		prevCol = tok.col
 	}
 	if len(encoded) == 0 {
-		return nil, nil
+		return nil
 	}
-	return &protocol.SemanticTokens{Data: encoded}, nil
+	return &protocol.SemanticTokens{Data: encoded}
 }
 
 // walkCELExpr recursively walks a CEL expression AST and collects semantic tokens.
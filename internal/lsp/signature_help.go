@@ -25,7 +25,14 @@ func (s *server) signatureHelp(req *jsonrpc2.Request) (any, error) {
 		return nil, nil
 	}
 
-	return computeSignatureHelp(f, s.celEnv, params.Position)
+	if exprs, ok := s.embedded(f); ok {
+		e, pos, ok := embeddedAt(exprs, f.content, params.Position)
+		if !ok {
+			return nil, nil
+		}
+		return computeSignatureHelp(e.file, e.env, pos)
+	}
+	return computeSignatureHelp(f, s.envFor(f.uri), params.Position)
 }
 
 func computeSignatureHelp(f *file, celEnv *cel.Env, pos protocol.Position) (*protocol.SignatureHelp, error) {
@@ -50,6 +57,11 @@ func computeSignatureHelp(f *file, celEnv *cel.Env, pos protocol.Position) (*pro
 	}
 
 	funcName := call.FunctionName()
+	isMember := call.IsMemberFunction()
+	if qualified, ok := qualifiedFunctionName(call, celEnv); ok {
+		// A namespaced global function, e.g. math.abs(x).
+		funcName, isMember = qualified, false
+	}
 
 	// Look up the function in the CEL environment.
 	funcs := celEnv.Functions()
@@ -60,7 +72,7 @@ func computeSignatureHelp(f *file, celEnv *cel.Env, pos protocol.Position) (*pro
 	}
 
 	// Generate signatures from the function declaration, filtered by call type.
-	sigs := generateSignatures(funcDecl, call.IsMemberFunction())
+	sigs := generateSignatures(funcDecl, isMember)
 	if len(sigs) == 0 {
 		return nil, nil
 	}
@@ -216,6 +228,13 @@ func generateSignatures(funcDecl any, isMemberFunction bool) []protocol.Signatur
 		doc = documenter.Documentation()
 	}
 
+	// Signatures of namespaced global functions, such as math.abs, start
+	// with their qualified name.
+	var name string
+	if named, ok := funcDecl.(interface{ Name() string }); ok {
+		name = named.Name()
+	}
+
 	if doc == nil {
 		// Fallback for functions without documentation.
 		return []protocol.SignatureInformation{{
@@ -226,7 +245,7 @@ func generateSignatures(funcDecl any, isMemberFunction bool) []protocol.Signatur
 	// If the main doc has a signature, use it as the primary signature.
 	// Check if it matches the expected call type.
 	if doc.Signature != "" {
-		if isSignatureMatchingCallType(doc.Signature, isMemberFunction) {
+		if isSignatureMatchingCallType(doc.Signature, name, isMemberFunction) {
 			sig := protocol.SignatureInformation{
 				Label:      doc.Signature,
 				Parameters: extractParametersFromSignature(doc.Signature, doc),
@@ -243,7 +262,7 @@ func generateSignatures(funcDecl any, isMemberFunction bool) []protocol.Signatur
 	// Use child signatures as overloads, filtering by call type.
 	var sigs []protocol.SignatureInformation
 	for _, child := range doc.Children {
-		if child.Signature != "" && isSignatureMatchingCallType(child.Signature, isMemberFunction) {
+		if child.Signature != "" && isSignatureMatchingCallType(child.Signature, name, isMemberFunction) {
 			sig := protocol.SignatureInformation{
 				Label:      child.Signature,
 				Parameters: extractParametersFromSignature(child.Signature, child),
@@ -269,8 +288,10 @@ func generateSignatures(funcDecl any, isMemberFunction bool) []protocol.Signatur
 
 // isSignatureMatchingCallType checks if a signature matches the call type.
 // Member function signatures typically have a receiver (e.g., "string.matches(string) -> bool").
-// Global function signatures don't (e.g., "matches(string, string) -> bool").
-func isSignatureMatchingCallType(signature string, isMemberFunction bool) bool {
+// Global function signatures don't (e.g., "matches(string, string) -> bool"),
+// though the name of the function itself, given by name, may be qualified
+// (e.g., "math.abs(int) -> int").
+func isSignatureMatchingCallType(signature, name string, isMemberFunction bool) bool {
 	// A simple heuristic: member functions have a dot before the opening paren.
 	// E.g., "string.matches(string) -> bool" contains a dot.
 	before, _, ok := strings.Cut(signature, "(")
@@ -279,7 +300,7 @@ func isSignatureMatchingCallType(signature string, isMemberFunction bool) bool {
 	}
 
 	beforeParen := before
-	hasDot := strings.Contains(beforeParen, ".")
+	hasDot := beforeParen != name && strings.Contains(beforeParen, ".")
 
 	// If it's a member call, we want signatures with dots.
 	// If it's a global call, we want signatures without dots.
@@ -0,0 +1,301 @@
+package lsp
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/interpreter"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// maxSubexpressionHintLength is the number of characters of a subexpression's
+// value shown in its hint; longer values are truncated, and shown in full
+// when the hint is resolved.
+const maxSubexpressionHintLength = 30
+
+// inlayHintData is the data of a hint whose value is truncated, from which
+// the hint is resolved.
+type inlayHintData struct {
+	Value string `json:"value"`
+}
+
+// subexpressionHints returns a hint after each interesting subexpression of
+// checked, a parsed and checked content, with its value from state: each
+// operand of && and ||, the condition of a ?:, and the result of each call
+// and comprehension. Subexpressions inside comprehensions only hold their
+// values in the last iteration, so they have no hints, and neither do those
+// that weren't evaluated, or that end where the expression does.
+func subexpressionHints(content string, checked *cel.Ast, state interpreter.EvalState) []protocol.InlayHint {
+	native := checked.NativeRep()
+	spans := &exprSpans{src: content, info: native.SourceInfo()}
+	root := native.Expr()
+	contentEnd := len(strings.TrimRight(content, " \t\r\n"))
+
+	type target struct {
+		value      string
+		start, end int
+	}
+	var targets []target
+	for _, e := range interestingSubexpressions(root) {
+		val, ok := state.Value(e.ID())
+		if !ok || val == nil || types.IsUnknown(val) {
+			continue
+		}
+		start, end := spans.grouped(spans.span(e))
+		if end <= 0 || end >= contentEnd {
+			continue
+		}
+		value := resultToString(val)
+		if types.IsError(val) {
+			value = fmt.Sprintf("error: %v", val)
+		}
+		targets = append(targets, target{value: value, start: start, end: end})
+	}
+	// Of the subexpressions that end at the same offset, only the outermost
+	// has a hint.
+	slices.SortStableFunc(targets, func(a, b target) int {
+		return cmp.Or(cmp.Compare(a.end, b.end), cmp.Compare(a.start, b.start))
+	})
+	targets = slices.CompactFunc(targets, func(a, b target) bool { return a.end == b.end })
+
+	hints := make([]protocol.InlayHint, 0, len(targets))
+	for _, t := range targets {
+		line, col := byteOffsetToLineCol(content, t.end)
+		hint := protocol.InlayHint{
+			Position:    protocol.Position{Line: line, Character: col},
+			Label:       []protocol.InlayHintLabelPart{{Value: "→ " + truncateValue(t.value)}},
+			Kind:        protocol.InlayHintKind(1),
+			PaddingLeft: true,
+		}
+		if utf8.RuneCountInString(t.value) > maxSubexpressionHintLength {
+			hint.Data = inlayHintData{Value: t.value}
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
+
+// truncateValue returns value, shortened with an ellipsis if it's longer than
+// maxSubexpressionHintLength.
+func truncateValue(value string) string {
+	if utf8.RuneCountInString(value) <= maxSubexpressionHintLength {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:maxSubexpressionHintLength-1]) + "…"
+}
+
+// interestingSubexpressions returns the subexpressions of root that have
+// hints, other than root itself.
+func interestingSubexpressions(root celast.Expr) []celast.Expr {
+	var exprs []celast.Expr
+	seen := make(map[int64]bool)
+	add := func(e celast.Expr) {
+		if e.ID() != root.ID() && !seen[e.ID()] {
+			seen[e.ID()] = true
+			exprs = append(exprs, e)
+		}
+	}
+	var visit func(e celast.Expr)
+	visit = func(e celast.Expr) {
+		switch e.Kind() {
+		case celast.CallKind:
+			call := e.AsCall()
+			fn := call.FunctionName()
+			switch {
+			case fn == operators.LogicalAnd || fn == operators.LogicalOr:
+				for _, arg := range call.Args() {
+					// The operands of a chain such as a && b && c are
+					// those of its innermost operators.
+					if arg.Kind() != celast.CallKind || arg.AsCall().FunctionName() != fn {
+						add(arg)
+					}
+				}
+			case fn == operators.Conditional:
+				add(call.Args()[0])
+			case !isOperatorFunction(fn):
+				add(e)
+			}
+			if call.IsMemberFunction() {
+				visit(call.Target())
+			}
+			for _, arg := range call.Args() {
+				visit(arg)
+			}
+		case celast.ComprehensionKind:
+			add(e)
+			visit(e.AsComprehension().IterRange())
+		case celast.ListKind:
+			for _, elem := range e.AsList().Elements() {
+				visit(elem)
+			}
+		case celast.MapKind:
+			for _, entry := range e.AsMap().Entries() {
+				visit(entry.AsMapEntry().Key())
+				visit(entry.AsMapEntry().Value())
+			}
+		case celast.StructKind:
+			for _, field := range e.AsStruct().Fields() {
+				visit(field.AsStructField().Value())
+			}
+		case celast.SelectKind:
+			visit(e.AsSelect().Operand())
+		}
+	}
+	visit(root)
+	return exprs
+}
+
+// isOperatorFunction reports whether fn is the function of an operator, such
+// as _+_ or _[_], rather than one that's called by name.
+func isOperatorFunction(fn string) bool {
+	_, ok := operators.FindReverse(fn)
+	return ok || fn == operators.Conditional
+}
+
+// exprSpans finds the spans of expressions in their source. CEL only records
+// the offsets of each node's own token, such as an operator or the opening
+// parenthesis of a call, so a node's span is that of its descendants along
+// with any brackets that close it.
+type exprSpans struct {
+	src  string
+	info *celast.SourceInfo
+}
+
+// span returns the byte offsets of the start and end of e in the source.
+func (s *exprSpans) span(e celast.Expr) (start, end int) {
+	start, end = len(s.src), 0
+	if r, ok := s.info.GetOffsetRange(e.ID()); ok && r.Stop > r.Start {
+		start, end = celRuneOffsetToByteOffset(s.src, r.Start), celRuneOffsetToByteOffset(s.src, r.Stop)
+	}
+	extend := func(child celast.Expr) {
+		childStart, childEnd := s.span(child)
+		start, end = min(start, childStart), max(end, childEnd)
+	}
+
+	switch e.Kind() {
+	case celast.CallKind:
+		call := e.AsCall()
+		if call.IsMemberFunction() {
+			extend(call.Target())
+		}
+		for _, arg := range call.Args() {
+			extend(arg)
+		}
+		switch fn := call.FunctionName(); {
+		case fn == operators.Index || fn == operators.OptIndex:
+			end = s.past(end, ']')
+		case !isOperatorFunction(fn):
+			if !call.IsMemberFunction() {
+				start = s.calleeStart(start)
+			}
+			if len(call.Args()) == 0 {
+				end = s.past(end, '(')
+			}
+			end = s.past(end, ')')
+		}
+	case celast.ComprehensionKind:
+		comp := e.AsComprehension()
+		for _, child := range []celast.Expr{comp.IterRange(), comp.AccuInit(), comp.LoopCondition(), comp.LoopStep(), comp.Result()} {
+			extend(child)
+		}
+		end = s.past(end, ')')
+	case celast.ListKind:
+		for _, elem := range e.AsList().Elements() {
+			extend(elem)
+		}
+		end = s.past(end, ']')
+	case celast.MapKind:
+		for _, entry := range e.AsMap().Entries() {
+			extend(entry.AsMapEntry().Key())
+			extend(entry.AsMapEntry().Value())
+		}
+		end = s.past(end, '}')
+	case celast.StructKind:
+		for _, field := range e.AsStruct().Fields() {
+			extend(field.AsStructField().Value())
+		}
+		end = s.past(end, '}')
+	case celast.SelectKind:
+		sel := e.AsSelect()
+		extend(sel.Operand())
+		// The node's own token is the dot, which the field name follows.
+		i := s.skip(end, " \t\r\n?")
+		if strings.HasPrefix(s.src[i:], sel.FieldName()) {
+			end = i + len(sel.FieldName())
+		}
+		if sel.IsTestOnly() {
+			// has(a.b)
+			if open := s.skipBack(start, " \t\r\n"); open > 0 && s.src[open-1] == '(' {
+				start = s.calleeStart(open - 1)
+			}
+			end = s.past(end, ')')
+		}
+	}
+	return start, end
+}
+
+// grouped returns the span from start to end, extended over any parentheses
+// that group it.
+func (s *exprSpans) grouped(start, end int) (int, int) {
+	for {
+		open := s.skipBack(start, " \t\r\n")
+		closing := s.skip(end, " \t\r\n")
+		if open == 0 || s.src[open-1] != '(' || closing >= len(s.src) || s.src[closing] != ')' {
+			return start, end
+		}
+		// A parenthesis after a name opens the arguments of a call.
+		if r, _ := utf8.DecodeLastRuneInString(s.src[:open-1]); open > 1 && isIdentifierChar(r) {
+			return start, end
+		}
+		start, end = open-1, closing+1
+	}
+}
+
+// past returns the offset past the bracket that follows end, skipping
+// whitespace and a trailing comma, or end if another character follows it.
+func (s *exprSpans) past(end int, bracket byte) int {
+	if i := s.skip(end, " \t\r\n,"); i < len(s.src) && s.src[i] == bracket {
+		return i + 1
+	}
+	return end
+}
+
+// calleeStart returns the offset of the name of the function called with
+// the parenthesis at open, or open if there's none.
+func (s *exprSpans) calleeStart(open int) int {
+	i := open
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(s.src[:i])
+		if !isIdentifierChar(r) && r != '.' {
+			break
+		}
+		i -= size
+	}
+	return i
+}
+
+// skip returns the offset of the first character at or after i that isn't
+// one of chars.
+func (s *exprSpans) skip(i int, chars string) int {
+	for i < len(s.src) && strings.IndexByte(chars, s.src[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// skipBack returns the offset after the last character before i that isn't
+// one of chars.
+func (s *exprSpans) skipBack(i int, chars string) int {
+	for i > 0 && strings.IndexByte(chars, s.src[i-1]) >= 0 {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,19 @@
+package rules
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/google/cel-go/cel"
+)
+
+func parse(env *cel.Env) error {
+	if _, err := regexp.Compile("^[a-z]+$"); err != nil {
+		return err
+	}
+	if _, err := url.Parse("https://example.com/a b"); err != nil {
+		return err
+	}
+	_, iss := env.Parse("request.name")
+	return iss.Err()
+}
@@ -0,0 +1,26 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// cel
+const adminRule = `request.user == "admin" &&
+	request.path.startsWith('/admin')`
+
+var sizeRule = /* cel */ "size(request) < 10"
+
+var nameRule = "request.name"
+
+func compile(env *cel.Env) error {
+	if _, iss := env.Compile("request.path.startsWith(\"/ét\xc3\xa9\") && size(request) > 'big'"); iss.Err() != nil {
+		return iss.Err()
+	}
+	if _, iss := env.Parse(`[1, 2, 3].map(x, x * 2)`); iss.Err() != nil {
+		return iss.Err()
+	}
+	fmt.Println("request.user")
+	return nil
+}
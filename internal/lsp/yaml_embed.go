@@ -0,0 +1,365 @@
+package lsp
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// yamlExpressions returns the CEL expressions embedded in the YAML or JSON
+// document at path: those of the Kubernetes manifests and cel-go policies it
+// contains, and those selected by the config file that applies to it.
+func (s *server) yamlExpressions(path, content string, read func(string) ([]byte, error)) []*embeddedExpr {
+	selectors := s.configSelectors(path, read)
+	var exprs []*embeddedExpr
+	// starts records the offset of each expression, so that one that's
+	// selected more than once is only analyzed once.
+	starts := make(map[int]bool)
+	for _, doc := range yamlDocuments(content) {
+		var found []*embeddedExpr
+		if isPolicy(doc) {
+			found = s.policyExpressions(path, content, doc, read)
+		} else {
+			found = s.kubernetesExpressions(path, content, doc, read)
+		}
+		for _, sel := range selectors {
+			found = append(found, sel.expressions(content, doc)...)
+		}
+		for _, e := range found {
+			if !starts[e.offsets[0]] {
+				starts[e.offsets[0]] = true
+				exprs = append(exprs, e)
+			}
+		}
+	}
+	return exprs
+}
+
+// yamlDocuments parses each of the documents in a YAML stream, stopping at
+// the first that can't be parsed.
+func yamlDocuments(content string) []*yaml.Node {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			return docs
+		}
+		if len(doc.Content) > 0 {
+			docs = append(docs, doc.Content[0])
+		}
+	}
+}
+
+// yamlField returns the value of the field key of the mapping n, or nil if n
+// isn't a mapping or has no such field.
+func yamlField(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlPath returns the node at the path of fields beneath n, or nil.
+func yamlPath(n *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		n = yamlField(n, key)
+	}
+	return n
+}
+
+// yamlItems returns the elements of n, or nil if it isn't a sequence.
+func yamlItems(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+// isYAMLString reports whether n is a string scalar.
+func isYAMLString(n *yaml.Node) bool {
+	return n != nil && n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str"
+}
+
+// yamlOffset returns the byte offset in content of the 1-based line and
+// column, counted in characters, of a node.
+func yamlOffset(content string, line, column int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		i := strings.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return len(content)
+		}
+		offset += i + 1
+	}
+	for ; column > 1 && offset < len(content); column-- {
+		_, size := utf8.DecodeRuneInString(content[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// yamlScalar returns the value of the scalar n in content, and the byte
+// offset in content of the source of each of its bytes and its end. Escape
+// sequences, folded lines, and the indentation of block scalars are mapped
+// back to where they were written. If the source can't be matched with the
+// value, every offset is that of the node.
+func yamlScalar(content string, n *yaml.Node) (string, []int) {
+	start := yamlOffset(content, n.Line, n.Column)
+	sc := &scalarScanner{content: content, want: n.Value}
+	switch {
+	case n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		sc.block(start, n.Style&yaml.FoldedStyle != 0)
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		sc.flow(start+1, '"')
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		sc.flow(start+1, '\'')
+	default:
+		sc.flow(start, 0)
+	}
+	if string(sc.value) != n.Value {
+		offsets := make([]int, len(n.Value)+1)
+		for i := range offsets {
+			offsets[i] = start
+		}
+		return n.Value, offsets
+	}
+	return n.Value, append(sc.offsets, sc.end)
+}
+
+// scalarScanner rescans the source of a scalar, building its value along
+// with the offset of each byte.
+type scalarScanner struct {
+	content string
+	// want is the value the parser produced, which determines where plain
+	// and block scalars end.
+	want    string
+	value   []byte
+	offsets []int
+	// trailing counts the literal spaces and tabs at the end of value,
+	// which are trimmed when a line is folded.
+	trailing int
+	end      int
+}
+
+func (sc *scalarScanner) emit(offset int, b ...byte) {
+	sc.value = append(sc.value, b...)
+	for range b {
+		sc.offsets = append(sc.offsets, offset)
+	}
+	sc.trailing = 0
+}
+
+// flow scans a quoted scalar starting after its opening quote, or a plain
+// scalar if quote is 0.
+func (sc *scalarScanner) flow(i int, quote byte) {
+	c := sc.content
+	for i < len(c) {
+		if quote == 0 && len(sc.value) >= len(sc.want) && string(sc.value) == sc.want {
+			break
+		}
+		switch {
+		case quote != 0 && c[i] == quote:
+			if quote == '\'' && i+1 < len(c) && c[i+1] == '\'' {
+				sc.emit(i, '\'')
+				i += 2
+				continue
+			}
+			sc.end = i
+			return
+		case quote == '"' && c[i] == '\\' && i+1 < len(c):
+			i = sc.escape(i)
+		case c[i] == '\n' || c[i] == '\r':
+			i = sc.fold(i)
+		case c[i] == ' ' || c[i] == '\t':
+			sc.emit(i, c[i])
+			sc.trailing++
+			i++
+		default:
+			sc.emit(i, c[i])
+			i++
+		}
+	}
+	sc.end = i
+}
+
+// fold folds the line break at i, returning the offset of the first
+// character of the next non-empty line. A single break becomes a space, and
+// each following empty line a newline.
+func (sc *scalarScanner) fold(i int) int {
+	sc.value = sc.value[:len(sc.value)-sc.trailing]
+	sc.offsets = sc.offsets[:len(sc.offsets)-sc.trailing]
+	at := i
+	var breaks []int
+	for {
+		if sc.content[i] == '\r' && i+1 < len(sc.content) && sc.content[i+1] == '\n' {
+			i++
+		}
+		i++
+		for i < len(sc.content) && (sc.content[i] == ' ' || sc.content[i] == '\t') {
+			i++
+		}
+		if i >= len(sc.content) || (sc.content[i] != '\n' && sc.content[i] != '\r') {
+			break
+		}
+		breaks = append(breaks, i)
+	}
+	if len(breaks) == 0 {
+		sc.emit(at, ' ')
+	}
+	for _, b := range breaks {
+		sc.emit(b, '\n')
+	}
+	return i
+}
+
+// yamlEscapes are the values of the escape sequences of double-quoted
+// scalars, other than those of line breaks and character codes.
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"",
+	'/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028",
+	'P': "\u2029",
+}
+
+// escape decodes the escape sequence at i in a double-quoted scalar,
+// returning the offset after it.
+func (sc *scalarScanner) escape(i int) int {
+	c := sc.content
+	e := c[i+1]
+	if s, ok := yamlEscapes[e]; ok {
+		sc.emit(i, []byte(s)...)
+		return i + 2
+	}
+	if e == '\n' || e == '\r' {
+		// An escaped line break joins the lines without a space.
+		j := i + 2
+		if e == '\r' && j < len(c) && c[j] == '\n' {
+			j++
+		}
+		for j < len(c) && (c[j] == ' ' || c[j] == '\t') {
+			j++
+		}
+		sc.trailing = 0
+		return j
+	}
+	size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+	if size > 0 && i+2+size <= len(c) {
+		if r, err := strconv.ParseUint(c[i+2:i+2+size], 16, 32); err == nil {
+			sc.emit(i, utf8.AppendRune(nil, rune(r))...)
+			return i + 2 + size
+		}
+	}
+	sc.emit(i, c[i])
+	return i + 1
+}
+
+// blockLine is a line of a block scalar.
+type blockLine struct {
+	// start and end are the offsets of the line's content, after its
+	// indentation, and of its line break.
+	start, end int
+	blank      bool
+	// more is set for lines indented beyond the block's indentation, which
+	// aren't folded.
+	more bool
+}
+
+// block scans a literal or folded block scalar whose indicator is at i.
+func (sc *scalarScanner) block(i int, folded bool) {
+	c := sc.content
+	lineStart := strings.LastIndexByte(c[:i], '\n') + 1
+	parentIndent := 0
+	for parentIndent < i-lineStart && c[lineStart+parentIndent] == ' ' {
+		parentIndent++
+	}
+	indent := 0
+	for j := i + 1; j < len(c) && c[j] != ' ' && c[j] != '\n' && c[j] != '#'; j++ {
+		if '1' <= c[j] && c[j] <= '9' {
+			indent = parentIndent + int(c[j]-'0')
+		}
+	}
+	next := strings.IndexByte(c[i:], '\n')
+	if next < 0 {
+		sc.end = len(c)
+		return
+	}
+
+	var lines []blockLine
+	for pos := i + next + 1; pos < len(c); {
+		end := strings.IndexByte(c[pos:], '\n')
+		if end < 0 {
+			end = len(c)
+		} else {
+			end += pos
+		}
+		spaces := 0
+		for pos+spaces < end && c[pos+spaces] == ' ' {
+			spaces++
+		}
+		blank := strings.TrimRight(c[pos:end], " \r") == ""
+		if !blank && indent == 0 {
+			indent = spaces
+		}
+		if !blank && spaces < indent {
+			break
+		}
+		line := blockLine{start: min(pos+indent, end), end: end, blank: blank}
+		if !blank {
+			line.more = c[line.start] == ' ' || c[line.start] == '\t'
+		}
+		lines = append(lines, line)
+		pos = end + 1
+	}
+
+	var prev *blockLine
+	var blanks []int
+	for k := range lines {
+		line := &lines[k]
+		if line.blank {
+			blanks = append(blanks, line.end)
+			continue
+		}
+		if prev != nil {
+			switch {
+			case folded && !prev.more && !line.more && len(blanks) == 0:
+				sc.emit(prev.end, ' ')
+			case folded && !prev.more && !line.more:
+			default:
+				sc.emit(prev.end, '\n')
+			}
+		}
+		for _, b := range blanks {
+			sc.emit(b, '\n')
+		}
+		blanks = nil
+		for j := line.start; j < line.end && c[j] != '\r'; j++ {
+			sc.emit(j, c[j])
+		}
+		prev = line
+	}
+	if prev != nil {
+		sc.emit(prev.end, '\n')
+	}
+	for _, b := range blanks {
+		sc.emit(b, '\n')
+	}
+
+	// The parser applied the block's chomping, which only removes line
+	// breaks from the end.
+	if len(sc.value) > len(sc.want) {
+		sc.value = sc.value[:len(sc.want)]
+		sc.offsets = sc.offsets[:len(sc.want)]
+	}
+	sc.end = i
+	if len(sc.offsets) > 0 {
+		sc.end = sc.offsets[len(sc.offsets)-1] + 1
+	}
+}
@@ -0,0 +1,272 @@
+// Package report writes the diagnostics found by cells check, in formats for
+// people and for CI systems.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+)
+
+// Formats are the names of the formats Write supports.
+var Formats = []string{"text", "json", "sarif", "github"}
+
+// Write writes the diagnostics of reports to w in the named format.
+func Write(w io.Writer, format string, reports []lsp.Report) error {
+	switch format {
+	case "text":
+		return Text(w, reports)
+	case "json":
+		return JSON(w, reports)
+	case "sarif":
+		return SARIF(w, reports)
+	case "github":
+		return GitHub(w, reports)
+	}
+	return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Failed reports whether any of the diagnostics of reports is an error or a
+// warning, which type-check failures are reported as.
+func Failed(reports []lsp.Report) bool {
+	for _, r := range reports {
+		for _, d := range r.Diagnostics {
+			if d.Severity == protocol.SeverityError || d.Severity == protocol.SeverityWarning {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Text writes each diagnostic in the style of a compiler, with an excerpt of
+// the line it's on and carets under its range:
+//
+//	limits.cel:2:3: error: undeclared reference to 'x'
+//	  2 | x + 1
+//	    | ^
+func Text(w io.Writer, reports []lsp.Report) error {
+	for _, r := range reports {
+		for _, d := range r.Diagnostics {
+			start := position(r.Content, d.Range.Start)
+			if _, err := fmt.Fprintf(w, "%s:%d:%d: %s: %s\n", r.Path, start.line, start.column, severity(d.Severity), d.Message); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, excerpt(r.Content, d.Range)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// excerpt returns the line of content that r starts on, numbered, followed
+// by carets under the part of it that r covers.
+func excerpt(content string, r protocol.Range) string {
+	start, end := position(content, r.Start), position(content, r.End)
+	text := strings.TrimRight(start.text, "\r")
+	width := utf8.RuneCountInString(text) + 1
+	if end.line == start.line {
+		width = end.column
+	}
+	carets := max(width-start.column, 1)
+
+	var indent strings.Builder
+	for i, ch := range []rune(text) {
+		if i+1 >= start.column {
+			break
+		}
+		if ch == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+	number := fmt.Sprint(start.line)
+	gutter := strings.Repeat(" ", len(number))
+	return fmt.Sprintf("  %s | %s\n  %s | %s%s\n", number, text, gutter, indent.String(), strings.Repeat("^", carets))
+}
+
+// jsonDiagnostic is a diagnostic in the JSON format, with 1-based lines and
+// columns.
+type jsonDiagnostic struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+// JSON writes the diagnostics as a JSON array of objects, with 1-based lines
+// and columns.
+func JSON(w io.Writer, reports []lsp.Report) error {
+	diagnostics := []jsonDiagnostic{}
+	for _, r := range reports {
+		for _, d := range r.Diagnostics {
+			start, end := position(r.Content, d.Range.Start), position(r.Content, d.Range.End)
+			diagnostics = append(diagnostics, jsonDiagnostic{
+				Path:      r.Path,
+				Line:      start.line,
+				Column:    start.column,
+				EndLine:   end.line,
+				EndColumn: end.column,
+				Severity:  severity(d.Severity),
+				Message:   d.Message,
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diagnostics)
+}
+
+// SARIF writes the diagnostics as a SARIF 2.1.0 log, as code scanning tools
+// such as GitHub's read.
+func SARIF(w io.Writer, reports []lsp.Report) error {
+	type (
+		message struct {
+			Text string `json:"text"`
+		}
+		region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn"`
+			EndLine     int `json:"endLine"`
+			EndColumn   int `json:"endColumn"`
+		}
+		artifact struct {
+			URI string `json:"uri"`
+		}
+		physical struct {
+			ArtifactLocation artifact `json:"artifactLocation"`
+			Region           region   `json:"region"`
+		}
+		location struct {
+			PhysicalLocation physical `json:"physicalLocation"`
+		}
+		result struct {
+			Level     string     `json:"level"`
+			Message   message    `json:"message"`
+			Locations []location `json:"locations"`
+		}
+	)
+	results := []result{}
+	for _, r := range reports {
+		for _, d := range r.Diagnostics {
+			level := "note"
+			switch d.Severity {
+			case protocol.SeverityError:
+				level = "error"
+			case protocol.SeverityWarning:
+				level = "warning"
+			}
+			// SARIF's columns count UTF-16 code units by default, as LSP's
+			// do.
+			results = append(results, result{
+				Level:   level,
+				Message: message{Text: d.Message},
+				Locations: []location{{PhysicalLocation: physical{
+					ArtifactLocation: artifact{URI: filepath.ToSlash(r.Path)},
+					Region: region{
+						StartLine:   int(d.Range.Start.Line) + 1,
+						StartColumn: int(d.Range.Start.Character) + 1,
+						EndLine:     int(d.Range.End.Line) + 1,
+						EndColumn:   int(d.Range.End.Character) + 1,
+					},
+				}}},
+			})
+		}
+	}
+	log := map[string]any{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":           "cells",
+				"informationUri": "https://github.com/stefanvanburen/cells",
+			}},
+			"results": results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// GitHub writes the diagnostics as GitHub Actions workflow commands, which
+// annotate the lines they're on.
+func GitHub(w io.Writer, reports []lsp.Report) error {
+	for _, r := range reports {
+		for _, d := range r.Diagnostics {
+			command := "notice"
+			switch d.Severity {
+			case protocol.SeverityError:
+				command = "error"
+			case protocol.SeverityWarning:
+				command = "warning"
+			}
+			start, end := position(r.Content, d.Range.Start), position(r.Content, d.Range.End)
+			_, err := fmt.Fprintf(w, "::%s file=%s,line=%d,col=%d,endLine=%d,endColumn=%d::%s\n",
+				command, escapeProperty(filepath.ToSlash(r.Path)), start.line, start.column, end.line, end.column, escapeData(d.Message))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// escapeData escapes s for the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes s for a property of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// severity returns the name of the severity s.
+func severity(s protocol.DiagnosticSeverity) string {
+	switch s {
+	case protocol.SeverityError:
+		return "error"
+	case protocol.SeverityWarning:
+		return "warning"
+	case protocol.SeverityInformation:
+		return "info"
+	}
+	return "hint"
+}
+
+// linePosition is a position in a file, with a 1-based line and a 1-based
+// column counted in characters, and the text of its line.
+type linePosition struct {
+	line, column int
+	text         string
+}
+
+// position returns the position in content of pos, whose character is
+// counted in UTF-16 code units.
+func position(content string, pos protocol.Position) linePosition {
+	lines := strings.Split(content, "\n")
+	line := min(int(pos.Line), len(lines)-1)
+	text := lines[line]
+	column, units := 1, 0
+	for _, ch := range text {
+		if units >= int(pos.Character) {
+			break
+		}
+		units += utf16.RuneLen(ch)
+		column++
+	}
+	return linePosition{line: line + 1, column: column, text: text}
+}
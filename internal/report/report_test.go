@@ -0,0 +1,141 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/lsp"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	"github.com/stefanvanburen/cells/internal/report"
+)
+
+// reports are the diagnostics of a file with an error after a string with a
+// multibyte character, and a warning on an indented line.
+var reports = []lsp.Report{{
+	Path:    "rules/limits.cel",
+	Content: "size('héllo') + 'a' &&\n\tlimit > 1\n",
+	Diagnostics: []protocol.Diagnostic{
+		{
+			Range:    protocol.Range{Start: protocol.Position{Line: 0, Character: 14}, End: protocol.Position{Line: 0, Character: 15}},
+			Severity: protocol.SeverityError,
+			Message:  "found no matching overload for '_+_'",
+		},
+		{
+			Range:    protocol.Range{Start: protocol.Position{Line: 1, Character: 1}, End: protocol.Position{Line: 1, Character: 6}},
+			Severity: protocol.SeverityWarning,
+			Message:  "undeclared reference to 'limit'",
+		},
+	},
+}}
+
+func TestText(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	be.Err(t, report.Write(&buf, "text", reports), nil)
+	be.Equal(t, buf.String(), `rules/limits.cel:1:15: error: found no matching overload for '_+_'
+  1 | size('héllo') + 'a' &&
+    |               ^
+rules/limits.cel:2:2: warning: undeclared reference to 'limit'
+  2 | 	limit > 1
+    | 	^^^^^
+`)
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	be.Err(t, report.Write(&buf, "json", reports), nil)
+	var got []map[string]any
+	be.Err(t, json.Unmarshal(buf.Bytes(), &got), nil)
+	be.Equal(t, len(got), 2)
+	be.Equal(t, got[0], map[string]any{
+		"path":      "rules/limits.cel",
+		"line":      float64(1),
+		"column":    float64(15),
+		"endLine":   float64(1),
+		"endColumn": float64(16),
+		"severity":  "error",
+		"message":   "found no matching overload for '_+_'",
+	})
+
+	// Without diagnostics, the array is empty.
+	buf.Reset()
+	be.Err(t, report.Write(&buf, "json", nil), nil)
+	be.Equal(t, strings.TrimSpace(buf.String()), "[]")
+}
+
+func TestSARIF(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	be.Err(t, report.Write(&buf, "sarif", reports), nil)
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name string `json:"name"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region map[string]int `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	be.Err(t, json.Unmarshal(buf.Bytes(), &log), nil)
+	be.Equal(t, log.Version, "2.1.0")
+	be.Equal(t, log.Runs[0].Tool.Driver.Name, "cells")
+	results := log.Runs[0].Results
+	be.Equal(t, len(results), 2)
+	be.Equal(t, results[0].Level, "error")
+	be.Equal(t, results[1].Level, "warning")
+	location := results[1].Locations[0].PhysicalLocation
+	be.Equal(t, location.ArtifactLocation.URI, "rules/limits.cel")
+	be.Equal(t, location.Region, map[string]int{"startLine": 2, "startColumn": 2, "endLine": 2, "endColumn": 7})
+}
+
+func TestGitHub(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	be.Err(t, report.Write(&buf, "github", []lsp.Report{{
+		Path:    "a,b.cel",
+		Content: "x\n",
+		Diagnostics: []protocol.Diagnostic{{
+			Range:    protocol.Range{End: protocol.Position{Character: 1}},
+			Severity: protocol.SeverityError,
+			Message:  "100% wrong\nreally",
+		}},
+	}}), nil)
+	be.Equal(t, buf.String(), "::error file=a%2Cb.cel,line=1,col=1,endLine=1,endColumn=2::100%25 wrong%0Areally\n")
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	err := report.Write(&bytes.Buffer{}, "xml", reports)
+	be.True(t, err != nil)
+}
+
+func TestFailed(t *testing.T) {
+	t.Parallel()
+
+	be.True(t, report.Failed(reports))
+	be.True(t, !report.Failed(nil))
+	be.True(t, !report.Failed([]lsp.Report{{
+		Diagnostics: []protocol.Diagnostic{{Severity: protocol.SeverityInformation}},
+	}}))
+}
@@ -0,0 +1,58 @@
+// Package server runs the cells language server, for programs that extend its
+// CEL environment with their own declarations.
+//
+// For example, a binary that links in a proprietary function library:
+//
+//	func main() {
+//		err := server.Serve(context.Background(), server.Stdio(),
+//			server.WithEnvOptions(mylib.CELLibrary()),
+//		)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stefanvanburen/cells/internal/lsp"
+)
+
+// Option configures the server.
+type Option func(*options)
+
+type options struct {
+	envOptions []cel.EnvOption
+}
+
+// WithEnvOptions adds options to every CEL environment the server creates,
+// such as declarations of custom functions, variables, and types. Functions
+// with implementations are used when evaluating expressions for inlay hints.
+//
+// The options are applied after the standard library, and before any options
+// from .cells.yaml config files.
+func WithEnvOptions(opts ...cel.EnvOption) Option {
+	return func(o *options) {
+		o.envOptions = append(o.envOptions, opts...)
+	}
+}
+
+// Serve runs the language server over rwc, speaking the Language Server
+// Protocol. It blocks until the connection is closed, returning nil, or
+// until ctx is cancelled, in which case it closes rwc and returns ctx.Err().
+func Serve(ctx context.Context, rwc io.ReadWriteCloser, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return lsp.ServeStream(ctx, rwc, lsp.WithEnvOptions(o.envOptions...))
+}
+
+// Stdio returns a stream reading from stdin and writing to stdout, which is
+// how editors usually communicate with language servers.
+func Stdio() io.ReadWriteCloser {
+	return lsp.Stdio()
+}
@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/nalgeon/be"
+	"github.com/stefanvanburen/cells/internal/jsonrpc2"
+	"github.com/stefanvanburen/cells/internal/lsp/protocol"
+	"github.com/stefanvanburen/cells/server"
+)
+
+// greet is a custom function, as a team might declare in its own library.
+var greet = cel.Function("greet",
+	cel.FunctionDocs("Returns a greeting for the given name."),
+	cel.Overload("greet_string", []*cel.Type{cel.StringType}, cel.StringType,
+		cel.UnaryBinding(func(name ref.Val) ref.Val {
+			return types.String("hello, " + string(name.(types.String)))
+		}),
+	),
+)
+
+func TestServeWithEnvOptions(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	})
+	go func() {
+		_ = server.Serve(ctx, serverConn, server.WithEnvOptions(greet))
+	}()
+
+	noop := jsonrpc2.HandlerFunc(func(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (any, error) {
+		return nil, nil
+	})
+	client := jsonrpc2.NewConn(ctx, clientConn, noop)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	var initResult protocol.InitializeResult
+	be.Err(t, client.Call(ctx, "initialize", protocol.InitializeParams{}, &initResult), nil)
+
+	uri := protocol.URIFromPath(t.TempDir() + "/greet.cel")
+	err := client.Notify(ctx, "textDocument/didOpen", protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			URI:        uri,
+			LanguageID: "cel",
+			Version:    1,
+			Text:       `greet("world")`,
+		},
+	})
+	be.Err(t, err, nil)
+
+	var report protocol.FullDocumentDiagnosticReport
+	err = client.Call(ctx, "textDocument/diagnostic", protocol.DocumentDiagnosticParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	}, &report)
+	be.Err(t, err, nil)
+	be.Equal(t, len(report.Items), 0)
+
+	var hover *protocol.Hover
+	err = client.Call(ctx, "textDocument/hover", protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 0, Character: 1},
+		},
+	}, &hover)
+	be.Err(t, err, nil)
+	be.True(t, hover != nil)
+	be.True(t, strings.Contains(hover.Contents.Value, "Returns a greeting for the given name."))
+
+	// The function's implementation is used for evaluation.
+	var hints []protocol.InlayHint
+	err = client.Call(ctx, "textDocument/inlayHint", protocol.InlayHintParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range: protocol.Range{
+			End: protocol.Position{Line: 1},
+		},
+	}, &hints)
+	be.Err(t, err, nil)
+	be.Equal(t, len(hints), 1)
+	be.True(t, strings.Contains(hints[0].Label[0].Value, "hello, world"))
+}
+
+func TestServeCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+	})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Serve(ctx, serverConn)
+	}()
+
+	cancel()
+	be.Err(t, <-errc, context.Canceled)
+}